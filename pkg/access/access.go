@@ -0,0 +1,147 @@
+package access
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rules are "channel:<id>", "playlist:<id>", "user:<name>" or
+// "url:<regexp>". Deny wins, and allow rules deny what they don't match.
+type Config struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// What a request reflects. Unknown fields are empty.
+type Subject struct {
+	ChannelID  string
+	PlaylistID string
+	User       string
+	URL        string // Upstream feed or content url
+}
+
+type List struct {
+	allow []rule
+	deny  []rule
+}
+
+type rule struct {
+	kind  string // channel, playlist, user or url
+	value string
+	re    *regexp.Regexp // For url rules
+}
+
+func (c Config) Compile() (*List, error) {
+	l := &List{}
+	for _, spec := range c.Allow {
+		r, err := parseRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		l.allow = append(l.allow, r)
+	}
+	for _, spec := range c.Deny {
+		r, err := parseRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		l.deny = append(l.deny, r)
+	}
+	return l, nil
+}
+
+func parseRule(spec string) (rule, error) {
+	i := strings.Index(spec, ":")
+	if i <= 0 || spec[i+1:] == "" {
+		return rule{}, fmt.Errorf("bad access rule %q", spec)
+	}
+	r := rule{kind: spec[:i], value: spec[i+1:]}
+	switch r.kind {
+	case "channel", "playlist", "user":
+	case "url":
+		re, err := regexp.Compile(r.value)
+		if err != nil {
+			return rule{}, fmt.Errorf("access rule %q: %w", spec, err)
+		}
+		r.re = re
+	default:
+		return rule{}, fmt.Errorf("bad access rule %q", spec)
+	}
+	return r, nil
+}
+
+// Reports whether the subject's channel could change whether it is allowed.
+func (l *List) NeedsChannel(s Subject) bool {
+	if l == nil {
+		return false
+	}
+	for _, r := range l.deny {
+		if r.kind == "channel" {
+			return true
+		}
+	}
+	if len(l.allow) == 0 {
+		return false
+	}
+	for _, r := range l.allow {
+		if r.kind != "channel" && r.matches(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// Nil if the subject may be reflected, otherwise why not.
+func (l *List) Check(s Subject) error {
+	if l == nil {
+		return nil
+	}
+	for _, r := range l.deny {
+		if r.matches(s) {
+			return fmt.Errorf("%s is denied", r.describe(s))
+		}
+	}
+	if len(l.allow) == 0 {
+		return nil
+	}
+	for _, r := range l.allow {
+		if r.matches(s) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not allowed", s.describe())
+}
+
+func (r rule) matches(s Subject) bool {
+	switch r.kind {
+	case "channel":
+		return s.ChannelID != "" && s.ChannelID == r.value
+	case "playlist":
+		return s.PlaylistID != "" && s.PlaylistID == r.value
+	case "user":
+		return s.User != "" && strings.EqualFold(s.User, r.value)
+	case "url":
+		return s.URL != "" && r.re.MatchString(s.URL)
+	}
+	return false
+}
+
+func (r rule) describe(s Subject) string {
+	if r.kind == "url" {
+		return "url " + s.URL
+	}
+	return r.kind + " " + r.value
+}
+
+func (s Subject) describe() string {
+	switch {
+	case s.ChannelID != "":
+		return "channel " + s.ChannelID
+	case s.PlaylistID != "":
+		return "playlist " + s.PlaylistID
+	case s.User != "":
+		return "user " + s.User
+	}
+	return "url " + s.URL
+}
@@ -0,0 +1,79 @@
+package access
+
+import "testing"
+
+func compile(t *testing.T, c Config) *List {
+	l, err := c.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   *Config
+		sub   Subject
+		allow bool
+	}{
+		{name: "no list", sub: Subject{ChannelID: "UC1"}, allow: true},
+		{name: "no rules", cfg: &Config{}, sub: Subject{ChannelID: "UC1"}, allow: true},
+		{name: "allowed channel", cfg: &Config{Allow: []string{"channel:UC1"}}, sub: Subject{ChannelID: "UC1"}, allow: true},
+		{name: "other channel", cfg: &Config{Allow: []string{"channel:UC1"}}, sub: Subject{ChannelID: "UC2"}},
+		{name: "unknown channel", cfg: &Config{Allow: []string{"channel:UC1"}}, sub: Subject{URL: "https://www.youtube.com/watch?v=x"}},
+		{name: "allowed playlist", cfg: &Config{Allow: []string{"playlist:PL1"}}, sub: Subject{ChannelID: "UC2", PlaylistID: "PL1"}, allow: true},
+		{name: "user case", cfg: &Config{Allow: []string{"user:Someone"}}, sub: Subject{User: "someone"}, allow: true},
+		{name: "url", cfg: &Config{Allow: []string{`url:^https://example\.com/`}}, sub: Subject{URL: "https://example.com/feed.xml"}, allow: true},
+		{name: "url unanchored", cfg: &Config{Allow: []string{`url:^https://example\.com/`}}, sub: Subject{URL: "https://evil.com/?https://example.com/"}},
+		{name: "deny wins", cfg: &Config{Allow: []string{"playlist:PL1"}, Deny: []string{"channel:UC2"}}, sub: Subject{ChannelID: "UC2", PlaylistID: "PL1"}},
+		{name: "deny only", cfg: &Config{Deny: []string{"channel:UC2"}}, sub: Subject{ChannelID: "UC1"}, allow: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l *List
+			if tt.cfg != nil {
+				l = compile(t, *tt.cfg)
+			}
+			if err := l.Check(tt.sub); (err == nil) != tt.allow {
+				t.Errorf("got %v, want allowed %v", err, tt.allow)
+			}
+		})
+	}
+}
+
+func TestNeedsChannel(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *Config
+		sub  Subject
+		want bool
+	}{
+		{name: "no list"},
+		{name: "url rules", cfg: &Config{Allow: []string{"url:x"}, Deny: []string{"playlist:PL2"}}, want: true},
+		{name: "deny only", cfg: &Config{Deny: []string{"playlist:PL2"}}},
+		{name: "channel deny", cfg: &Config{Deny: []string{"channel:UC2"}}, sub: Subject{PlaylistID: "PL1"}, want: true},
+		{name: "allowed by playlist", cfg: &Config{Allow: []string{"channel:UC1", "playlist:PL1"}}, sub: Subject{PlaylistID: "PL1"}},
+		{name: "allowed by user", cfg: &Config{Allow: []string{"user:someone"}}, sub: Subject{User: "someone"}},
+		{name: "other playlist", cfg: &Config{Allow: []string{"channel:UC1", "playlist:PL1"}}, sub: Subject{PlaylistID: "PL2"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l *List
+			if tt.cfg != nil {
+				l = compile(t, *tt.cfg)
+			}
+			if got := l.NeedsChannel(tt.sub); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileBadRules(t *testing.T) {
+	for _, spec := range []string{"channel", "channel:", ":x", "video:x", "url:("} {
+		if _, err := (Config{Allow: []string{spec}}).Compile(); err == nil {
+			t.Errorf("%q compiled", spec)
+		}
+	}
+}
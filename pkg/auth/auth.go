@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nlsun/rss-reflector/pkg/log"
+	"github.com/nlsun/rss-reflector/pkg/util"
+)
+
+var logger = log.DefaultLogger
+
+// Bytes of randomness in a token secret.
+const secretLen = 16
+
+// Grants access to whoever knows the secret.
+type Token struct {
+	Name    string    `json:"name"`
+	Secret  string    `json:"secret"`
+	Created time.Time `json:"created"`
+}
+
+// Tokens persisted as a JSON file, reloaded when another process changes it.
+type Store struct {
+	mu     sync.Mutex
+	file   jsonFile
+	tokens []Token
+}
+
+func NewStore(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), util.DefaultDirPerm); err != nil {
+		return nil, err
+	}
+	s := &Store{file: jsonFile{path: path}}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Creates a token with a new random secret. Names are unique.
+func (s *Store) Create(name string) (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if name == "" {
+		return Token{}, fmt.Errorf("empty token name")
+	}
+	if err := s.reload(); err != nil {
+		return Token{}, err
+	}
+	for _, t := range s.tokens {
+		if t.Name == name {
+			return Token{}, fmt.Errorf("token %q exists", name)
+		}
+	}
+	b := make([]byte, secretLen)
+	if _, err := rand.Read(b); err != nil {
+		return Token{}, err
+	}
+	t := Token{Name: name, Secret: hex.EncodeToString(b), Created: time.Now().UTC()}
+	s.tokens = append(s.tokens, t)
+	return t, s.write()
+}
+
+// Returns false if there was no such token.
+func (s *Store) Revoke(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return false, err
+	}
+	for i, t := range s.tokens {
+		if t.Name == name {
+			s.tokens = append(s.tokens[:i], s.tokens[i+1:]...)
+			return true, s.write()
+		}
+	}
+	return false, nil
+}
+
+// Tokens sorted by name.
+func (s *Store) List() ([]Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	tokens := append([]Token{}, s.tokens...)
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Name < tokens[j].Name })
+	return tokens, nil
+}
+
+// Name of the token with the given secret, compared in constant time.
+func (s *Store) Check(secret string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		// Keep going with what was read before.
+		logger.Print(err)
+	}
+	name, found := "", false
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Secret), []byte(secret)) == 1 {
+			name, found = t.Name, true
+		}
+	}
+	return name, found && secret != ""
+}
+
+// Must be called with the lock held.
+func (s *Store) reload() error {
+	var tokens []Token
+	if changed, err := s.file.load(&tokens); err != nil || !changed {
+		return err
+	}
+	s.tokens = tokens
+	return nil
+}
+
+// Must be called with the lock held.
+func (s *Store) write() error {
+	return s.file.save(s.tokens)
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/nlsun/rss-reflector/pkg/util"
+)
+
+// A JSON file of secrets that other processes may change.
+type jsonFile struct {
+	path string
+	// Of the file when it was last read or written.
+	info os.FileInfo
+}
+
+// Reads the file into v if it changed since the last load or save.
+func (f *jsonFile) load(v interface{}) (bool, error) {
+	fi, err := os.Stat(f.path)
+	if os.IsNotExist(err) {
+		changed := f.info != nil
+		f.info = nil
+		return changed, nil
+	} else if err != nil {
+		return false, err
+	}
+	if f.info != nil && os.SameFile(fi, f.info) && fi.ModTime().Equal(f.info.ModTime()) {
+		return false, nil
+	}
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return false, fmt.Errorf("%s: %w", f.path, err)
+	}
+	f.info = fi
+	return true, nil
+}
+
+// Written next to the file and moved into place.
+func (f *jsonFile) save(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, util.PrivateFilePerm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		return err
+	}
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	f.info = fi
+	return nil
+}
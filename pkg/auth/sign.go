@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nlsun/rss-reflector/pkg/util"
+)
+
+// Query parameters of a signed url.
+const (
+	SigParam     string = "sig"
+	ExpiresParam string = "exp" // Unix time, absent for urls that don't expire
+	KeyIDParam   string = "kid"
+)
+
+var (
+	ErrUnsigned = errors.New("unsigned url")
+	ErrBadSig   = errors.New("bad url signature")
+	ErrExpired  = errors.New("expired url")
+)
+
+// A signing key. Only the newest one signs, older ones still verify.
+type Key struct {
+	ID      string    `json:"id"`
+	Secret  string    `json:"secret"`
+	Created time.Time `json:"created"`
+}
+
+// Signs urls with keys persisted like the token store.
+type Signer struct {
+	mu   sync.Mutex
+	file jsonFile
+	keys []Key // Newest first
+}
+
+// Creates the first key if there is none.
+func NewSigner(path string) (*Signer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), util.DefaultDirPerm); err != nil {
+		return nil, err
+	}
+	s := &Signer{file: jsonFile{path: path}}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	if len(s.keys) == 0 {
+		if _, err := s.rotate(1); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Starts signing with a new key and keeps at most keep keys.
+func (s *Signer) Rotate(keep int) (Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return Key{}, err
+	}
+	return s.rotate(keep)
+}
+
+// Must be called with the lock held.
+func (s *Signer) rotate(keep int) (Key, error) {
+	b := make([]byte, secretLen)
+	if _, err := rand.Read(b); err != nil {
+		return Key{}, err
+	}
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return Key{}, err
+	}
+	k := Key{ID: hex.EncodeToString(id), Secret: hex.EncodeToString(b), Created: time.Now().UTC()}
+	s.keys = append([]Key{k}, s.keys...)
+	if keep > 0 && len(s.keys) > keep {
+		s.keys = s.keys[:keep]
+	}
+	return k, s.file.save(s.keys)
+}
+
+// Signature parameters for the request described by fields.
+func (s *Signer) Sign(fields []string, expires time.Time) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		logger.Print(err)
+	}
+	if len(s.keys) == 0 {
+		return nil, fmt.Errorf("no signing key")
+	}
+	exp := ""
+	if !expires.IsZero() {
+		exp = strconv.FormatInt(expires.Unix(), 10)
+	}
+	k := s.keys[0]
+	params := map[string]string{
+		KeyIDParam: k.ID,
+		SigParam:   mac(k, fields, exp),
+	}
+	if exp != "" {
+		params[ExpiresParam] = exp
+	}
+	return params, nil
+}
+
+// Checks the signature parameters of a request described by fields.
+func (s *Signer) Verify(fields []string, get func(string) string, now time.Time) error {
+	sig, exp, kid := get(SigParam), get(ExpiresParam), get(KeyIDParam)
+	if sig == "" {
+		return ErrUnsigned
+	}
+	if exp != "" {
+		unix, err := strconv.ParseInt(exp, 10, 64)
+		if err != nil {
+			return ErrBadSig
+		}
+		if now.After(time.Unix(unix, 0)) {
+			return ErrExpired
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		logger.Print(err)
+	}
+	for _, k := range s.keys {
+		if k.ID != kid {
+			continue
+		}
+		if hmac.Equal([]byte(sig), []byte(mac(k, fields, exp))) {
+			return nil
+		}
+	}
+	return ErrBadSig
+}
+
+// Must be called with the lock held.
+func (s *Signer) reload() error {
+	var keys []Key
+	if changed, err := s.file.load(&keys); err != nil || !changed {
+		return err
+	}
+	s.keys = keys
+	return nil
+}
+
+// Fields are length prefixed so different requests never sign the same bytes.
+func mac(k Key, fields []string, exp string) string {
+	h := hmac.New(sha256.New, []byte(k.Secret))
+	for _, f := range append(fields[:len(fields):len(fields)], exp) {
+		fmt.Fprintf(h, "%d:%s", len(f), f)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
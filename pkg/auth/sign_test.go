@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestSigner(t *testing.T) *Signer {
+	s, err := NewSigner(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func params(p map[string]string) func(string) string {
+	return func(k string) string { return p[k] }
+}
+
+func TestSignVerify(t *testing.T) {
+	s := newTestSigner(t)
+	now := time.Now()
+	fields := []string{"/content", "youtube", "https://www.youtube.com/watch?v=abc", "1", "Album", "", ""}
+	forever, err := s.Sign(fields, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiring, err := s.Sign(fields, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		fields []string
+		params map[string]string
+		now    time.Time
+		want   error
+	}{
+		{name: "valid", fields: fields, params: forever, now: now},
+		{name: "valid until it expires", fields: fields, params: expiring, now: now},
+		{name: "expired", fields: fields, params: expiring, now: now.Add(2 * time.Hour), want: ErrExpired},
+		{name: "unsigned", fields: fields, params: map[string]string{}, now: now, want: ErrUnsigned},
+		{name: "other request", fields: []string{"/content", "youtube", "https://www.youtube.com/watch?v=abc", "", "Album", "", ""}, params: forever, now: now, want: ErrBadSig},
+		{name: "fields run together", fields: []string{"/content", "youtube", "https://www.youtube.com/watch?v=abc1", "", "Album", "", ""}, params: forever, now: now, want: ErrBadSig},
+		{name: "expiry moved", fields: fields, params: map[string]string{SigParam: expiring[SigParam], KeyIDParam: expiring[KeyIDParam], ExpiresParam: "99999999999"}, now: now, want: ErrBadSig},
+		{name: "expiry dropped", fields: fields, params: map[string]string{SigParam: expiring[SigParam], KeyIDParam: expiring[KeyIDParam]}, now: now, want: ErrBadSig},
+		{name: "unknown key", fields: fields, params: map[string]string{SigParam: forever[SigParam], KeyIDParam: "nokey"}, now: now, want: ErrBadSig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.Verify(tt.fields, params(tt.params), tt.now); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignerRotation(t *testing.T) {
+	s := newTestSigner(t)
+	fields := []string{"youtube", "abc"}
+	old, err := s.Sign(fields, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another process, such as --rotate-signing-key, rotates the keys on
+	// disk and the running signer picks them up.
+	other, err := NewSigner(s.file.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Rotate(2); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := s.Sign(fields, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed[KeyIDParam] == old[KeyIDParam] {
+		t.Fatal("still signing with the old key")
+	}
+	if err := s.Verify(fields, params(old), time.Now()); err != nil {
+		t.Errorf("link signed before the rotation: %v", err)
+	}
+
+	// Kept keys only go back so far.
+	if _, err := other.Rotate(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Verify(fields, params(old), time.Now()); !errors.Is(err, ErrBadSig) {
+		t.Errorf("link signed with a dropped key: got %v, want %v", err, ErrBadSig)
+	}
+	if err := s.Verify(fields, params(signed), time.Now()); err != nil {
+		t.Errorf("link signed with a kept key: %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nlsun/rss-reflector/pkg/content"
+	"github.com/nlsun/rss-reflector/pkg/server"
+)
+
+// Commands by name. All of them take the same flags as serve.
+var commands = map[string]func(args []string) error{
+	"serve": serve,
+	"fetch": fetch,
+	"feed":  printFeed,
+	"cache": manageCache,
+	"check": check,
+}
+
+const commandsUsage = `
+Commands:
+  serve               Serve feeds and content, the default
+  fetch <url>...      Download into the cache and print where to
+  feed <url>          Print the reflected feed
+  cache ls            List the cached items, oldest first
+  cache rm <key>...   Remove cached items by key, url or video id
+  cache prune [age]   Keep the newest --max-data-count items, none older than age
+  cache verify        Report broken cache entries, --fix removes them
+  check               Validate the config and probe the downloaders
+`
+
+// Loads the options of a command other than serve. Logs go to stderr.
+func commandOptions(args []string, commandFlags func(fs *flag.FlagSet)) (*options, error) {
+	o, err := loadOptions(args, commandFlags)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	if err := setLogFile(o.logFile); err != nil {
+		return nil, fmt.Errorf("log file: %w", err)
+	}
+	if o.logFile == "" {
+		logger.SetOutput(os.Stderr)
+	}
+	return o, nil
+}
+
+// Canceled on SIGINT or SIGTERM.
+func interruptible() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigC:
+			logger.Printf("received %s, stopping", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigC)
+	}()
+	return ctx, cancel
+}
+
+func fetch(args []string) error {
+	o, err := commandOptions(args, nil)
+	if err != nil {
+		return err
+	}
+	if len(o.args) == 0 {
+		return fmt.Errorf("want urls to fetch")
+	}
+	cfg, err := o.serverConfig()
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	sv, err := server.NewFetchState(cfg)
+	if errors.Is(err, content.ErrCacheLocked) {
+		return fmt.Errorf("%w, fetch through the server's /content/ links instead", err)
+	} else if err != nil {
+		return err
+	}
+	defer sv.Close()
+	ctx, cancel := interruptible()
+	defer cancel()
+	for _, target := range o.args {
+		path, err := sv.Fetch(ctx, target)
+		if err != nil {
+			return fmt.Errorf("%s: %w", target, err)
+		}
+		fmt.Println(path)
+	}
+	return nil
+}
+
+func printFeed(args []string) error {
+	o, err := commandOptions(args, nil)
+	if err != nil {
+		return err
+	}
+	if len(o.args) != 1 {
+		return fmt.Errorf("want a feed url")
+	}
+	cfg, err := o.serverConfig()
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	sv, err := server.NewFeedState(cfg)
+	if err != nil {
+		return err
+	}
+	defer sv.Close()
+	ctx, cancel := interruptible()
+	defer cancel()
+	feed, err := sv.Feed(ctx, o.args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Print(feed)
+	return nil
+}
+
+// Changing the cache fails while a server runs on the data directory.
+func manageCache(args []string) error {
+	var fix bool
+	o, err := commandOptions(args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&fix, "fix", false, "Make cache verify remove the files it finds problems with")
+	})
+	if err != nil {
+		return err
+	}
+	if len(o.args) == 0 {
+		return fmt.Errorf("want ls, rm, prune or verify")
+	}
+	if fix && o.args[0] != "verify" {
+		return fmt.Errorf("--fix only applies to cache verify")
+	}
+	dir := server.FetcherPath(o.cfg.DataDir)
+	err = cacheCommand(dir, o.args[0], o.args[1:], o.cfg.MaxNumDataFiles, fix)
+	if errors.Is(err, content.ErrCacheLocked) {
+		return fmt.Errorf("%w, stop the server first", err)
+	}
+	return err
+}
+
+func cacheCommand(dir, sub string, rest []string, keep int, fix bool) error {
+	switch sub {
+	case "ls":
+		entries, err := content.ListCache(dir)
+		if err != nil {
+			return err
+		}
+		printEntries(entries)
+	case "rm":
+		if len(rest) == 0 {
+			return fmt.Errorf("want keys, urls or video ids to remove")
+		}
+		for _, target := range rest {
+			removed, err := content.RemoveCached(dir, target)
+			if err != nil {
+				return err
+			} else if len(removed) == 0 {
+				return fmt.Errorf("nothing cached for %q", target)
+			}
+			printEntries(removed)
+		}
+	case "prune":
+		var maxAge time.Duration
+		if len(rest) > 1 {
+			return fmt.Errorf("want at most an age")
+		} else if len(rest) == 1 {
+			var err error
+			if maxAge, err = time.ParseDuration(rest[0]); err != nil {
+				return err
+			}
+		}
+		removed, err := content.PruneCache(dir, keep, maxAge)
+		if err != nil {
+			return err
+		}
+		printEntries(removed)
+	case "verify":
+		problems, err := content.VerifyCache(dir, fix)
+		if err != nil {
+			return err
+		}
+		for _, p := range problems {
+			fmt.Printf("%s: %s\n", p.Path, p.Problem)
+		}
+		if len(problems) > 0 && !fix {
+			return fmt.Errorf("%d problems found", len(problems))
+		}
+	default:
+		return fmt.Errorf("unknown cache command %q", sub)
+	}
+	return nil
+}
+
+func printEntries(entries []content.CacheEntry) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, e := range entries {
+		title := "-"
+		if e.Meta != nil {
+			title = e.Meta.Uri
+			if e.Meta.Info != nil && e.Meta.Info.Title != "" {
+				title = e.Meta.Info.Title
+			}
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", e.Key, e.Size, e.Modified.Format(time.RFC3339), title)
+	}
+	w.Flush()
+}
+
+// Fails if the config is invalid or no downloader is usable.
+func check(args []string) error {
+	o, err := commandOptions(args, nil)
+	if err != nil {
+		return err
+	}
+	if len(o.args) > 0 {
+		return fmt.Errorf("unexpected arguments %q", o.args)
+	}
+	cfg, err := o.serverConfig()
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	downloaders, err := server.Check(cfg)
+	if err != nil {
+		return err
+	}
+	if o.config != "" {
+		fmt.Printf("config %s: ok\n", o.config)
+	}
+	for _, d := range downloaders {
+		if d.Error != "" {
+			fmt.Printf("downloader %s: %s\n", d.Name, d.Error)
+		} else {
+			fmt.Printf("downloader %s: ok, version %s\n", d.Name, d.Version)
+		}
+	}
+	return nil
+}
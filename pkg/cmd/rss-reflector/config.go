@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// YAML config file with a section for each part of the setup. Keys mostly
+// share their flag's name. The environment overrides it, flags override both.
+type configFile struct {
+	values [][2]string // Flag names and values, in the order to set them
+	feeds  []byte      // JSON of the feeds section, nil without one
+	access []byte      // JSON of auth's access rules, nil without them
+}
+
+// A key of a config file section.
+type configKey struct {
+	flag   string
+	values func(v interface{}) ([]string, error) // Nil for plain values and lists
+}
+
+var configSections = map[string]map[string]configKey{
+	"": {
+		"data": {flag: "data"},
+	},
+	"listen": {
+		"addr":            {flag: "addr"},
+		"public-url":      {flag: "public-url"},
+		"trusted-proxies": {flag: "trusted-proxies"},
+	},
+	"downloader": {
+		"backends":                {flag: "downloaders"},
+		"youtube-dl":              {flag: "youtube-dl"},
+		"youtube-dl-flags":        {flag: "youtube-dl-flags"},
+		"yt-dlp":                  {flag: "yt-dlp"},
+		"yt-dlp-flags":            {flag: "yt-dlp-flags"},
+		"native-base-url":         {flag: "native-base-url"},
+		"ffmpeg":                  {flag: "ffmpeg"},
+		"subtitle-langs":          {flag: "subtitle-langs"},
+		"sponsorblock-api":        {flag: "sponsorblock-api"},
+		"sponsorblock-categories": {flag: "sponsorblock-categories"},
+		"task-timeout":            {flag: "task-timeout"},
+	},
+	"subscriptions": {
+		"merge":      {flag: "merge", values: entries("=")},
+		"local":      {flag: "local", values: localEntries},
+		"local-poll": {flag: "local-poll"},
+		"queue-max":  {flag: "queue-max"},
+	},
+	"auth": {
+		"enabled":         {flag: "auth"},
+		"sign-urls":       {flag: "sign-urls"},
+		"sign-ttl":        {flag: "sign-ttl"},
+		"signing-keys":    {flag: "signing-keys"},
+		"feed-rate":       {flag: "feed-rate"},
+		"content-rate":    {flag: "content-rate"},
+		"daily-bytes":     {flag: "daily-bytes"},
+		"daily-downloads": {flag: "daily-downloads"},
+	},
+	"retention": {
+		"max-data-count": {flag: "max-data-count"},
+		"feed-ttl":       {flag: "feed-ttl"},
+		"feed-stale":     {flag: "feed-stale"},
+	},
+	"upstream": {
+		"user-agent":       {flag: "user-agent"},
+		"timeout":          {flag: "upstream-timeout"},
+		"host-timeouts":    {flag: "upstream-host-timeouts", values: joined(entries("="))},
+		"proxies":          {flag: "proxies"},
+		"retries":          {flag: "upstream-retries"},
+		"retry-wait":       {flag: "upstream-retry-wait"},
+		"breaker-failures": {flag: "breaker-failures"},
+		"breaker-cooldown": {flag: "breaker-cooldown"},
+	},
+	"logging": {
+		"file": {flag: "log-file"},
+	},
+}
+
+// Environment variable overriding a flag, e.g. RSS_REFLECTOR_FEED_TTL.
+func envName(flagName string) string {
+	return "RSS_REFLECTOR_" + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// Sets up options from the config file, then the environment, then args.
+func loadOptions(args []string, commandFlags func(fs *flag.FlagSet)) (*options, error) {
+	var pre options
+	fs := newFlagSet(&pre)
+	if commandFlags != nil {
+		commandFlags(fs)
+	}
+	fs.SetOutput(ioutil.Discard)
+	parseArgs(fs, args)
+	path := pre.config
+	if path == "" {
+		path = os.Getenv(envName("config"))
+	}
+
+	o := &options{}
+	fs = newFlagSet(o)
+	if commandFlags != nil {
+		commandFlags(fs)
+	}
+	var err error
+	if path != "" {
+		file, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, kv := range file.values {
+			if err := fs.Set(kv[0], kv[1]); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", path, kv[0], err)
+			}
+		}
+		o.fileFeeds, o.fileAccess = file.feeds, file.access
+	}
+	newLayer(fs)
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if v, ok := os.LookupEnv(envName(f.Name)); ok && envErr == nil {
+			if err := fs.Set(f.Name, v); err != nil {
+				envErr = fmt.Errorf("%s: %w", envName(f.Name), err)
+			}
+		}
+	})
+	if envErr != nil {
+		return nil, envErr
+	}
+	newLayer(fs)
+	if o.args, err = parseArgs(fs, args); err != nil {
+		return nil, err
+	}
+	o.config = path
+	return o, nil
+}
+
+// A flag that collects repeated values.
+type repeatable interface {
+	flag.Value
+	reset()
+}
+
+// Wraps a repeatable flag so each layer of options replaces the last.
+type layered struct {
+	value repeatable
+	set   bool // Whether the current layer has set it
+}
+
+func (l *layered) String() string {
+	if l.value == nil {
+		return ""
+	}
+	return l.value.String()
+}
+
+func (l *layered) Set(s string) error {
+	if !l.set {
+		l.value.reset()
+		l.set = true
+	}
+	return l.value.Set(s)
+}
+
+// Starts the next layer of options.
+func newLayer(fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if l, ok := f.Value.(*layered); ok {
+			l.set = false
+		}
+	})
+}
+
+// Parses flags wherever they are among the arguments, which are returned.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return rest, nil
+		}
+		if len(args) > fs.NArg() && args[len(args)-fs.NArg()-1] == "--" {
+			return append(rest, fs.Args()...), nil
+		}
+		rest, args = append(rest, fs.Arg(0)), fs.Args()[1:]
+	}
+}
+
+func readConfigFile(path string) (*configFile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	file := &configFile{}
+	// Sorted so repeatable flags are set in the same order every time.
+	var names []string
+	for name := range doc {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := doc[name]
+		var err error
+		switch name {
+		case "feeds":
+			file.feeds, err = json.Marshal(jsonValue(v))
+		case "profiles":
+			var values []string
+			if values, err = entries(":")(v); err == nil {
+				for _, value := range values {
+					file.values = append(file.values, [2]string{"profile", value})
+				}
+			}
+		default:
+			if _, ok := configSections[""][name]; ok {
+				err = file.add(configSections[""], name, v)
+			} else if keys, ok := configSections[name]; !ok {
+				err = fmt.Errorf("unknown section")
+			} else {
+				err = file.addSection(name, keys, v)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+	}
+	return file, nil
+}
+
+func (f *configFile) addSection(section string, keys map[string]configKey, v interface{}) error {
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return fmt.Errorf("want a mapping")
+	}
+	var names []string
+	for k := range m {
+		names = append(names, fmt.Sprint(k))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var err error
+		if section == "auth" && name == "access" {
+			f.access, err = json.Marshal(jsonValue(m[name]))
+		} else {
+			err = f.add(keys, name, m[name])
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (f *configFile) add(keys map[string]configKey, name string, v interface{}) error {
+	key, ok := keys[name]
+	if !ok {
+		return fmt.Errorf("unknown key")
+	}
+	if key.values == nil {
+		value, err := plainValue(v)
+		if err != nil {
+			return err
+		}
+		f.values = append(f.values, [2]string{key.flag, value})
+		return nil
+	}
+	values, err := key.values(v)
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		f.values = append(f.values, [2]string{key.flag, value})
+	}
+	return nil
+}
+
+// A scalar as it is, a list joined with commas.
+func plainValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		var parts []string
+		for _, e := range v {
+			s, err := plainValue(e)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, s)
+		}
+		return strings.Join(parts, ","), nil
+	case map[interface{}]interface{}:
+		return "", fmt.Errorf("want a value or a list, got a mapping")
+	}
+	return fmt.Sprint(v), nil
+}
+
+// A value for each entry of a mapping, as name<sep>value.
+func entries(sep string) func(v interface{}) ([]string, error) {
+	return func(v interface{}) ([]string, error) {
+		m, ok := v.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("want a mapping")
+		}
+		var out []string
+		for k, e := range m {
+			value, err := plainValue(e)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", k, err)
+			}
+			out = append(out, fmt.Sprint(k)+sep+value)
+		}
+		sort.Strings(out)
+		return out, nil
+	}
+}
+
+// Entries joined into a single value.
+func joined(values func(v interface{}) ([]string, error)) func(v interface{}) ([]string, error) {
+	return func(v interface{}) ([]string, error) {
+		out, err := values(v)
+		if err != nil {
+			return nil, err
+		}
+		return []string{strings.Join(out, ",")}, nil
+	}
+}
+
+// name: path, or name: {path: path, recursive: true}.
+func localEntries(v interface{}) ([]string, error) {
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("want a mapping")
+	}
+	var out []string
+	for k, e := range m {
+		var dir struct {
+			Path      string `yaml:"path"`
+			Recursive bool   `yaml:"recursive"`
+		}
+		if path, ok := e.(string); ok {
+			dir.Path = path
+		} else if b, err := yaml.Marshal(e); err != nil {
+			return nil, err
+		} else if err := yaml.UnmarshalStrict(b, &dir); err != nil {
+			return nil, fmt.Errorf("%v: %w", k, err)
+		}
+		value := fmt.Sprint(k) + "=" + dir.Path
+		if dir.Recursive {
+			value += ",recursive"
+		}
+		out = append(out, value)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// YAML mappings have keys of any type, JSON objects only string keys.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jsonValue(e)
+		}
+	}
+	return v
+}
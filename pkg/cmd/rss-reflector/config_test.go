@@ -0,0 +1,163 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func writeConfig(t *testing.T, doc string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfigFile(t *testing.T) {
+	path := writeConfig(t, `
+data: /srv/data
+listen:
+  addr: ":8080"
+downloader:
+  backends: [yt-dlp, native]
+profiles:
+  fast: [loudnorm, tempo=1.5]
+subscriptions:
+  merge: {news: ["channel:UCxxxx", "user:someone"]}
+  local: {talks: /srv/talks}
+upstream:
+  host-timeouts: {youtube.com: 10s, example.com: 5s}
+feeds:
+  UCxxxx: {cookies: members}
+auth:
+  access: {deny: ["channel:UCyyyy"]}
+`)
+	file, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]string{
+		{"data", "/srv/data"},
+		{"downloaders", "yt-dlp,native"},
+		{"addr", ":8080"},
+		{"profile", "fast:loudnorm,tempo=1.5"},
+		{"local", "talks=/srv/talks"},
+		{"merge", "news=channel:UCxxxx,user:someone"},
+		{"upstream-host-timeouts", "example.com=5s,youtube.com=10s"},
+	}
+	if !reflect.DeepEqual(file.values, want) {
+		t.Errorf("values %q, want %q", file.values, want)
+	}
+	if string(file.feeds) != `{"UCxxxx":{"cookies":"members"}}` {
+		t.Errorf("feeds %s", file.feeds)
+	}
+	if string(file.access) != `{"deny":["channel:UCyyyy"]}` {
+		t.Errorf("access %s", file.access)
+	}
+}
+
+func TestReadConfigFileErrors(t *testing.T) {
+	for _, doc := range []string{
+		"nope: 1",
+		"listen: {nope: 1}",
+		"listen: [addr]",
+		"listen: {addr: {a: b}}",
+		"subscriptions: {local: {talks: {path: /srv, nope: true}}}",
+		"data: [",
+	} {
+		if _, err := readConfigFile(writeConfig(t, doc)); err == nil {
+			t.Errorf("%q: no error", doc)
+		}
+	}
+}
+
+func TestEntries(t *testing.T) {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(`{b: [1, 2], a: x}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	got, err := entries("=")(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a=x", "b=1,2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := entries("=")([]interface{}{"a"}); err == nil {
+		t.Error("list taken for a mapping")
+	}
+}
+
+func TestLocalEntries(t *testing.T) {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(`{talks: /srv/talks, archive: {path: /srv/archive, recursive: true}}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	got, err := localEntries(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"archive=/srv/archive,recursive", "talks=/srv/talks"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLoadOptionsOverrides(t *testing.T) {
+	path := writeConfig(t, `
+listen:
+  addr: ":1"
+retention:
+  feed-ttl: 1m
+  feed-stale: 2m
+profiles:
+  slow: [tempo=0.8]
+  fast: [tempo=1.5]
+subscriptions:
+  local: {talks: /srv/talks}
+  merge: {news: ["user:someone"]}
+`)
+	t.Setenv(envName("config"), path)
+	t.Setenv(envName("feed-ttl"), "3m")
+	t.Setenv(envName("addr"), ":2")
+	t.Setenv(envName("local"), "music=/srv/music")
+
+	o, err := loadOptions([]string{"--addr", ":3", "--profile", "quiet:loudnorm", "rest"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.cfg.Addr != ":3" {
+		t.Errorf("addr %s, want the flag's", o.cfg.Addr)
+	}
+	if o.cfg.FeedTTL != 3*time.Minute {
+		t.Errorf("feed ttl %s, want the environment's", o.cfg.FeedTTL)
+	}
+	if o.cfg.FeedStale != 2*time.Minute {
+		t.Errorf("feed stale %s, want the file's", o.cfg.FeedStale)
+	}
+	if len(o.profiles) != 1 || o.profiles[0].Name != "quiet" {
+		t.Errorf("profiles %v, want only the flag's", o.profiles.String())
+	}
+	if len(o.localDirs) != 1 || o.localDirs[0].Name != "music" {
+		t.Errorf("local dirs %v, want only the environment's", o.localDirs.String())
+	}
+	if want := (mergeFlags{"news": {"user:someone"}}); !reflect.DeepEqual(o.merges, want) {
+		t.Errorf("merges %v, want the file's", o.merges)
+	}
+	if !reflect.DeepEqual(o.args, []string{"rest"}) || o.config != path {
+		t.Errorf("args %q, config %s", o.args, o.config)
+	}
+
+	// Repeating a flag in one layer still collects every value.
+	o, err = loadOptions([]string{"--profile", "a:loudnorm", "--profile", "b:silence"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.profiles.String() != "a,b" {
+		t.Errorf("profiles %s, want a,b", o.profiles.String())
+	}
+}
@@ -1,33 +1,621 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/nlsun/rss-reflector/pkg/access"
+	"github.com/nlsun/rss-reflector/pkg/auth"
+	"github.com/nlsun/rss-reflector/pkg/content"
+	"github.com/nlsun/rss-reflector/pkg/cookies"
+	"github.com/nlsun/rss-reflector/pkg/local"
 	"github.com/nlsun/rss-reflector/pkg/log"
+	"github.com/nlsun/rss-reflector/pkg/ratelimit"
+	"github.com/nlsun/rss-reflector/pkg/rss"
 	"github.com/nlsun/rss-reflector/pkg/server"
+	"github.com/nlsun/rss-reflector/pkg/upstream"
+	"github.com/nlsun/rss-reflector/pkg/util"
 )
 
 var logger = log.DefaultLogger
 
+// Everything the flags, environment and config file set.
+type options struct {
+	cfg                    server.Config
+	downloaders            string
+	ytdl                   string
+	ytdlFlags              string
+	ytdlp                  string
+	ytdlpFlags             string
+	nativeBaseURL          string
+	sponsorBlockCategories string
+	subtitleLangs          string
+	profiles               profileFlags
+	feeds                  string
+	rewrites               string // The --feeds file of old, rewrites only
+	merges                 mergeFlags
+	localDirs              localFlags
+	accessFile             string
+	publicURL              string
+	trustedProxies         string
+	feedRate               string
+	contentRate            string
+	hostTimeouts           string
+	proxies                string
+	createToken            string
+	revokeToken            string
+	listTokens             bool
+	importCookies          string
+	removeCookies          string
+	listCookies            bool
+	rotateKey              bool
+	keepKeys               int
+	config                 string // Config file, empty for none
+	logFile                string
+	fileFeeds              []byte   // Feeds section of the config file
+	fileAccess             []byte   // Access rules of the config file
+	args                   []string // Arguments left among the flags
+}
+
 func main() {
-	var addr string
-	var datadir string
-	var ytdl string
-	var maxNumDataFiles int
-	var ytdlFlags string
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		logger.Fatalf("unknown command %q, want serve, fetch, feed, cache or check", name)
+	}
+	if err := cmd(args); errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		logger.Fatalf("%s error: %s", name, err)
+	}
+}
+
+// Serves until stopped, or manages tokens, cookies or keys when asked to.
+func serve(args []string) error {
+	o, err := loadOptions(args, nil)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if len(o.args) > 0 {
+		return fmt.Errorf("unexpected arguments %q", o.args)
+	}
+
+	if o.createToken != "" || o.revokeToken != "" || o.listTokens {
+		if err := manageTokens(o.cfg.DataDir, o.createToken, o.revokeToken, o.listTokens); err != nil {
+			return fmt.Errorf("tokens: %w", err)
+		}
+		return nil
+	}
+	if o.importCookies != "" || o.removeCookies != "" || o.listCookies {
+		if err := manageCookies(o.cfg.DataDir, o.importCookies, o.removeCookies, o.listCookies); err != nil {
+			return fmt.Errorf("cookies: %w", err)
+		}
+		return nil
+	}
+	if o.rotateKey {
+		signer, err := auth.NewSigner(server.SigningKeysPath(o.cfg.DataDir))
+		if err == nil {
+			_, err = signer.Rotate(o.keepKeys)
+		}
+		if err != nil {
+			return fmt.Errorf("signing key: %w", err)
+		}
+		return nil
+	}
+
+	if err := setLogFile(o.logFile); err != nil {
+		return fmt.Errorf("log file: %w", err)
+	}
+	cfg, err := o.serverConfig()
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	sv, err := server.NewServer(cfg)
+	if err != nil {
+		return fmt.Errorf("new server: %w", err)
+	}
+	go reloadOnChange(sv, cfg, args, o.config)
+	if err := sv.Run(); err != nil {
+		return fmt.Errorf("server run: %w", err)
+	}
+	return nil
+}
+
+// Turns the options into the server's config.
+func (o *options) serverConfig() (server.Config, error) {
+	cfg := o.cfg
+	cfg.Postprocess.SponsorBlockCategories = strings.Split(o.sponsorBlockCategories, ",")
+	cfg.Postprocess.Profiles = o.profiles
+	cfg.Merges = o.merges
+	cfg.LocalDirs = o.localDirs
+
+	var err error
+	if o.feeds != "" {
+		if cfg.Feeds, err = loadFeeds(o.feeds); err != nil {
+			return cfg, fmt.Errorf("feeds: %w", err)
+		}
+	} else if o.fileFeeds != nil {
+		if cfg.Feeds, err = parseFeeds(o.fileFeeds); err != nil {
+			return cfg, fmt.Errorf("feeds: %w", err)
+		}
+	}
+	if o.rewrites != "" {
+		logger.Print("--rewrites is deprecated, use --feeds")
+		rewrites, err := loadRewrites(o.rewrites)
+		if err != nil {
+			return cfg, fmt.Errorf("rewrites: %w", err)
+		}
+		if cfg.Feeds == nil {
+			cfg.Feeds = map[string]server.FeedConfig{}
+		}
+		// Rewrites given in the feeds settings win.
+		for id, rw := range rewrites {
+			if feed := cfg.Feeds[id]; feed.Rewrite == nil {
+				feed.Rewrite = rw
+				cfg.Feeds[id] = feed
+			}
+		}
+	}
+
+	if cfg.FeedRate, err = ratelimit.ParseRate(o.feedRate); err != nil {
+		return cfg, fmt.Errorf("feed rate: %w", err)
+	}
+	if cfg.ContentRate, err = ratelimit.ParseRate(o.contentRate); err != nil {
+		return cfg, fmt.Errorf("content rate: %w", err)
+	}
+
+	if o.publicURL != "" {
+		u, err := url.Parse(o.publicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return cfg, fmt.Errorf("public url: bad url %q", o.publicURL)
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/")
+		cfg.PublicURL = u
+	}
+	if cfg.TrustedProxies, err = server.ParseTrustedProxies(strings.Split(o.trustedProxies, ",")); err != nil {
+		return cfg, fmt.Errorf("trusted proxies: %w", err)
+	}
+
+	if cfg.Upstream.HostTimeouts, err = parseHostTimeouts(o.hostTimeouts); err != nil {
+		return cfg, fmt.Errorf("upstream host timeouts: %w", err)
+	}
+	if o.proxies != "" {
+		cfg.Upstream.Proxies = strings.Split(o.proxies, ",")
+	}
+
+	if o.accessFile != "" {
+		if cfg.Access, err = loadAccess(o.accessFile); err != nil {
+			return cfg, fmt.Errorf("access: %w", err)
+		}
+	} else if o.fileAccess != nil {
+		if cfg.Access, err = parseAccess(o.fileAccess); err != nil {
+			return cfg, fmt.Errorf("access: %w", err)
+		}
+	}
+
+	for _, name := range strings.Split(o.downloaders, ",") {
+		dcfg := content.DownloaderConfig{Name: strings.TrimSpace(name)}
+		if o.subtitleLangs != "" {
+			dcfg.SubtitleLangs = strings.Split(o.subtitleLangs, ",")
+		}
+		switch dcfg.Name {
+		case content.YoutubeDL:
+			dcfg.Path, dcfg.Flags = o.ytdl, o.ytdlFlags
+		case content.YtDlp:
+			dcfg.Path, dcfg.Flags = o.ytdlp, o.ytdlpFlags
+		case content.Native:
+			dcfg.Path, dcfg.BaseURL = cfg.Postprocess.FFmpeg, o.nativeBaseURL
+		}
+		cfg.Downloaders = append(cfg.Downloaders, dcfg)
+	}
+	return cfg, nil
+}
+
+// How often the config file is checked for changes.
+const configPoll = 5 * time.Second
+
+// Reloads the config on SIGHUP and when the config file changes.
+func reloadOnChange(sv *server.State, cfg server.Config, args []string, path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var poll <-chan time.Time
+	if path != "" {
+		poll = time.NewTicker(configPoll).C
+	}
+	modTime := fileModTime(path)
+	for {
+		select {
+		case <-hup:
+			logger.Print("received hangup, reloading")
+		case <-poll:
+			t := fileModTime(path)
+			if t.Equal(modTime) {
+				continue
+			}
+			modTime = t
+			logger.Printf("%s changed, reloading", path)
+		}
+		next, err := reload(sv, cfg, args)
+		if err != nil {
+			logger.Print("reload error: ", err)
+			continue
+		}
+		cfg = next
+	}
+}
+
+func reload(sv *server.State, prev server.Config, args []string) (server.Config, error) {
+	o, err := loadOptions(args, nil)
+	if err != nil {
+		return prev, err
+	}
+	cfg, err := o.serverConfig()
+	if err != nil {
+		return prev, err
+	}
+	if err := setLogFile(o.logFile); err != nil {
+		logger.Print("log file error: ", err)
+	}
+	if err := sv.Reload(cfg); err != nil {
+		return prev, err
+	}
+	if !reflect.DeepEqual(restartOnly(prev), restartOnly(cfg)) {
+		logger.Print("some changes only apply after a restart")
+	}
+	return cfg, nil
+}
+
+// Settings the server takes at startup and keeps until it stops.
+func restartOnly(cfg server.Config) interface{} {
+	return []interface{}{
+		cfg.Addr, cfg.DataDir, cfg.Downloaders, cfg.Postprocess, cfg.MaxNumDataFiles, cfg.TaskTimeout,
+		cfg.LocalDirs, cfg.LocalPoll, cfg.QueueMax, cfg.Auth, cfg.SignURLs, cfg.Upstream,
+	}
+}
+
+var logFile *os.File
+
+// Opened again on every reload, so the file can be rotated.
+func setLogFile(path string) error {
+	var out io.Writer = log.DefaultLogOutput
+	var f *os.File
+	if path != "" {
+		var err error
+		if f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, util.DefaultFilePerm); err != nil {
+			return err
+		}
+		out = f
+	}
+	logger.SetOutput(out)
+	if logFile != nil {
+		logFile.Close()
+	}
+	logFile = f
+	return nil
+}
+
+func fileModTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// A fresh set for every load, so a reload starts from the defaults.
+func newFlagSet(o *options) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.StringVar(&o.config, "config", "", "YAML config file, reloaded on change and on SIGHUP. Environment variables such as "+envName("feed-ttl")+" override it, flags override both")
+	fs.StringVar(&o.logFile, "log-file", "", "File to log to, reopened on reload, empty for stdout")
+	fs.StringVar(&o.cfg.Addr, "addr", ":3322", "Address to listen on")
+	fs.StringVar(&o.cfg.DataDir, "data", "data", "Data directory")
+	fs.StringVar(&o.downloaders, "downloaders", content.YoutubeDL, "Comma separated downloaders to try in order (native, youtube-dl, yt-dlp)")
+	fs.StringVar(&o.ytdl, "youtube-dl", "youtube-dl", "youtube-dl")
+	fs.StringVar(&o.ytdlFlags, "youtube-dl-flags", "", "youtube-dl flags, empty for the built-in defaults")
+	fs.StringVar(&o.ytdlp, "yt-dlp", "yt-dlp", "yt-dlp")
+	fs.StringVar(&o.ytdlpFlags, "yt-dlp-flags", "", "yt-dlp flags, empty for the built-in defaults")
+	fs.StringVar(&o.cfg.Postprocess.FFmpeg, "ffmpeg", "ffmpeg", "ffmpeg, used for post-processing and by the native downloader")
+	fs.StringVar(&o.nativeBaseURL, "native-base-url", content.NativeBaseURL, "YouTube base url for the native downloader")
+	fs.StringVar(&o.cfg.Postprocess.SponsorBlockAPI, "sponsorblock-api", "", "SponsorBlock API base url, e.g. https://sponsor.ajay.app, empty to disable")
+	fs.StringVar(&o.sponsorBlockCategories, "sponsorblock-categories", "sponsor,intro,selfpromo", "Comma separated SponsorBlock categories to cut")
+	fs.StringVar(&o.subtitleLangs, "subtitle-langs", "", "Comma separated subtitle languages to fetch for transcripts, in order of preference, e.g. en, empty for none")
+	fs.Var(&layered{value: &o.profiles}, "profile", "Processing profile requests may choose with ?profile=name, as name:option,... with options loudnorm, silence, tempo=<speed>, format=<mp3|m4a|opus|ogg> and bitrate=<rate>, e.g. fast:loudnorm,tempo=1.5 or mobile:format=opus,bitrate=32k. Repeatable")
+	fs.StringVar(&o.rewrites, "rewrites", "", "Deprecated, use --feeds. JSON file of item rewrites by channel, playlist or user id, as {\"<id>\": {\"titles\": [...], ...}}")
+	fs.StringVar(&o.feeds, "feeds", "", "JSON file of item rewrites and filters by channel, playlist or user id, \"*\" for all other feeds, replaces the feeds section of the config file")
+	fs.Var(&layered{value: &o.merges}, "merge", "Merged feed served at /rss/merge/<name>, as name=source,... with sources channel:<id>, playlist:<id> or user:<name>. Repeatable")
+	fs.Var(&layered{value: &o.localDirs}, "local", "Directory served as a feed at /rss/local/<name>, as name=path, or name=path,recursive to include subdirectories. Repeatable")
+	fs.DurationVar(&o.cfg.LocalPoll, "local-poll", time.Minute, "How often local directories are checked for new files, 0 to only check at startup")
+	fs.IntVar(&o.cfg.QueueMax, "queue-max", 100, "Max entries of each queue at /queue/<name>, 0 for no limit")
+	fs.StringVar(&o.accessFile, "access", "", "JSON file of {\"allow\": [...], \"deny\": [...]} rules channel:<id>, playlist:<id>, user:<name> or url:<regexp> deciding what may be reflected, replaces the access rules of the config file")
+	fs.StringVar(&o.publicURL, "public-url", "", "Where clients reach the reflector, e.g. https://example.com/reflector, empty to work it out from each request")
+	fs.StringVar(&o.trustedProxies, "trusted-proxies", strings.Join(server.DefaultTrustedProxies, ","), "Comma separated proxy addresses or networks whose Forwarded and X-Forwarded-* headers are believed")
+	fs.DurationVar(&o.cfg.FeedTTL, "feed-ttl", 10*time.Minute, "How long generated feeds are cached, 0 to disable")
+	fs.DurationVar(&o.cfg.FeedStale, "feed-stale", time.Hour, "How long after --feed-ttl a cached feed is still served while it is refreshed in the background")
+	fs.StringVar(&o.feedRate, "feed-rate", "", "Feed requests per minute for each client address and token, as rate or rate/burst, empty for no limit")
+	fs.StringVar(&o.contentRate, "content-rate", "", "Content requests per minute for each client address and token, as rate or rate/burst, empty for no limit")
+	fs.Int64Var(&o.cfg.DailyBytes, "daily-bytes", 0, "Content bytes served per day to each client address and token, 0 for no limit")
+	fs.Int64Var(&o.cfg.DailyDownloads, "daily-downloads", 0, "New downloads per day for each client address and token, 0 for no limit")
+	upstreamDefaults := upstream.DefaultConfig()
+	fs.StringVar(&o.cfg.Upstream.UserAgent, "user-agent", upstreamDefaults.UserAgent, "User agent of requests to upstream sites")
+	fs.DurationVar(&o.cfg.Upstream.Timeout, "upstream-timeout", upstreamDefaults.Timeout, "How long to wait for upstream response headers, 0 for no limit")
+	fs.StringVar(&o.hostTimeouts, "upstream-host-timeouts", "", "Comma separated host=duration timeouts overriding --upstream-timeout, which also apply to subdomains")
+	fs.StringVar(&o.proxies, "proxies", "", "Comma separated http, https or socks5 proxy urls used in turn for upstream requests and downloads")
+	fs.IntVar(&o.cfg.Upstream.Retries, "upstream-retries", upstreamDefaults.Retries, "Retries of upstream requests after network errors, 5xx and 429")
+	fs.DurationVar(&o.cfg.Upstream.RetryWait, "upstream-retry-wait", upstreamDefaults.RetryWait, "Wait before the first retry, doubled for every further one")
+	fs.IntVar(&o.cfg.Upstream.BreakerFailures, "breaker-failures", upstreamDefaults.BreakerFailures, "Failed upstream requests in a row after which a host is left alone for --breaker-cooldown, 0 to never stop")
+	fs.DurationVar(&o.cfg.Upstream.BreakerCooldown, "breaker-cooldown", upstreamDefaults.BreakerCooldown, "How long a failing host is left alone")
+	fs.BoolVar(&o.cfg.AllowPrivateURLs, "allow-private-urls", false, "Let /rss/feed and /content/feed fetch urls on loopback and private addresses")
+	fs.Int64Var(&o.cfg.Postprocess.DirectMaxBytes, "max-media-bytes", 1<<30, "Largest file downloaded from /content/feed in bytes, 0 for no limit")
+	fs.BoolVar(&o.cfg.Auth, "auth", false, "Require a token, given as /t/<token>/..., ?token=<token> or the HTTP Basic auth password")
+	fs.StringVar(&o.createToken, "create-token", "", "Create a token with this name in the data directory, print it and exit")
+	fs.StringVar(&o.revokeToken, "revoke-token", "", "Revoke the token with this name and exit")
+	fs.BoolVar(&o.listTokens, "list-tokens", false, "List tokens and exit")
+	fs.StringVar(&o.importCookies, "import-cookies", "", "Copy a Netscape format cookie file into the data directory as name=path and exit. Feeds name the jars they use, tokens use the jar named after them")
+	fs.StringVar(&o.removeCookies, "remove-cookies", "", "Remove the cookie jar with this name and exit")
+	fs.BoolVar(&o.listCookies, "list-cookies", false, "List cookie jars and exit")
+	fs.BoolVar(&o.cfg.SignURLs, "sign-urls", false, "Sign content links in YouTube feeds and refuse content requests without a valid signature, links to the media of reflected feeds and links naming cookies are always signed")
+	fs.DurationVar(&o.cfg.SignTTL, "sign-ttl", 0, "How long content link signatures are valid, 0 for ever")
+	fs.BoolVar(&o.rotateKey, "rotate-signing-key", false, "Start signing with a new key and exit, links signed with the --signing-keys-1 newest old keys stay valid")
+	fs.IntVar(&o.keepKeys, "signing-keys", 2, "Signing keys kept on rotation, the new one included")
+	fs.IntVar(&o.cfg.MaxNumDataFiles, "max-data-count", 20, "Max number of cached data files")
+	fs.DurationVar(&o.cfg.TaskTimeout, "task-timeout", 30*time.Minute, "Max duration of a single download, 0 for no limit")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [command] [flags] [args]\n%s\nFlags:\n", fs.Name(), commandsUsage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// The running server picks up the changes.
+func manageTokens(dataDir, create, revoke string, list bool) error {
+	store, err := auth.NewStore(server.TokensPath(dataDir))
+	if err != nil {
+		return err
+	}
+	if create != "" {
+		t, err := store.Create(create)
+		if err != nil {
+			return err
+		}
+		fmt.Println(t.Secret)
+	}
+	if revoke != "" {
+		found, err := store.Revoke(revoke)
+		if err != nil {
+			return err
+		} else if !found {
+			return fmt.Errorf("no token %q", revoke)
+		}
+	}
+	if list {
+		tokens, err := store.List()
+		if err != nil {
+			return err
+		}
+		for _, t := range tokens {
+			fmt.Printf("%s\t%s\t%s\n", t.Name, t.Secret, t.Created.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// The running server picks up the changes.
+func manageCookies(dataDir, imp, remove string, list bool) error {
+	store, err := cookies.NewStore(server.CookiesPath(dataDir))
+	if err != nil {
+		return err
+	}
+	if imp != "" {
+		parts := strings.SplitN(imp, "=", 2)
+		if len(parts) < 2 || parts[1] == "" {
+			return fmt.Errorf("want name=path, got %q", imp)
+		}
+		if err := store.Import(parts[0], parts[1]); err != nil {
+			return err
+		}
+	}
+	if remove != "" {
+		found, err := store.Remove(remove)
+		if err != nil {
+			return err
+		} else if !found {
+			return fmt.Errorf("no cookies %q", remove)
+		}
+	}
+	if list {
+		names, err := store.List()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	}
+	return nil
+}
+
+// Reads {"<feed id>": {"rewrite": {...}, "filter": {...}, "cookies": "<jar>"}}.
+func loadFeeds(path string) (map[string]server.FeedConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseFeeds(b)
+}
+
+func parseFeeds(b []byte) (map[string]server.FeedConfig, error) {
+	var configs map[string]struct {
+		Rewrite *rss.RewriteConfig `json:"rewrite"`
+		Filter  rss.FilterConfig   `json:"filter"`
+		Cookies string             `json:"cookies"`
+	}
+	if err := json.Unmarshal(b, &configs); err != nil {
+		return nil, err
+	}
+	feeds := map[string]server.FeedConfig{}
+	for id, c := range configs {
+		feed := server.FeedConfig{Filter: c.Filter, Cookies: c.Cookies}
+		if c.Cookies != "" && !cookies.ValidName(c.Cookies) {
+			return nil, fmt.Errorf("feed %s: bad cookies name %q", id, c.Cookies)
+		}
+		if c.Rewrite != nil {
+			var err error
+			if feed.Rewrite, err = c.Rewrite.Compile(); err != nil {
+				return nil, fmt.Errorf("feed %s rewrite: %w", id, err)
+			}
+		}
+		// Catch mistakes at startup rather than on every request.
+		if _, err := c.Filter.Compile(); err != nil {
+			return nil, fmt.Errorf("feed %s filter: %w", id, err)
+		}
+		feeds[id] = feed
+	}
+	return feeds, nil
+}
+
+// Reads the {"<feed id>": {"titles": [...], ...}} of --rewrites.
+func loadRewrites(path string) (map[string]*rss.Rewrite, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs map[string]rss.RewriteConfig
+	if err := json.Unmarshal(b, &configs); err != nil {
+		return nil, err
+	}
+	rewrites := map[string]*rss.Rewrite{}
+	for id, c := range configs {
+		if rewrites[id], err = c.Compile(); err != nil {
+			return nil, fmt.Errorf("feed %s: %w", id, err)
+		}
+	}
+	return rewrites, nil
+}
 
-	flag.StringVar(&addr, "addr", ":3322", "Address to listen on")
-	flag.StringVar(&datadir, "data", "data", "Data directory")
-	flag.StringVar(&ytdl, "youtube-dl", "youtube-dl", "youtube-dl")
-	flag.IntVar(&maxNumDataFiles, "max-data-count", 20, "Max number of cached data files")
-	defaulYtdlFlags := `--extract-audio --audio-format mp3 --postprocessor-args "-strict experimental"`
-	flag.StringVar(&ytdlFlags, "youtube-dl-flags", defaulYtdlFlags, "youtube-dl flags")
+func loadAccess(path string) (*access.List, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseAccess(b)
+}
 
-	flag.Parse()
+func parseAccess(b []byte) (*access.List, error) {
+	var c access.Config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return c.Compile()
+}
+
+// Parses host=duration,...
+func parseHostTimeouts(spec string) (map[string]time.Duration, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	timeouts := map[string]time.Duration{}
+	for _, part := range strings.Split(spec, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) < 2 || kv[0] == "" {
+			return nil, fmt.Errorf("want host=duration, got %q", part)
+		}
+		d, err := time.ParseDuration(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("host %s: %w", kv[0], err)
+		}
+		timeouts[kv[0]] = d
+	}
+	return timeouts, nil
+}
 
-	sv, err := server.NewServer(addr, datadir, ytdl, ytdlFlags, maxNumDataFiles)
+// Collects repeated --profile flags.
+type profileFlags []content.Profile
+
+func (p *profileFlags) String() string {
+	var names []string
+	for _, profile := range *p {
+		names = append(names, profile.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (p *profileFlags) Set(spec string) error {
+	profile, err := content.ParseProfile(spec)
 	if err != nil {
-		logger.Fatal("new server error: ", err)
+		return err
 	}
-	logger.Fatal("server run error: ", sv.Run())
+	*p = append(*p, profile)
+	return nil
 }
+
+func (p *profileFlags) reset() { *p = nil }
+
+// Collects repeated --merge flags.
+type mergeFlags map[string][]string
+
+func (m *mergeFlags) String() string {
+	var names []string
+	for name := range *m {
+		names = append(names, name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (m *mergeFlags) Set(spec string) error {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("want name=source,..., got %q", spec)
+	}
+	if *m == nil {
+		*m = mergeFlags{}
+	}
+	for _, src := range strings.Split(parts[1], ",") {
+		(*m)[parts[0]] = append((*m)[parts[0]], strings.TrimSpace(src))
+	}
+	return nil
+}
+
+func (m *mergeFlags) reset() { *m = nil }
+
+// Collects repeated --local flags.
+type localFlags []local.Dir
+
+func (l *localFlags) String() string {
+	var names []string
+	for _, d := range *l {
+		names = append(names, d.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (l *localFlags) Set(spec string) error {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" || strings.Contains(parts[0], "/") {
+		return fmt.Errorf("want name=path[,recursive], got %q", spec)
+	}
+	d := local.Dir{Name: parts[0], Path: parts[1]}
+	if strings.HasSuffix(d.Path, ",recursive") {
+		d.Path, d.Recursive = strings.TrimSuffix(d.Path, ",recursive"), true
+	}
+	*l = append(*l, d)
+	return nil
+}
+
+func (l *localFlags) reset() { *l = nil }
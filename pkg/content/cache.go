@@ -0,0 +1,212 @@
+package content
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nlsun/rss-reflector/pkg/util"
+)
+
+// Returned when the cache is locked by a running fetcher.
+var ErrCacheLocked = errors.New("cache in use by another process")
+
+// The fetcher and the commands changing the cache hold a lock on this file.
+func lockPath(basedir string) string {
+	return filepath.Join(basedir, "lock")
+}
+
+// Locks the cache under basedir for changes made outside of a fetcher.
+func lockCache(basedir string) (*os.File, error) {
+	if err := os.MkdirAll(basedir, util.DefaultDirPerm); err != nil {
+		return nil, err
+	}
+	return lockFile(lockPath(basedir))
+}
+
+// A cached item as it is stored under a fetcher's directory.
+type CacheEntry struct {
+	Key      string    // Made from the request the item was fetched for
+	Path     string    // The data file
+	Size     int64     // Of the data file
+	Modified time.Time // When the item was cached
+	Meta     *ItemMeta // Nil if the metadata is missing or unreadable
+}
+
+// Something wrong with a file in the cache.
+type CacheProblem struct {
+	Path    string
+	Problem string
+}
+
+// Items cached under the fetcher directory basedir, oldest first.
+func ListCache(basedir string) ([]CacheEntry, error) {
+	datadir, metadir := filepath.Join(basedir, "data"), filepath.Join(basedir, "meta")
+	files, err := readDirIfExists(datadir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []CacheEntry
+	for _, fi := range files {
+		key := strings.TrimSuffix(fi.Name(), filepath.Ext(fi.Name()))
+		e := CacheEntry{Key: key, Path: filepath.Join(datadir, fi.Name()), Size: fi.Size(), Modified: fi.ModTime()}
+		if e.Meta, err = readMeta(metadir, key); err != nil {
+			logger.Printf("metadata of %s: %s", key, err)
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Modified.Before(entries[j].Modified) })
+	return entries, nil
+}
+
+// Removes the cached items whose key, uri or video id is target.
+func RemoveCached(basedir, target string) ([]CacheEntry, error) {
+	lock, err := lockCache(basedir)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+	entries, err := ListCache(basedir)
+	if err != nil {
+		return nil, err
+	}
+	var removed []CacheEntry
+	for _, e := range entries {
+		if !e.matches(target) {
+			continue
+		}
+		if err := removeEntry(basedir, e); err != nil {
+			return removed, err
+		}
+		removed = append(removed, e)
+	}
+	return removed, nil
+}
+
+func (e CacheEntry) matches(target string) bool {
+	if e.Key == target {
+		return true
+	} else if e.Meta == nil {
+		return false
+	} else if e.Meta.Uri == target {
+		return true
+	}
+	id, err := YoutubeVideoID(e.Meta.Uri)
+	return e.Meta.Src == YoutubeSource && err == nil && id == target
+}
+
+// Removes the oldest items until at most keep are left, and any older than
+// maxAge unless it is 0.
+func PruneCache(basedir string, keep int, maxAge time.Duration) ([]CacheEntry, error) {
+	lock, err := lockCache(basedir)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+	entries, err := ListCache(basedir)
+	if err != nil {
+		return nil, err
+	}
+	var removed []CacheEntry
+	for i, e := range entries {
+		if len(entries)-i <= keep && (maxAge == 0 || time.Since(e.Modified) <= maxAge) {
+			continue
+		}
+		if err := removeEntry(basedir, e); err != nil {
+			return removed, err
+		}
+		removed = append(removed, e)
+	}
+	return removed, nil
+}
+
+// Looks for broken data and metadata files, and removes them with fix.
+func VerifyCache(basedir string, fix bool) ([]CacheProblem, error) {
+	if fix {
+		lock, err := lockCache(basedir)
+		if err != nil {
+			return nil, err
+		}
+		defer lock.Close()
+	}
+	entries, err := ListCache(basedir)
+	if err != nil {
+		return nil, err
+	}
+	var problems []CacheProblem
+	keys := map[string]bool{}
+	for _, e := range entries {
+		keys[e.Key] = true
+		problem := ""
+		switch {
+		case e.Size == 0:
+			problem = "empty data file"
+		case e.Meta == nil:
+			problem = "missing or unreadable metadata"
+		case MimeType(e.Path) == "":
+			problem = "unknown media type"
+		}
+		if problem == "" {
+			continue
+		}
+		problems = append(problems, CacheProblem{Path: e.Path, Problem: problem})
+		if fix {
+			if err := removeEntry(basedir, e); err != nil {
+				return problems, err
+			}
+		}
+	}
+
+	metadir := filepath.Join(basedir, "meta")
+	files, err := readDirIfExists(metadir)
+	if err != nil {
+		return problems, err
+	}
+	for _, fi := range files {
+		key := strings.TrimSuffix(fi.Name(), filepath.Ext(fi.Name()))
+		if keys[key] {
+			continue
+		}
+		path := filepath.Join(metadir, fi.Name())
+		problems = append(problems, CacheProblem{Path: path, Problem: "metadata without data"})
+		if fix {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return problems, err
+			}
+		}
+	}
+	return problems, nil
+}
+
+func removeEntry(basedir string, e CacheEntry) error {
+	logger.Printf("removing cached file: %s", e.Path)
+	if err := os.Remove(e.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return removeMeta(filepath.Join(basedir, "meta"), e.Key)
+}
+
+// A missing directory is empty, as the fetcher creates them on startup.
+func readDirIfExists(dir string) ([]os.FileInfo, error) {
+	d, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	files, err := d.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	var regular []os.FileInfo
+	for _, fi := range files {
+		if fi.Mode().IsRegular() {
+			regular = append(regular, fi)
+		}
+	}
+	return regular, nil
+}
@@ -0,0 +1,170 @@
+package content
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Caches data as the file name, modified age ago, with metadata unless meta
+// is nil.
+func cacheItem(t *testing.T, basedir, name string, data string, meta *ItemMeta, age time.Duration) {
+	for _, dir := range []string{"data", "meta"} {
+		if err := os.MkdirAll(filepath.Join(basedir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(basedir, "data", name)
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Now().Add(-age)
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	if meta != nil {
+		key := name[:len(name)-len(filepath.Ext(name))]
+		if err := writeMeta(filepath.Join(basedir, "meta"), key, meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func cachedKeys(t *testing.T, basedir string) []string {
+	entries, err := ListCache(basedir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, e := range entries {
+		keys = append(keys, e.Key)
+	}
+	return keys
+}
+
+func TestPruneCache(t *testing.T) {
+	dir := t.TempDir()
+	meta := &ItemMeta{Src: YoutubeSource, Uri: "https://www.youtube.com/watch?v=abcdefghijk"}
+	cacheItem(t, dir, "a.mp3", "a", meta, 3*time.Hour)
+	cacheItem(t, dir, "b.mp3", "b", meta, 2*time.Hour)
+	cacheItem(t, dir, "c.mp3", "c", meta, time.Hour)
+	cacheItem(t, dir, "d.mp3", "d", meta, 0)
+
+	removed, err := PruneCache(dir, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0].Key != "a" {
+		t.Errorf("removed %+v, want the oldest", removed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "meta", "a.json")); !os.IsNotExist(err) {
+		t.Errorf("metadata of a left: %v", err)
+	}
+
+	if _, err := PruneCache(dir, 3, 90*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cachedKeys(t, dir), []string{"c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("left %q, want %q", got, want)
+	}
+}
+
+func TestVerifyCache(t *testing.T) {
+	dir := t.TempDir()
+	meta := &ItemMeta{Src: YoutubeSource, Uri: "https://www.youtube.com/watch?v=abcdefghijk"}
+	cacheItem(t, dir, "good.mp3", "audio", meta, 0)
+	cacheItem(t, dir, "empty.mp3", "", meta, 0)
+	cacheItem(t, dir, "nometa.mp3", "audio", nil, 0)
+	cacheItem(t, dir, "odd.xyz", "audio", meta, 0)
+	if err := writeMeta(filepath.Join(dir, "meta"), "orphan", meta); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := VerifyCache(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range problems {
+		got = append(got, filepath.Base(p.Path)+": "+p.Problem)
+	}
+	sort.Strings(got)
+	want := []string{
+		"empty.mp3: empty data file",
+		"nometa.mp3: missing or unreadable metadata",
+		"odd.xyz: unknown media type",
+		"orphan.json: metadata without data",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if keys := cachedKeys(t, dir); len(keys) != 4 {
+		t.Errorf("%q left, want nothing removed without fix", keys)
+	}
+
+	if _, err := VerifyCache(dir, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := cachedKeys(t, dir); !reflect.DeepEqual(got, []string{"good"}) {
+		t.Errorf("left %q", got)
+	}
+	if problems, err := VerifyCache(dir, false); err != nil || len(problems) != 0 {
+		t.Errorf("after fixing: %+v, %v", problems, err)
+	}
+}
+
+func TestCacheLocked(t *testing.T) {
+	dir := t.TempDir()
+	f, err := NewFetcher(dir, []Downloader{&countingDownloader{}}, PostprocessConfig{}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := NewFetcher(dir, []Downloader{&countingDownloader{}}, PostprocessConfig{}, 10, 0); !errors.Is(err, ErrCacheLocked) {
+		t.Errorf("second fetcher: got %v, want the cache locked", err)
+	}
+	if _, err := PruneCache(dir, 0, 0); !errors.Is(err, ErrCacheLocked) {
+		t.Errorf("prune: got %v, want the cache locked", err)
+	}
+	if _, err := VerifyCache(dir, false); err != nil {
+		t.Errorf("verify without fixing: %v", err)
+	}
+
+	lookups, err := NewLookupFetcher(dir, []Downloader{&countingDownloader{}}, PostprocessConfig{})
+	if err != nil {
+		t.Fatalf("lookup fetcher next to a running one: %v", err)
+	}
+	defer lookups.Close()
+	if _, err := lookups.SubmitTask(context.Background(), TaskRequest{Src: YoutubeSource, Uri: "x"}); err == nil {
+		t.Error("lookup fetcher took a task")
+	}
+	lookups.FinishTask()
+}
+
+func TestTaskRequestKeyAlbum(t *testing.T) {
+	f := &Fetcher{}
+	req := TaskRequest{Src: YoutubeSource, Uri: "https://www.youtube.com/watch?v=abcdefghijk"}
+	plain, _ := f.normalize(req).key()
+	req.Album = "Show / Part 1"
+	show, _ := f.normalize(req).key()
+	req.Album = "Other show"
+	other, _ := f.normalize(req).key()
+	if plain == show || show == other || filepath.Base(show) != show {
+		t.Errorf("keys %q, %q and %q", plain, show, other)
+	}
+
+	// Only YouTube downloads are tagged with it.
+	feed := TaskRequest{Src: FeedSource, Uri: "https://example.com/ep1.mp3"}
+	plain, _ = f.normalize(feed).key()
+	feed.Album = "Show"
+	if key, _ := f.normalize(feed).key(); key != plain {
+		t.Errorf("feed item key %q, want %q", key, plain)
+	}
+}
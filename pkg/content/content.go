@@ -2,24 +2,45 @@ package content
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/google/shlex"
-
+	"github.com/nlsun/rss-reflector/pkg/cookies"
 	"github.com/nlsun/rss-reflector/pkg/log"
+	"github.com/nlsun/rss-reflector/pkg/upstream"
 	"github.com/nlsun/rss-reflector/pkg/util"
 )
 
 type Source string
 
 type TaskRequest struct {
-	Src Source // Content source
-	Uri string // Content uri
+	Src          Source // Content source
+	Uri          string // Content uri
+	SponsorBlock bool   // Cut out sponsor segments
+	Album        string // Album to tag the file with, usually the feed title
+	Profile      string // Name of the processing profile, empty for none
+	// Sent with the download and its upstream requests, nil for none.
+	Cookies *cookies.Jar
+}
+
+// Post-processing applied after the download.
+type PostprocessConfig struct {
+	FFmpeg                 string    // Path to ffmpeg
+	SponsorBlockAPI        string    // SponsorBlock compatible API base url, empty to disable
+	SponsorBlockCategories []string  // Segment categories to cut
+	Profiles               []Profile // Profiles requests may choose from
+	// Fetches cover art and SponsorBlock segments. Optional.
+	Client *upstream.Client
+	// Downloads media urls instead of Client if set.
+	DirectClient *upstream.Client
+	// Largest file downloaded from a media url in bytes, 0 for no limit.
+	DirectMaxBytes int64
 }
 
 type internalTaskRequest struct {
@@ -32,30 +53,69 @@ type taskResponse struct {
 	err  error  // Errors encountered
 }
 
+// A metadata lookup, made by the download worker.
+type infoRequest struct {
+	ctx   context.Context   // The client's, the lookup ends with it
+	key   string            // Of the lookup in the info cache
+	uri   string            // What to look up
+	respC chan infoResponse // Buffered, so the worker never waits on it
+}
+
+type infoResponse struct {
+	info *Info
+	err  error
+}
+
 type fetcherTask struct {
-	req       TaskRequest
-	respC     chan<- taskResponse
-	finC      <-chan struct{}
-	tmpdir    string
-	datadir   string
-	ytdl      string
-	ytdlFlags string
-	maxndf    int
+	req         TaskRequest
+	respC       chan<- taskResponse
+	finC        <-chan struct{}
+	tmpdir      string
+	datadir     string
+	metadir     string
+	downloaders []Downloader
+	pp          PostprocessConfig
+	maxndf      int
+	timeout     time.Duration
+	progress    func(Progress)
 }
 
 type Fetcher struct {
-	tmpdir    string                   // Directory to store temporary data
-	datadir   string                   // Directory to store data
-	ytdl      string                   // Path to youtube-dl
-	ytdlFlags string                   // youtube-dl command line flags
-	maxndf    int                      // Max number of data files to cache
-	reqQueue  chan internalTaskRequest // The client request queue
-	respQueue chan taskResponse        // The handler response queue
-	finQueue  chan struct{}            // The client fin response queue
+	tmpdir      string                   // Directory to store temporary data
+	datadir     string                   // Directory to store data
+	metadir     string                   // Directory to store item metadata
+	downloaders []Downloader             // Usable backends, in fallback order
+	direct      Downloader               // Backend for media urls
+	statuses    []DownloaderStatus       // Detected state of all backends
+	pp          PostprocessConfig        // Post-processing settings
+	maxndf      int                      // Max number of data files to cache
+	timeout     time.Duration            // Max duration of a single task
+	jobs        *jobTracker              // Status of running and recent tasks
+	ctx         context.Context          // Cancelled when the fetcher is closed
+	cancel      context.CancelFunc       // Closes the fetcher
+	infos       *infoCache               // Metadata looked up outside of tasks
+	reqQueue    chan internalTaskRequest // The client request queue
+	infoQueue   chan infoRequest         // The metadata lookup queue
+	details     *nativeDownloader        // Looks up details beside the worker
+	detailSem   chan struct{}            // Bounds the detail lookups
+	respQueue   chan taskResponse        // The handler response queue
+	finQueue    chan struct{}            // The client fin response queue
+	lock        *os.File                 // Held on the cache, nil for lookups only
 }
 
 const (
 	YoutubeSource Source = "youtube"
+	// Media urls, such as the enclosures of reflected feeds.
+	FeedSource Source = "feed"
+)
+
+const (
+	// How long a backend gets to report its version.
+	versionTimeout = 30 * time.Second
+	// How long a metadata lookup may take.
+	infoTimeout = time.Minute
+	// Detail lookups running at once.
+	maxDetailLookups = 4
 )
 
 var logger = log.DefaultLogger
@@ -64,34 +124,78 @@ func (s Source) String() string {
 	return string(s)
 }
 
-func NewFetcher(basedir, ytdl, ytdlFlags string, maxndf int) (*Fetcher, error) {
-	if err := exec.Command(ytdl, "--version").Run(); err != nil {
-		return nil, err
+// Downloaders that fail to report a version are skipped. The cache is locked
+// until Close, ErrCacheLocked if another fetcher has it.
+func NewFetcher(basedir string, downloaders []Downloader, pp PostprocessConfig, maxndf int, timeout time.Duration) (*Fetcher, error) {
+	return newFetcher(basedir, downloaders, pp, maxndf, timeout, true)
+}
+
+// A fetcher that reads the cache and looks up metadata but never downloads.
+func NewLookupFetcher(basedir string, downloaders []Downloader, pp PostprocessConfig) (*Fetcher, error) {
+	return newFetcher(basedir, downloaders, pp, 0, 0, false)
+}
+
+func newFetcher(basedir string, downloaders []Downloader, pp PostprocessConfig, maxndf int, timeout time.Duration, lock bool) (*Fetcher, error) {
+	usable, statuses := detectDownloaders(downloaders)
+	if len(usable) == 0 {
+		return nil, fmt.Errorf("no usable downloader: %+v", statuses)
 	}
 
 	datadir := filepath.Join(basedir, "data")
 	tmpdir := filepath.Join(basedir, "tmp")
+	metadir := filepath.Join(basedir, "meta")
 
 	if err := os.MkdirAll(datadir, util.DefaultDirPerm); err != nil {
 		return nil, err
 	}
+	if err := os.MkdirAll(metadir, util.DefaultDirPerm); err != nil {
+		return nil, err
+	}
 	if err := os.MkdirAll(tmpdir, util.DefaultDirPerm); err != nil {
 		return nil, err
 	}
 
+	var lockf *os.File
+	if lock {
+		var err error
+		if lockf, err = lockFile(lockPath(basedir)); err != nil {
+			return nil, err
+		}
+	}
+
+	details := newNativeDownloader("", "", nil, pp.Client)
+	for _, d := range usable {
+		if d, ok := d.(*nativeDownloader); ok {
+			details = d
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 	fetcher := &Fetcher{
-		datadir:   datadir,
-		tmpdir:    tmpdir,
-		ytdl:      ytdl,
-		ytdlFlags: ytdlFlags,
-		maxndf:    maxndf,
+		datadir:     datadir,
+		tmpdir:      tmpdir,
+		metadir:     metadir,
+		downloaders: usable,
+		direct:      newDirectDownloader(pp.directClient(), pp.DirectMaxBytes),
+		statuses:    statuses,
+		pp:          pp,
+		infos:       newInfoCache(),
+		details:     details,
+		detailSem:   make(chan struct{}, maxDetailLookups),
+		maxndf:      maxndf,
+		timeout:     timeout,
+		jobs:        newJobTracker(),
+		ctx:         ctx,
+		cancel:      cancel,
 
 		// The queue purposefully has no buffering so we will never attempt
 		// to fetch things concurrently. This is because we expect this to
 		// run on weak servers.
 		reqQueue:  make(chan internalTaskRequest),
+		infoQueue: make(chan infoRequest),
 		respQueue: make(chan taskResponse),
 		finQueue:  make(chan struct{}),
+		lock:      lockf,
 	}
 
 	go fetcher.handleTasks()
@@ -99,122 +203,257 @@ func NewFetcher(basedir, ytdl, ytdlFlags string, maxndf int) (*Fetcher, error) {
 	return fetcher, nil
 }
 
+func detectDownloaders(downloaders []Downloader) ([]Downloader, []DownloaderStatus) {
+	var usable []Downloader
+	statuses := []DownloaderStatus{}
+	for _, d := range downloaders {
+		ctx, cancel := context.WithTimeout(context.Background(), versionTimeout)
+		version, err := d.Version(ctx)
+		cancel()
+		status := DownloaderStatus{Name: d.Name(), Version: version}
+		if err != nil {
+			status.Error = err.Error()
+			logger.Printf("downloader %s unusable: %s", d.Name(), err)
+		} else {
+			usable = append(usable, d)
+			logger.Printf("downloader %s version %s", d.Name(), version)
+		}
+		statuses = append(statuses, status)
+	}
+	return usable, statuses
+}
+
 func (f *Fetcher) handleTasks() {
-	for intreq := range f.reqQueue {
-		logger.Printf("fetcher handling task %+v", intreq.req)
-		t := fetcherTask{
-			req:       intreq.req,
-			respC:     f.respQueue,
-			finC:      f.finQueue,
-			tmpdir:    f.tmpdir,
-			datadir:   f.datadir,
-			ytdl:      f.ytdl,
-			ytdlFlags: f.ytdlFlags,
-			maxndf:    f.maxndf,
+	for {
+		select {
+		case intreq := <-f.reqQueue:
+			logger.Printf("fetcher handling task %+v", intreq.req)
+			t := fetcherTask{
+				req:         intreq.req,
+				respC:       f.respQueue,
+				finC:        f.finQueue,
+				tmpdir:      f.tmpdir,
+				datadir:     f.datadir,
+				metadir:     f.metadir,
+				downloaders: f.downloadersFor(intreq.req.Src),
+				pp:          f.pp,
+				maxndf:      f.maxndf,
+				timeout:     f.timeout,
+			}
+			id := f.jobs.start(intreq.req)
+			t.progress = func(p Progress) { f.jobs.progress(id, p) }
+			err := t.doTask(f.ctx)
+			f.jobs.finish(id, err)
+			logger.Printf("fetcher completed task %+v", intreq.req)
+		case ireq := <-f.infoQueue:
+			info, err := f.lookupInfo(ireq.ctx, ireq.key, ireq.uri)
+			ireq.respC <- infoResponse{info: info, err: err}
+		case <-f.ctx.Done():
+			logger.Print("fetcher closed, terminating task handler")
+			if f.lock != nil {
+				f.lock.Close()
+			}
+			return
 		}
-		t.doTask(intreq.ctx)
-		logger.Printf("fetcher completed task %+v", intreq.req)
 	}
-	logger.Print("request queue closed, terminating task handler")
 }
 
-func (f fetcherTask) doTask(ctx context.Context) {
-	path, err := f.doTaskHelper(ctx)
+// Runs under the fetcher's context, so only the timeout or Close stop it.
+func (f fetcherTask) doTask(ctx context.Context) error {
+	taskCtx := ctx
+	if f.timeout > 0 {
+		var cancel context.CancelFunc
+		taskCtx, cancel = context.WithTimeout(ctx, f.timeout)
+		defer cancel()
+	}
+	path, err := f.doTaskHelper(taskCtx)
 	f.respC <- taskResponse{path: path, err: err}
-	<-f.finC
+	select {
+	case <-f.finC:
+	case <-ctx.Done():
+	}
+	return err
 }
 
 // Downloads to a temporary location and then moves it to the final location
 // after the download completes. This is so we don't accidentally use
 // half-finished downloads.
 func (f fetcherTask) doTaskHelper(ctx context.Context) (string, error) {
-	// Even if the context closes, we still want to complete the download. That
-	// way it'll be cached when the request retries.
+	// Even if the client goes away, we still want to complete the download.
+	// That way it'll be cached when the request retries.
+	ctx = cookies.WithJar(ctx, f.req.Cookies)
+
+	if len(f.downloaders) == 0 {
+		return "", fmt.Errorf("source %s unimplemented", f.req.Src)
+	}
+	if dataf, err := f.cached(); err != nil || dataf != "" {
+		return dataf, err
+	}
+
+	// Without segments the audio is cached uncut, under the plain key.
+	var segments []Segment
+	if f.req.SponsorBlock {
+		var err error
+		if segments, err = f.sponsorSegments(ctx); err != nil {
+			logger.Printf("sponsor segments of %s, keeping them: %s", f.req.Uri, err)
+			f.req.SponsorBlock = false
+			if dataf, err := f.cached(); err != nil || dataf != "" {
+				return dataf, err
+			}
+		}
+	}
 
-	// youtube-dl does this weird thing where you have to use it's file name
-	// templates so you cannot use exact string match.
+	// Downloaders pick the extension, so files are found by prefix.
 
-	u, err := url.Parse(f.req.Uri)
+	fnamePrefix, err := f.req.key()
 	if err != nil {
 		return "", err
 	}
-	fnamePrefix := strings.Replace(u.RequestURI(), "_", "__", -1)
-	fnamePrefix = strings.Replace(fnamePrefix, "/", "_", -1)
-	fnamePrefix = f.req.Src.String() + "_" + fnamePrefix
-	// tmpfPrefix is only a prefix because it is created by youtube-dl
+	// tmpfPrefix is only a prefix because it is created by the downloader
 	tmpfPrefix := filepath.Join(f.tmpdir, fnamePrefix)
-	// dataf is not a prefix because it is the file we name
-	dataf := filepath.Join(f.datadir, fnamePrefix)
+	// dataPrefix is followed by the extension the downloader picked
+	dataPrefix := filepath.Join(f.datadir, fnamePrefix)
 
-	if f.req.Src != YoutubeSource {
-		return "", fmt.Errorf("source %s unimplemented", f.req.Src)
+	// Wipe the tmp file location first in case there was stale data.
+	if err := removeWithPrefix(tmpfPrefix); err != nil {
+		return "", err
 	}
 
-	if ok, err := util.FileExists(dataf); err != nil {
+	if err := f.evict(); err != nil {
 		return "", err
-	} else if ok {
-		return dataf, nil
 	}
 
-	// Wipe the tmp file location first in case there was stale data.
-	if tmpf, err := util.FindFileWithPrefix(tmpfPrefix); err != nil {
+	res, err := downloadWithFallback(ctx, f.downloaders, f.req.Uri, tmpfPrefix, f.progress)
+	if err != nil {
 		return "", err
-	} else if tmpf != "" {
-		logger.Print("removing stale tmp file %s", tmpf)
-		if err := os.RemoveAll(tmpf); err != nil {
+	}
+	tmpf := res.Path
+	defer removeSubtitles(res.Subtitles)
+
+	meta := &ItemMeta{Src: f.req.Src, Uri: f.req.Uri, SponsorBlock: f.req.SponsorBlock, Album: f.req.Album, Info: res.Info}
+	if f.req.SponsorBlock {
+		if err := f.cutSponsorSegments(ctx, tmpf, segments); err != nil {
 			return "", err
 		}
+		meta.SponsorSegments = segments
+	}
+	profile, _ := f.pp.profile(f.req.Profile)
+	if tmpf, err = f.applyProfile(ctx, tmpf, profile); err != nil {
+		return "", err
+	}
+	meta.Profile = profile.Name
+	if res.Info != nil {
+		meta.Chapters = profile.mapChapters(cutChapters(res.Info.chapters(), meta.SponsorSegments))
+	}
+	mapTime := func(t float64) (float64, bool) {
+		t, ok := cutTime(t, meta.SponsorSegments)
+		return profile.mapTime(t), ok
+	}
+	// A broken transcript doesn't make the audio any worse.
+	lang, err := saveTranscript(f.metadir, fnamePrefix, res.Subtitles, mapTime)
+	if err != nil {
+		logger.Printf("transcript of %s: %s", f.req.Uri, err)
+		lang = ""
+	}
+	meta.TranscriptLang = lang
+	if err := writeTags(ctx, f.pp.Client, tmpf, meta); err != nil {
+		return "", err
+	}
+	if err := writeMeta(f.metadir, fnamePrefix, meta); err != nil {
+		return "", err
 	}
 
-	if files, err := util.FilesSortedByOldest(f.datadir); err != nil {
+	dataf := dataPrefix + filepath.Ext(tmpf)
+	logger.Printf("moving %s to %s", tmpf, dataf)
+	if err := os.Rename(tmpf, dataf); err != nil {
 		return "", err
-	} else if len(files) >= f.maxndf {
-		logger.Printf("removing files, count %d max %d", len(files), f.maxndf)
-		for _, file := range files[:len(files)-f.maxndf+1] {
-			if err := os.RemoveAll(file); err != nil {
-				return "", err
-			}
-			logger.Printf("removing cached file: %s", file)
-		}
 	}
 
-	splitFlags, err := shlex.Split(f.ytdlFlags)
+	return dataf, nil
+}
+
+// Path of the task's cached file, empty if there is none.
+func (f fetcherTask) cached() (string, error) {
+	key, err := f.req.key()
 	if err != nil {
 		return "", err
 	}
-	// youtube-dl forces you to use their template format if you are re-encoding
-	cmdFlags := append(splitFlags, "--output", tmpfPrefix+`.%(ext)s`, f.req.Uri)
-	logger.Printf("%s %+v", f.ytdl, cmdFlags)
-	cmd := exec.Command(f.ytdl, cmdFlags...)
-	outputB, err := cmd.CombinedOutput()
+	return util.FindFileWithPrefix(filepath.Join(f.datadir, key) + ".")
+}
+
+// Makes room for one more data file by removing the oldest ones.
+func (f fetcherTask) evict() error {
+	files, err := util.FilesSortedByOldest(f.datadir)
 	if err != nil {
-		msg := err.Error()
-		if outputB != nil {
-			msg += "\n" + string(outputB)
+		return err
+	}
+	if len(files) < f.maxndf {
+		return nil
+	}
+	logger.Printf("removing files, count %d max %d", len(files), f.maxndf)
+	for _, file := range files[:len(files)-f.maxndf+1] {
+		logger.Printf("removing cached file: %s", file)
+		if err := os.RemoveAll(filepath.Join(f.datadir, file)); err != nil {
+			return err
+		}
+		if err := removeMeta(f.metadir, strings.TrimSuffix(file, filepath.Ext(file))); err != nil {
+			return err
 		}
-		return "", fmt.Errorf(msg)
 	}
-	logger.Print(string(outputB))
+	return nil
+}
+
+func (f *Fetcher) downloadersFor(src Source) []Downloader {
+	switch src {
+	case YoutubeSource:
+		return f.downloaders
+	case FeedSource:
+		return []Downloader{f.direct}
+	}
+	return nil
+}
 
-	tmpf, err := util.FindFileWithPrefix(tmpfPrefix)
+// Name under which the request is cached. Variants get suffixes after a '#'.
+func (r TaskRequest) key() (string, error) {
+	u, err := url.Parse(r.Uri)
 	if err != nil {
 		return "", err
 	}
-	if tmpf == "" {
-		return "", fmt.Errorf("tmp file with prefix %s not found", tmpfPrefix)
+	var key string
+	if r.Src == YoutubeSource {
+		key = strings.Replace(u.RequestURI(), "_", "__", -1)
+		key = strings.Replace(key, "/", "_", -1)
+	} else {
+		// Urls can be too long for a file name.
+		sum := sha256.Sum256([]byte(r.Uri))
+		key = hex.EncodeToString(sum[:16])
 	}
-	logger.Printf("moving %s to %s", tmpf, dataf)
-	if err := os.Rename(tmpf, dataf); err != nil {
-		return "", err
+	key = r.Src.String() + "_" + key
+	if r.SponsorBlock {
+		key += "#sb"
 	}
-
-	return dataf, nil
+	if r.Profile != "" {
+		key += "#p-" + r.Profile
+	}
+	if r.Cookies != nil {
+		key += "#c-" + r.Cookies.Name
+	}
+	// Albums are feed titles, which can't go in a file name as they are.
+	if r.Album != "" {
+		sum := sha256.Sum256([]byte(r.Album))
+		key += "#a-" + hex.EncodeToString(sum[:4])
+	}
+	return key, nil
 }
 
 // There should never be more than one task running at a time.
 //
 // FinishTask MUST be called WHETHER OR NOT this succeeds.
 func (f *Fetcher) SubmitTask(ctx context.Context, req TaskRequest) (string, error) {
+	req = f.normalize(req)
+	if f.lock == nil {
+		return "", fmt.Errorf("task %+v submitted to a fetcher for lookups only", req)
+	}
 	logger.Printf("submitting task %+v", req)
 	select {
 	case f.reqQueue <- internalTaskRequest{req: req, ctx: ctx}:
@@ -222,11 +461,197 @@ func (f *Fetcher) SubmitTask(ctx context.Context, req TaskRequest) (string, erro
 		return resp.path, resp.err
 	case <-ctx.Done():
 		return "", fmt.Errorf("context done before task %+v submitted", req)
+	case <-f.ctx.Done():
+		return "", fmt.Errorf("fetcher closed before task %+v submitted", req)
 	}
 }
 
 // This must be called after the returned resources are no longer used. This
 // allows the next task to begin.
 func (f *Fetcher) FinishTask() {
-	f.finQueue <- struct{}{}
+	if f.lock == nil {
+		return
+	}
+	select {
+	case f.finQueue <- struct{}{}:
+	case <-f.ctx.Done():
+	}
+}
+
+// Status of the running task and recently finished ones, oldest first.
+func (f *Fetcher) Jobs() []JobStatus {
+	return f.jobs.list()
+}
+
+// Drops options the fetcher is not set up for.
+func (f *Fetcher) normalize(req TaskRequest) TaskRequest {
+	if req.SponsorBlock && (f.pp.SponsorBlockAPI == "" || req.Src != YoutubeSource) {
+		req.SponsorBlock = false
+	}
+	if _, ok := f.pp.profile(req.Profile); !ok {
+		req.Profile = ""
+	}
+	// Only YouTube downloads are tagged.
+	if req.Src != YoutubeSource {
+		req.Album = ""
+	}
+	return req
+}
+
+// Metadata of the cached item for the request, nil if it isn't cached.
+func (f *Fetcher) ItemMeta(req TaskRequest) (*ItemMeta, error) {
+	key, err := f.normalize(req).key()
+	if err != nil {
+		return nil, err
+	}
+	return readMeta(f.metadir, key)
+}
+
+// Reports whether the request can be served without a download.
+func (f *Fetcher) Cached(req TaskRequest) (bool, error) {
+	key, err := f.normalize(req).key()
+	if err != nil {
+		return false, err
+	}
+	path, err := util.FindFileWithPrefix(filepath.Join(f.datadir, key) + ".")
+	if err != nil {
+		return false, err
+	}
+	return path != "", nil
+}
+
+// Metadata of a uri, without downloading it. Waits for the running task.
+func (f *Fetcher) Info(ctx context.Context, uri string) (*Info, error) {
+	key := uri
+	if jar := cookies.FromContext(ctx); jar != nil {
+		key += "#c-" + jar.Name
+	}
+	if info := f.infos.get(key, time.Now()); info != nil {
+		return info, nil
+	}
+	respC := make(chan infoResponse, 1)
+	select {
+	case f.infoQueue <- infoRequest{ctx: ctx, key: key, uri: uri, respC: respC}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-f.ctx.Done():
+		return nil, fmt.Errorf("fetcher closed before looking up %s", uri)
+	}
+	resp := <-respC
+	return resp.info, resp.err
+}
+
+// Duration and live status of a YouTube video, without waiting for the worker.
+func (f *Fetcher) Details(ctx context.Context, uri string) (*Info, error) {
+	key := uri + "#d"
+	if info := f.infos.get(key, time.Now()); info != nil {
+		return info, nil
+	}
+	select {
+	case f.detailSem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-f.detailSem }()
+	ctx, cancel := context.WithTimeout(ctx, infoTimeout)
+	defer cancel()
+	info, err := f.details.Info(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	f.infos.put(key, info, time.Now())
+	return info, nil
+}
+
+// Runs on the worker.
+func (f *Fetcher) lookupInfo(ctx context.Context, key, uri string) (*Info, error) {
+	if info := f.infos.get(key, time.Now()); info != nil {
+		return info, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, infoTimeout)
+	defer cancel()
+	info, err := infoWithFallback(ctx, f.downloaders, uri)
+	if err != nil {
+		return nil, err
+	}
+	f.infos.put(key, info, time.Now())
+	return info, nil
+}
+
+// Chapters of the item as they are in its file.
+func (f *Fetcher) Chapters(ctx context.Context, req TaskRequest) ([]Chapter, error) {
+	req = f.normalize(req)
+	if meta, err := f.ItemMeta(req); err != nil {
+		return nil, err
+	} else if meta != nil && meta.Info != nil {
+		return meta.Chapters, nil
+	}
+
+	info, err := f.Info(cookies.WithJar(ctx, req.Cookies), req.Uri)
+	if err != nil {
+		return nil, err
+	}
+	chapters := info.chapters()
+	if req.SponsorBlock && len(chapters) > 0 {
+		// Like downloads, keep the segments if they can't be looked up.
+		segments, err := lookupSponsorSegments(ctx, f.pp.Client, f.pp.SponsorBlockAPI, info.ID, f.pp.SponsorBlockCategories)
+		if err != nil {
+			logger.Printf("sponsor segments of %s, keeping them: %s", info.ID, err)
+		}
+		chapters = cutChapters(chapters, segments)
+	}
+	profile, _ := f.pp.profile(req.Profile)
+	return profile.mapChapters(chapters), nil
+}
+
+// Reports whether requests may ask for the named profile.
+func (f *Fetcher) HasProfile(name string) bool {
+	_, ok := f.pp.profile(name)
+	return ok
+}
+
+// Mime type the named profile makes, empty if it keeps the format.
+func (f *Fetcher) ProfileMimeType(name string) string {
+	p, _ := f.pp.profile(name)
+	return p.MimeType()
+}
+
+// Mime type the request is served with, empty if unknown.
+func (f *Fetcher) MimeType(req TaskRequest) string {
+	req = f.normalize(req)
+	if key, err := req.key(); err == nil {
+		if path, err := util.FindFileWithPrefix(filepath.Join(f.datadir, key) + "."); err == nil && path != "" {
+			return MimeType(path)
+		}
+	}
+	if t := f.ProfileMimeType(req.Profile); t != "" {
+		return t
+	}
+	if ds := f.downloadersFor(req.Src); len(ds) > 0 {
+		return defaultMimeType(ds[0])
+	}
+	return ""
+}
+
+// Detected state of every configured downloader, in fallback order.
+func (f *Fetcher) Downloaders() []DownloaderStatus {
+	return f.statuses
+}
+
+// Returns false if the job is unknown or has been forgotten.
+func (f *Fetcher) Job(id int64) (JobStatus, bool) {
+	return f.jobs.get(id)
+}
+
+// Streams every job change. Call the returned func to stop.
+func (f *Fetcher) SubscribeJobs() (<-chan JobStatus, func()) {
+	return f.jobs.subscribe()
+}
+
+// Stops accepting tasks and kills the running download, if any.
+func (f *Fetcher) Close() {
+	f.cancel()
 }
@@ -0,0 +1,146 @@
+package content
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nlsun/rss-reflector/pkg/upstream"
+)
+
+// Downloads files as they are, such as podcast enclosures.
+type directDownloader struct {
+	client   *upstream.Client // Client for all requests
+	maxBytes int64            // Largest file downloaded, 0 for no limit
+}
+
+// Bytes between progress reports.
+const directProgressStep = 1 << 20
+
+func newDirectDownloader(client *upstream.Client, maxBytes int64) *directDownloader {
+	return &directDownloader{client: client, maxBytes: maxBytes}
+}
+
+func (pp PostprocessConfig) directClient() *upstream.Client {
+	if pp.DirectClient != nil {
+		return pp.DirectClient
+	}
+	return pp.Client
+}
+
+func (d *directDownloader) Name() string {
+	return Direct
+}
+
+func (d *directDownloader) Version(ctx context.Context) (string, error) {
+	return "builtin", nil
+}
+
+func (d *directDownloader) Info(ctx context.Context, uri string) (*Info, error) {
+	return &Info{WebpageURL: uri}, nil
+}
+
+func (d *directDownloader) Download(ctx context.Context, uri, outPrefix string, progress func(Progress)) (*Result, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("resp status %s", resp.Status)
+	}
+	if d.maxBytes > 0 && resp.ContentLength > d.maxBytes {
+		return nil, fmt.Errorf("%s is %d bytes, more than the limit of %d", uri, resp.ContentLength, d.maxBytes)
+	}
+
+	dst := outPrefix + directExt(u, resp.Header.Get("Content-Type"))
+	out, err := os.Create(dst)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+	w := &progressWriter{w: out, total: resp.ContentLength, start: time.Now(), progress: progress}
+	var body io.Reader = resp.Body
+	if d.maxBytes > 0 {
+		// The length may be missing or wrong, so the copy is limited too.
+		body = io.LimitReader(resp.Body, d.maxBytes+1)
+	}
+	n, err := io.Copy(w, body)
+	if err != nil {
+		return nil, err
+	}
+	if d.maxBytes > 0 && n > d.maxBytes {
+		out.Close()
+		os.Remove(dst)
+		return nil, fmt.Errorf("%s is more than the limit of %d bytes", uri, d.maxBytes)
+	}
+	w.report()
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	return &Result{Path: dst, Info: &Info{WebpageURL: uri}}, nil
+}
+
+// Extension from the url if it looks like media, else the content type.
+func directExt(u *url.URL, contentType string) string {
+	if ext := strings.ToLower(path.Ext(u.Path)); knownMimeType(ext) != "" {
+		return ext
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if ext := extension(mediaType); ext != "" {
+		return ext
+	}
+	return ".bin"
+}
+
+type progressWriter struct {
+	w        io.Writer
+	total    int64 // Expected size, -1 if unknown
+	done     int64
+	reported int64
+	start    time.Time
+	progress func(Progress)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.done += int64(n)
+	if p.done-p.reported >= directProgressStep {
+		p.report()
+	}
+	return n, err
+}
+
+func (p *progressWriter) report() {
+	p.reported = p.done
+	prog := Progress{Phase: PhaseDownload}
+	if p.total > 0 {
+		prog.TotalBytes = p.total
+		prog.Percent = float64(p.done) * 100 / float64(p.total)
+	}
+	if secs := time.Since(p.start).Seconds(); secs > 0 {
+		prog.Speed = int64(float64(p.done) / secs)
+		if p.total > 0 && prog.Speed > 0 {
+			prog.ETA = (p.total - p.done) / prog.Speed
+		}
+	}
+	p.progress(prog)
+}
@@ -0,0 +1,90 @@
+package content
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nlsun/rss-reflector/pkg/upstream"
+)
+
+func TestDirectExt(t *testing.T) {
+	tests := []struct {
+		url         string
+		contentType string
+		want        string
+	}{
+		{"https://example.com/ep1.mp3", "application/octet-stream", ".mp3"},
+		{"https://example.com/ep1.M4A?x=1", "", ".m4a"},
+		{"https://example.com/media?id=1", "audio/mpeg", ".mp3"},
+		{"https://example.com/media?id=1", "audio/mp4; charset=binary", ".m4a"},
+		{"https://example.com/media?id=1", "audio/ogg", ".opus"},
+		{"https://example.com/ep1.php", "text/html", ".bin"},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		// The extension must be the same every time for a file to be found
+		// in the cache again.
+		for i := 0; i < 10; i++ {
+			if got := directExt(u, tt.contentType); got != tt.want {
+				t.Fatalf("directExt(%s, %q) = %s, want %s", tt.url, tt.contentType, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestDirectDownloadSizeLimit(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("chunked") != "" {
+			// No Content-Length, so only the copy can catch it.
+			w.(http.Flusher).Flush()
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	client, err := upstream.New(upstream.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		maxBytes int64
+		wantErr  bool
+	}{
+		{name: "no limit"},
+		{name: "under the limit", maxBytes: 100},
+		{name: "length over the limit", maxBytes: 99, wantErr: true},
+		{name: "body over the limit", query: "?chunked=1", maxBytes: 99, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDirectDownloader(client, tt.maxBytes)
+			outPrefix := filepath.Join(t.TempDir(), "out")
+			res, err := d.Download(context.Background(), srv.URL+"/ep1.mp3"+tt.query, outPrefix, func(Progress) {})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("downloaded a file over the limit")
+				}
+				if _, err := os.Stat(outPrefix + ".mp3"); !os.IsNotExist(err) {
+					t.Error("file over the limit left behind")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b, _ := ioutil.ReadFile(res.Path); string(b) != body {
+				t.Errorf("downloaded %d bytes", len(b))
+			}
+		})
+	}
+}
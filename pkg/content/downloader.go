@@ -0,0 +1,163 @@
+package content
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/shlex"
+
+	"github.com/nlsun/rss-reflector/pkg/upstream"
+	"github.com/nlsun/rss-reflector/pkg/util"
+)
+
+// A backend that knows how to turn a content uri into a media file.
+type Downloader interface {
+	// Name of the backend, e.g. "youtube-dl".
+	Name() string
+	// Detects the backend version, failing if the backend is unusable.
+	Version(ctx context.Context) (string, error)
+	// Downloads uri into a file whose name starts with outPrefix.
+	Download(ctx context.Context, uri, outPrefix string, progress func(Progress)) (*Result, error)
+	// Looks up metadata without downloading.
+	Info(ctx context.Context, uri string) (*Info, error)
+}
+
+type Result struct {
+	Path      string     // The downloaded file
+	Info      *Info      // Metadata, nil if the backend had none
+	Subtitles []Subtitle // Subtitle files in order of preference
+}
+
+type Subtitle struct {
+	Lang string // Language code
+	Path string // WebVTT file
+}
+
+type DownloaderConfig struct {
+	Name    string // Backend name
+	Path    string // Path to the executable, ffmpeg for the native backend
+	Flags   string // Command line flags, replaces the backend defaults
+	BaseURL string // Site to download from, only used by the native backend
+	// Subtitle languages to fetch along with the media, by preference.
+	SubtitleLangs []string
+	// Makes the native backend's requests. Optional.
+	Client *upstream.Client
+}
+
+// The backend couldn't make sense of the uri, another one may.
+type ExtractorError struct {
+	Downloader string // Backend that failed
+	Msg        string // Error printed by the backend
+}
+
+// The detected state of a configured backend.
+type DownloaderStatus struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+const (
+	YoutubeDL string = "youtube-dl"
+	YtDlp     string = "yt-dlp"
+	Native    string = "native"
+	// Plain downloads of media urls, always available.
+	Direct string = "direct"
+)
+
+func (e *ExtractorError) Error() string {
+	return fmt.Sprintf("%s extractor error: %s", e.Downloader, e.Msg)
+}
+
+func NewDownloader(cfg DownloaderConfig) (Downloader, error) {
+	var d *commandDownloader
+	switch cfg.Name {
+	case Native:
+		return newNativeDownloader(cfg.BaseURL, cfg.Path, cfg.SubtitleLangs, cfg.Client), nil
+	case YoutubeDL:
+		d = newYoutubeDL(cfg.Path)
+	case YtDlp:
+		d = newYtDlp(cfg.Path)
+	default:
+		return nil, fmt.Errorf("unknown downloader %s", cfg.Name)
+	}
+	d.subLangs = cfg.SubtitleLangs
+	d.client = cfg.Client
+	if cfg.Flags != "" {
+		flags, err := shlex.Split(cfg.Flags)
+		if err != nil {
+			return nil, err
+		}
+		d.flags = flags
+	}
+	return d, nil
+}
+
+// Mime type of the files a backend makes, empty if it depends on the media.
+func defaultMimeType(d Downloader) string {
+	switch d := d.(type) {
+	case *nativeDownloader:
+		return MimeType("." + nativeContainers[0].ext)
+	case *commandDownloader:
+		for i, flag := range d.flags {
+			if flag == "--audio-format" && i+1 < len(d.flags) {
+				return MimeType("." + d.flags[i+1])
+			}
+		}
+	}
+	return ""
+}
+
+// Moves on to the next downloader only on extractor errors.
+func downloadWithFallback(ctx context.Context, downloaders []Downloader, uri, outPrefix string, progress func(Progress)) (*Result, error) {
+	var errs []string
+	for _, d := range downloaders {
+		res, err := d.Download(ctx, uri, outPrefix, progress)
+		var extErr *ExtractorError
+		if err == nil {
+			return res, nil
+		} else if !errors.As(err, &extErr) {
+			return nil, err
+		}
+		logger.Printf("%s, trying next downloader", err)
+		errs = append(errs, err.Error())
+		// Don't let the next backend trip over partial files.
+		if err := removeWithPrefix(outPrefix); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("all downloaders failed:\n%s", strings.Join(errs, "\n"))
+}
+
+// Like downloadWithFallback but for metadata.
+func infoWithFallback(ctx context.Context, downloaders []Downloader, uri string) (*Info, error) {
+	var errs []string
+	for _, d := range downloaders {
+		info, err := d.Info(ctx, uri)
+		var extErr *ExtractorError
+		if err == nil {
+			return info, nil
+		} else if !errors.As(err, &extErr) {
+			return nil, err
+		}
+		logger.Printf("%s, trying next downloader", err)
+		errs = append(errs, err.Error())
+	}
+	return nil, fmt.Errorf("all downloaders failed:\n%s", strings.Join(errs, "\n"))
+}
+
+func removeWithPrefix(prefix string) error {
+	for {
+		f, err := util.FindFileWithPrefix(prefix)
+		if err != nil || f == "" {
+			return err
+		}
+		logger.Printf("removing stale tmp file %s", f)
+		if err := os.RemoveAll(f); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package content
+
+import (
+	"container/list"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metadata about a video, as in youtube-dl's info json.
+type Info struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Uploader    string    `json:"uploader,omitempty"`
+	ChannelID   string    `json:"channel_id,omitempty"`
+	UploadDate  string    `json:"upload_date,omitempty"` // YYYYMMDD
+	Duration    float64   `json:"duration,omitempty"`    // Seconds
+	Thumbnail   string    `json:"thumbnail,omitempty"`
+	WebpageURL  string    `json:"webpage_url,omitempty"`
+	IsLive      bool      `json:"is_live,omitempty"`
+	LiveStatus  string    `json:"live_status,omitempty"` // not_live, is_upcoming, is_live, post_live or was_live
+	Chapters    []Chapter `json:"chapters,omitempty"`
+}
+
+// Recent lookups, least recently used first out. They expire as live
+// streams and premieres change.
+type infoCache struct {
+	mu    sync.Mutex
+	order *list.List               // Of *infoEntry, most recently used first
+	infos map[string]*list.Element // By uri, and jar when looked up with one
+}
+
+type infoEntry struct {
+	key     string
+	info    *Info
+	expires time.Time
+}
+
+// A chapter, times are in seconds.
+type Chapter struct {
+	Start float64 `json:"start_time"`
+	End   float64 `json:"end_time"`
+	Title string  `json:"title"`
+}
+
+// Live, upcoming or recorded from a live stream.
+func (i *Info) Live() bool {
+	switch i.LiveStatus {
+	case "is_upcoming", "is_live", "post_live", "was_live":
+		return true
+	}
+	return i.IsLive
+}
+
+const (
+	// Lookups to remember.
+	maxCachedInfos = 1000
+	// How long a lookup is good for.
+	infoTTL = 6 * time.Hour
+)
+
+// "1:02:03 Title", "12:34 - Title" or "Title (12:34)"
+var (
+	leadingTimestampRe  = regexp.MustCompile(`^\s*(?:\d+:)?\d{1,2}:\d{2}\b`)
+	trailingTimestampRe = regexp.MustCompile(`\(?\b(?:\d+:)?\d{1,2}:\d{2}\)?\s*$`)
+	titleTrimChars      = " \t-–—:|•"
+)
+
+func newInfoCache() *infoCache {
+	return &infoCache{order: list.New(), infos: map[string]*list.Element{}}
+}
+
+// Nil if the lookup isn't cached or has expired.
+func (c *infoCache) get(key string, now time.Time) *Info {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.infos[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*infoEntry)
+	if now.After(e.expires) {
+		c.order.Remove(el)
+		delete(c.infos, key)
+		return nil
+	}
+	c.order.MoveToFront(el)
+	return e.info
+}
+
+func (c *infoCache) put(key string, info *Info, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.infos[key]; ok {
+		c.order.Remove(el)
+	}
+	c.infos[key] = c.order.PushFront(&infoEntry{key: key, info: info, expires: now.Add(infoTTL)})
+	for c.order.Len() > maxCachedInfos {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.infos, oldest.Value.(*infoEntry).key)
+	}
+}
+
+// Chapters from the metadata, else from the description.
+func (i *Info) chapters() []Chapter {
+	if len(i.Chapters) > 0 {
+		return i.Chapters
+	}
+	return ParseDescriptionChapters(i.Description, i.Duration)
+}
+
+// Follows YouTube's rules: at least two timestamps, the first at zero.
+func ParseDescriptionChapters(description string, duration float64) []Chapter {
+	var chapters []Chapter
+	for _, line := range strings.Split(description, "\n") {
+		var ts, title string
+		if m := leadingTimestampRe.FindString(line); m != "" {
+			ts, title = m, line[len(m):]
+		} else if m := trailingTimestampRe.FindString(line); m != "" {
+			ts, title = m, line[:len(line)-len(m)]
+		} else {
+			continue
+		}
+		title = strings.Trim(title, titleTrimChars)
+		start := parseClock(strings.Trim(ts, " ()"))
+		if title == "" || (len(chapters) > 0 && float64(start) <= chapters[len(chapters)-1].Start) {
+			continue
+		}
+		chapters = append(chapters, Chapter{Start: float64(start), Title: title})
+	}
+	if len(chapters) < 2 || chapters[0].Start != 0 {
+		return nil
+	}
+	for i := range chapters {
+		if i+1 < len(chapters) {
+			chapters[i].End = chapters[i+1].Start
+		} else {
+			chapters[i].End = duration
+		}
+	}
+	return chapters
+}
+
+// Shifts chapters past cut segments, dropping the ones cut entirely.
+func cutChapters(chapters []Chapter, cuts []Segment) []Chapter {
+	if len(cuts) == 0 {
+		return chapters
+	}
+	var out []Chapter
+	for _, c := range chapters {
+		start, _ := cutTime(c.Start, cuts)
+		end, _ := cutTime(c.End, cuts)
+		if c.End == 0 {
+			end = 0
+		} else if end <= start {
+			continue
+		}
+		out = append(out, Chapter{Start: start, End: end, Title: c.Title})
+	}
+	return out
+}
+
+// Where a time ends up once the cuts are removed, false if it was cut.
+func cutTime(t float64, cuts []Segment) (float64, bool) {
+	removed := 0.0
+	kept := true
+	for _, c := range cuts {
+		if t >= c.End {
+			removed += c.End - c.Start
+		} else if t > c.Start {
+			removed += t - c.Start
+			kept = false
+		}
+	}
+	return t - removed, kept
+}
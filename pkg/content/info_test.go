@@ -0,0 +1,208 @@
+package content
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nlsun/rss-reflector/pkg/upstream"
+)
+
+func TestInfoCache(t *testing.T) {
+	c := newInfoCache()
+	now := time.Now()
+	for i := 0; i < maxCachedInfos; i++ {
+		c.put(fmt.Sprint(i), &Info{ID: fmt.Sprint(i)}, now)
+	}
+	// Using the oldest keeps it when the next one makes room.
+	if c.get("0", now) == nil {
+		t.Fatal("0 missing")
+	}
+	c.put("new", &Info{ID: "new"}, now)
+	if c.get("0", now) == nil {
+		t.Error("recently used 0 evicted")
+	}
+	if c.get("1", now) != nil {
+		t.Error("least recently used 1 kept")
+	}
+	if c.get("new", now) == nil {
+		t.Error("new missing")
+	}
+	if c.order.Len() != maxCachedInfos || len(c.infos) != maxCachedInfos {
+		t.Errorf("%d and %d cached, want %d", c.order.Len(), len(c.infos), maxCachedInfos)
+	}
+
+	if c.get("new", now.Add(infoTTL+time.Second)) != nil {
+		t.Error("expired lookup returned")
+	}
+	if _, ok := c.infos["new"]; ok {
+		t.Error("expired lookup kept")
+	}
+}
+
+// Counts how many lookups run at once.
+type countingDownloader struct {
+	running, most, calls int32
+}
+
+func (d *countingDownloader) Name() string { return "counting" }
+
+func (d *countingDownloader) Version(ctx context.Context) (string, error) { return "1", nil }
+
+func (d *countingDownloader) Download(ctx context.Context, uri, outPrefix string, progress func(Progress)) (*Result, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (d *countingDownloader) Info(ctx context.Context, uri string) (*Info, error) {
+	atomic.AddInt32(&d.calls, 1)
+	n := atomic.AddInt32(&d.running, 1)
+	defer atomic.AddInt32(&d.running, -1)
+	for {
+		most := atomic.LoadInt32(&d.most)
+		if n <= most || atomic.CompareAndSwapInt32(&d.most, most, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return &Info{ID: uri}, nil
+}
+
+func TestFetcherInfoOneAtATime(t *testing.T) {
+	d := &countingDownloader{}
+	f, err := NewFetcher(t.TempDir(), []Downloader{d}, PostprocessConfig{}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Two requests for each uri.
+			uri := fmt.Sprint(i / 2)
+			info, err := f.Info(context.Background(), uri)
+			if err != nil || info.ID != uri {
+				t.Errorf("info of %s: %+v, %v", uri, info, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if d.most != 1 {
+		t.Errorf("%d lookups ran at once", d.most)
+	}
+	if d.calls != 4 {
+		t.Errorf("%d lookups for 4 uris", d.calls)
+	}
+}
+
+func TestFetcherDetailsBesideTask(t *testing.T) {
+	yt := newFakeYoutube(t, "OK", nil)
+	client, err := upstream.New(upstream.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newNativeDownloader(yt.URL, "", nil, client)
+	f, err := NewFetcher(t.TempDir(), []Downloader{d}, PostprocessConfig{Client: client}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The task has no formats to download, and holds the worker until it
+	// is finished.
+	uri := "https://www.youtube.com/watch?v=abcdefghijk"
+	if _, err := f.SubmitTask(context.Background(), TaskRequest{Src: YoutubeSource, Uri: uri}); err == nil {
+		t.Fatal("downloaded without formats")
+	}
+	defer f.FinishTask()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	info, err := f.Details(ctx, uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Duration != 42 || info.Live() {
+		t.Errorf("got duration %v live %v", info.Duration, info.Live())
+	}
+}
+
+func TestParseDescriptionChapters(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		want        []Chapter
+	}{
+		{
+			name:        "leading and trailing timestamps",
+			description: "Intro text\n0:00 - Intro\nThe middle part (2:30)\n1:02:03 | The end\nThanks for watching",
+			want: []Chapter{
+				{Start: 0, End: 150, Title: "Intro"},
+				{Start: 150, End: 3723, Title: "The middle part"},
+				{Start: 3723, End: 4000, Title: "The end"},
+			},
+		},
+		{
+			name:        "out of order and untitled lines skipped",
+			description: "00:00 Start\n02:00\n05:00 Middle\n03:00 Earlier than the last\n10:00 Later",
+			want: []Chapter{
+				{Start: 0, End: 300, Title: "Start"},
+				{Start: 300, End: 600, Title: "Middle"},
+				{Start: 600, End: 4000, Title: "Later"},
+			},
+		},
+		{name: "not starting at zero", description: "0:10 One\n0:20 Two"},
+		{name: "a single timestamp", description: "0:00 Only"},
+		{name: "no timestamps", description: "Just words"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseDescriptionChapters(tt.description, 4000); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCutChapters(t *testing.T) {
+	chapters := []Chapter{
+		{Start: 0, End: 10, Title: "Intro"},
+		{Start: 10, End: 20, Title: "Sponsor"},
+		{Start: 20, End: 60, Title: "Talk"},
+	}
+	// The sponsor chapter goes entirely, and 5s in the middle of the talk.
+	cuts := []Segment{{Start: 10, End: 20}, {Start: 30, End: 35}}
+	want := []Chapter{
+		{Start: 0, End: 10, Title: "Intro"},
+		{Start: 10, End: 45, Title: "Talk"},
+	}
+	if got := cutChapters(chapters, cuts); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// A cut across a chapter boundary moves the next chapter to its start.
+	cuts = []Segment{{Start: 5, End: 15}}
+	want = []Chapter{
+		{Start: 0, End: 5, Title: "Intro"},
+		{Start: 5, End: 10, Title: "Sponsor"},
+		{Start: 10, End: 50, Title: "Talk"},
+	}
+	if got := cutChapters(chapters, cuts); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Without an end the last chapter runs to the end of the file.
+	open := []Chapter{{Start: 0, Title: "All"}}
+	if got := cutChapters(open, cuts); !reflect.DeepEqual(got, open) {
+		t.Errorf("got %+v, want %+v", got, open)
+	}
+	if got := cutChapters(chapters, nil); !reflect.DeepEqual(got, chapters) {
+		t.Errorf("no cuts: got %+v", got)
+	}
+}
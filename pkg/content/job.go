@@ -0,0 +1,173 @@
+package content
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+type JobState string
+
+type JobStatus struct {
+	ID       int64      `json:"id"`                 // Unique job id
+	Src      Source     `json:"source"`             // Content source
+	Uri      string     `json:"uri"`                // Content uri
+	State    JobState   `json:"state"`              // Current state
+	Error    string     `json:"error,omitempty"`    // Failure reason
+	Progress *Progress  `json:"progress,omitempty"` // Latest download progress
+	Started  time.Time  `json:"started"`            // When the task began
+	Finished *time.Time `json:"finished,omitempty"` // When the task ended
+}
+
+// Keeps track of the running task and a short history of finished ones.
+type jobTracker struct {
+	mu     sync.Mutex
+	nextID int64
+	jobs   map[int64]*JobStatus
+	subs   map[chan JobStatus]struct{} // Receivers of job updates
+}
+
+const (
+	JobRunning  JobState = "running"
+	JobDone     JobState = "done"
+	JobFailed   JobState = "failed"
+	JobTimeout  JobState = "timeout"
+	JobCanceled JobState = "canceled"
+
+	// Number of finished jobs to remember.
+	maxJobHistory = 50
+
+	// Updates buffered per subscriber before they start being dropped.
+	subBufferSize = 64
+)
+
+func newJobTracker() *jobTracker {
+	return &jobTracker{
+		jobs: map[int64]*JobStatus{},
+		subs: map[chan JobStatus]struct{}{},
+	}
+}
+
+func (t *jobTracker) start(req TaskRequest) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextID++
+	t.jobs[t.nextID] = &JobStatus{
+		ID:      t.nextID,
+		Src:     req.Src,
+		Uri:     req.Uri,
+		State:   JobRunning,
+		Started: time.Now(),
+	}
+	t.prune()
+	t.publish(t.jobs[t.nextID])
+	return t.nextID
+}
+
+func (t *jobTracker) progress(id int64, p Progress) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job, ok := t.jobs[id]
+	if !ok {
+		return
+	}
+	job.Progress = &p
+	t.publish(job)
+}
+
+func (t *jobTracker) finish(id int64, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job, ok := t.jobs[id]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	job.Finished = &now
+	job.State = jobStateFromErr(err)
+	if err != nil {
+		job.Error = err.Error()
+	}
+	t.publish(job)
+}
+
+// Must be called with the lock held. Slow subscribers miss updates.
+func (t *jobTracker) publish(job *JobStatus) {
+	for c := range t.subs {
+		select {
+		case c <- *job:
+		default:
+		}
+	}
+}
+
+func (t *jobTracker) subscribe() (<-chan JobStatus, func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := make(chan JobStatus, subBufferSize)
+	t.subs[c] = struct{}{}
+	return c, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.subs, c)
+	}
+}
+
+func (t *jobTracker) get(id int64) (JobStatus, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job, ok := t.jobs[id]
+	if !ok {
+		return JobStatus{}, false
+	}
+	return *job, true
+}
+
+// Drops the oldest finished jobs once the history grows past its limit.
+func (t *jobTracker) prune() {
+	if len(t.jobs) <= maxJobHistory {
+		return
+	}
+	for _, job := range t.sorted() {
+		if len(t.jobs) <= maxJobHistory {
+			return
+		}
+		if job.Finished != nil {
+			delete(t.jobs, job.ID)
+		}
+	}
+}
+
+func (t *jobTracker) sorted() []*JobStatus {
+	jobs := make([]*JobStatus, 0, len(t.jobs))
+	for _, job := range t.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs
+}
+
+func (t *jobTracker) list() []JobStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := []JobStatus{}
+	for _, job := range t.sorted() {
+		out = append(out, *job)
+	}
+	return out
+}
+
+func jobStateFromErr(err error) JobState {
+	switch {
+	case err == nil:
+		return JobDone
+	case errors.Is(err, context.DeadlineExceeded):
+		return JobTimeout
+	case errors.Is(err, context.Canceled):
+		return JobCanceled
+	default:
+		return JobFailed
+	}
+}
@@ -0,0 +1,15 @@
+//go:build windows
+// +build windows
+
+package content
+
+import (
+	"os"
+
+	"github.com/nlsun/rss-reflector/pkg/util"
+)
+
+// There is no flock here, so nothing is locked.
+func lockFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, util.DefaultFilePerm)
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package content
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/nlsun/rss-reflector/pkg/util"
+)
+
+// Takes an exclusive lock on the file at path, released on close.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, util.DefaultFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, ErrCacheLocked
+		}
+		return nil, err
+	}
+	return f, nil
+}
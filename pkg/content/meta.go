@@ -0,0 +1,60 @@
+package content
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/nlsun/rss-reflector/pkg/util"
+)
+
+// What we know about a cached item, stored under the key of its data file.
+type ItemMeta struct {
+	Src             Source    `json:"source"`                     // Content source
+	Uri             string    `json:"uri"`                        // Content uri
+	SponsorBlock    bool      `json:"sponsorblock,omitempty"`     // Whether sponsor segments were looked up
+	SponsorSegments []Segment `json:"sponsor_segments,omitempty"` // Segments cut from the audio
+	Album           string    `json:"album,omitempty"`            // Album the file was tagged with
+	Profile         string    `json:"profile,omitempty"`          // Processing profile applied
+	Info            *Info     `json:"info,omitempty"`             // Metadata reported by the downloader
+	Chapters        []Chapter `json:"chapters,omitempty"`         // Chapters as they are in the file
+	TranscriptLang  string    `json:"transcript_lang,omitempty"`  // Language of the stored transcript
+}
+
+func metaPath(metadir, key string) string {
+	return filepath.Join(metadir, key+".json")
+}
+
+func writeMeta(metadir, key string, meta *ItemMeta) error {
+	b, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(metaPath(metadir, key), b, util.DefaultFilePerm)
+}
+
+// Returns nil if there is no metadata for the key.
+func readMeta(metadir, key string) (*ItemMeta, error) {
+	b, err := ioutil.ReadFile(metaPath(metadir, key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var meta ItemMeta
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// Removes the metadata and everything stored alongside it.
+func removeMeta(metadir, key string) error {
+	for _, path := range []string{metaPath(metadir, key), transcriptPath(metadir, key)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package content
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// Small servers often lack a mime database. Files are saved with the first
+// extension of their type.
+var mimeTypes = []struct {
+	ext      string
+	mimeType string
+}{
+	{".mp3", "audio/mpeg"},
+	{".m4a", "audio/mp4"},
+	{".aac", "audio/aac"},
+	{".opus", "audio/ogg"},
+	{".ogg", "audio/ogg"},
+	{".webm", "audio/webm"},
+	{".flac", "audio/flac"},
+	{".wav", "audio/wav"},
+	{".m4b", "audio/mp4"},
+	{".mp4", "video/mp4"},
+}
+
+// Mime type of a content file based on its extension. Empty if unknown.
+func MimeType(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if t := knownMimeType(ext); t != "" {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+func knownMimeType(ext string) string {
+	for _, m := range mimeTypes {
+		if m.ext == ext {
+			return m.mimeType
+		}
+	}
+	return ""
+}
+
+// Extension files of a mime type are saved with, empty if unknown.
+func extension(mimeType string) string {
+	for _, m := range mimeTypes {
+		if m.mimeType == mimeType {
+			return m.ext
+		}
+	}
+	return ""
+}
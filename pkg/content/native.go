@@ -0,0 +1,425 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nlsun/rss-reflector/pkg/upstream"
+)
+
+// Downloads audio straight from YouTube. Streams without a plain url are
+// extractor errors, so the next downloader takes over.
+type nativeDownloader struct {
+	baseURL  string           // YouTube base url, replaceable for testing
+	ffmpeg   string           // Path to ffmpeg for remuxing, empty to skip it
+	subLangs []string         // Caption languages to fetch
+	client   *upstream.Client // Client for all requests
+}
+
+type playerResponse struct {
+	PlayabilityStatus struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	} `json:"playabilityStatus"`
+	StreamingData struct {
+		AdaptiveFormats []adaptiveFormat `json:"adaptiveFormats"`
+	} `json:"streamingData"`
+	VideoDetails struct {
+		VideoID          string `json:"videoId"`
+		Title            string `json:"title"`
+		LengthSeconds    string `json:"lengthSeconds"`
+		ChannelID        string `json:"channelId"`
+		ShortDescription string `json:"shortDescription"`
+		Author           string `json:"author"`
+		IsLive           bool   `json:"isLive"`
+		IsLiveContent    bool   `json:"isLiveContent"` // Streamed live, now or in the past
+		IsUpcoming       bool   `json:"isUpcoming"`
+		Thumbnail        struct {
+			Thumbnails []struct {
+				URL   string `json:"url"`
+				Width int    `json:"width"`
+			} `json:"thumbnails"`
+		} `json:"thumbnail"`
+	} `json:"videoDetails"`
+	Captions struct {
+		PlayerCaptionsTracklistRenderer struct {
+			CaptionTracks []captionTrack `json:"captionTracks"`
+		} `json:"playerCaptionsTracklistRenderer"`
+	} `json:"captions"`
+	Microformat struct {
+		PlayerMicroformatRenderer struct {
+			UploadDate string `json:"uploadDate"` // YYYY-MM-DD
+		} `json:"playerMicroformatRenderer"`
+	} `json:"microformat"`
+}
+
+type captionTrack struct {
+	BaseURL      string `json:"baseUrl"`
+	LanguageCode string `json:"languageCode"`
+	Kind         string `json:"kind"` // "asr" for automatic captions
+}
+
+type adaptiveFormat struct {
+	Itag          int    `json:"itag"`
+	URL           string `json:"url"`
+	MimeType      string `json:"mimeType"`
+	Bitrate       int    `json:"bitrate"`
+	ContentLength string `json:"contentLength"`
+}
+
+const (
+	NativeBaseURL string = "https://www.youtube.com"
+
+	// Responses to this client carry plain stream urls.
+	innertubeClientName    = "ANDROID"
+	innertubeClientVersion = "19.09.37"
+	innertubeUserAgent     = "com.google.android.youtube/19.09.37 (Linux; U; Android 11) gzip"
+
+	// Streams are fetched in ranges, larger requests get throttled.
+	nativeChunkSize = 10 << 20
+)
+
+// Stream containers by preference, with their extension once remuxed.
+var nativeContainers = []struct {
+	mimeType string
+	rawExt   string
+	ext      string
+}{
+	{"audio/mp4", "m4a", "m4a"},
+	{"audio/webm", "webm", "opus"},
+}
+
+func newNativeDownloader(baseURL, ffmpeg string, subLangs []string, client *upstream.Client) *nativeDownloader {
+	if baseURL == "" {
+		baseURL = NativeBaseURL
+	}
+	return &nativeDownloader{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		ffmpeg:   ffmpeg,
+		subLangs: subLangs,
+		client:   client,
+	}
+}
+
+func (d *nativeDownloader) Name() string {
+	return Native
+}
+
+func (d *nativeDownloader) Version(ctx context.Context) (string, error) {
+	if d.ffmpeg == "" {
+		return "builtin", nil
+	}
+	var version string
+	err := runCommand(ctx, func(line string) {
+		if version == "" {
+			version = strings.TrimSpace(line)
+		}
+	}, d.ffmpeg, "-version")
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg: %w", err)
+	}
+	return "builtin, " + version, nil
+}
+
+func (d *nativeDownloader) Download(ctx context.Context, uri, outPrefix string, progress func(Progress)) (*Result, error) {
+	progress(Progress{Phase: PhaseExtract})
+	player, err := d.playablePlayer(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	id := player.VideoDetails.VideoID
+	format, rawExt, ext := pickAudioFormat(player.StreamingData.AdaptiveFormats)
+	if format == nil {
+		return nil, &ExtractorError{Downloader: Native, Msg: fmt.Sprintf("video %s has no plain audio stream", id)}
+	}
+	logger.Printf("native downloading %s itag %d %s", id, format.Itag, format.MimeType)
+
+	rawf := outPrefix + ".raw." + rawExt
+	if err := d.fetchStream(ctx, format, rawf, progress); err != nil {
+		return nil, err
+	}
+	res := &Result{Path: outPrefix + "." + ext, Info: player.info()}
+	res.Subtitles = d.fetchCaptions(ctx, player, outPrefix)
+	if d.ffmpeg == "" {
+		res.Path = outPrefix + "." + rawExt
+		return res, os.Rename(rawf, res.Path)
+	}
+
+	progress(Progress{Phase: PhasePostprocess, Percent: 100})
+	if err := d.remux(ctx, rawf, res.Path); err != nil {
+		return nil, err
+	}
+	return res, os.Remove(rawf)
+}
+
+func (d *nativeDownloader) Info(ctx context.Context, uri string) (*Info, error) {
+	player, err := d.playablePlayer(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	return player.info(), nil
+}
+
+func (d *nativeDownloader) playablePlayer(ctx context.Context, uri string) (*playerResponse, error) {
+	id, err := YoutubeVideoID(uri)
+	if err != nil {
+		return nil, &ExtractorError{Downloader: Native, Msg: err.Error()}
+	}
+	player, err := d.player(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if player.PlayabilityStatus.Status != "OK" {
+		return nil, &ExtractorError{
+			Downloader: Native,
+			Msg:        fmt.Sprintf("video %s %s: %s", id, player.PlayabilityStatus.Status, player.PlayabilityStatus.Reason),
+		}
+	}
+	player.VideoDetails.VideoID = id
+	return player, nil
+}
+
+func (p *playerResponse) info() *Info {
+	v := p.VideoDetails
+	info := &Info{
+		ID:          v.VideoID,
+		Title:       v.Title,
+		Description: v.ShortDescription,
+		Uploader:    v.Author,
+		ChannelID:   v.ChannelID,
+		UploadDate:  strings.Replace(p.Microformat.PlayerMicroformatRenderer.UploadDate, "-", "", -1),
+		WebpageURL:  "https://www.youtube.com/watch?v=" + v.VideoID,
+		IsLive:      v.IsLive,
+	}
+	fmt.Sscan(v.LengthSeconds, &info.Duration)
+	switch {
+	case v.IsUpcoming:
+		info.LiveStatus = "is_upcoming"
+	case v.IsLive:
+		info.LiveStatus = "is_live"
+	case v.IsLiveContent:
+		info.LiveStatus = "was_live"
+	default:
+		info.LiveStatus = "not_live"
+	}
+	// Thumbnails are listed smallest first.
+	if thumbs := v.Thumbnail.Thumbnails; len(thumbs) > 0 {
+		info.Thumbnail = thumbs[len(thumbs)-1].URL
+	}
+	return info
+}
+
+func (d *nativeDownloader) player(ctx context.Context, id string) (*playerResponse, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"videoId": id,
+		"context": map[string]interface{}{
+			"client": map[string]interface{}{
+				"clientName":        innertubeClientName,
+				"clientVersion":     innertubeClientVersion,
+				"androidSdkVersion": 30,
+				"hl":                "en",
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, d.baseURL+"/youtubei/v1/player", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", innertubeUserAgent)
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &ExtractorError{Downloader: Native, Msg: fmt.Sprintf("player resp status %s", resp.Status)}
+	}
+
+	var player playerResponse
+	if err := json.NewDecoder(resp.Body).Decode(&player); err != nil {
+		return nil, &ExtractorError{Downloader: Native, Msg: fmt.Sprintf("player resp: %s", err)}
+	}
+	return &player, nil
+}
+
+// Picks the highest bitrate audio stream of the most preferred container.
+func pickAudioFormat(formats []adaptiveFormat) (*adaptiveFormat, string, string) {
+	sort.SliceStable(formats, func(i, j int) bool {
+		return formats[i].Bitrate > formats[j].Bitrate
+	})
+	for _, c := range nativeContainers {
+		for i := range formats {
+			if formats[i].URL != "" && strings.HasPrefix(formats[i].MimeType, c.mimeType) {
+				return &formats[i], c.rawExt, c.ext
+			}
+		}
+	}
+	return nil, "", ""
+}
+
+func (d *nativeDownloader) fetchStream(ctx context.Context, format *adaptiveFormat, dst string, progress func(Progress)) error {
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var total int64
+	fmt.Sscan(format.ContentLength, &total)
+	start := time.Now()
+	var done int64
+	for total == 0 || done < total {
+		req, err := http.NewRequest(http.MethodGet, format.URL, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("User-Agent", innertubeUserAgent)
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", done, done+nativeChunkSize-1))
+		resp, err := d.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			resp.Body.Close()
+			break
+		} else if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			resp.Body.Close()
+			return fmt.Errorf("stream resp status %s", resp.Status)
+		}
+		n, err := io.Copy(out, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		done += n
+		if total == 0 {
+			total = rangeTotal(resp)
+		}
+
+		p := Progress{Phase: PhaseDownload, TotalBytes: total}
+		if secs := time.Since(start).Seconds(); secs > 0 {
+			p.Speed = int64(float64(done) / secs)
+		}
+		if total > 0 {
+			p.Percent = float64(done) * 100 / float64(total)
+			if p.Speed > 0 {
+				p.ETA = (total - done) / p.Speed
+			}
+		}
+		progress(p)
+
+		// A full response or a short range means we are done.
+		if resp.StatusCode == http.StatusOK || n < nativeChunkSize {
+			break
+		}
+	}
+	return out.Close()
+}
+
+// Captions are nice to have, failing to get them doesn't fail the download.
+func (d *nativeDownloader) fetchCaptions(ctx context.Context, player *playerResponse, outPrefix string) []Subtitle {
+	var subs []Subtitle
+	tracks := player.Captions.PlayerCaptionsTracklistRenderer.CaptionTracks
+	for _, lang := range d.subLangs {
+		track := pickCaptionTrack(tracks, lang)
+		if track == nil {
+			continue
+		}
+		path := outPrefix + "." + lang + ".vtt"
+		if err := d.fetchToFile(ctx, track.BaseURL+"&fmt=vtt", path); err != nil {
+			logger.Printf("native captions %s: %s", lang, err)
+			continue
+		}
+		subs = append(subs, Subtitle{Lang: lang, Path: path})
+	}
+	return subs
+}
+
+// Prefers captions written by people over automatic ones.
+func pickCaptionTrack(tracks []captionTrack, lang string) *captionTrack {
+	var auto *captionTrack
+	for i, t := range tracks {
+		if t.LanguageCode != lang {
+			continue
+		}
+		if t.Kind != "asr" {
+			return &tracks[i]
+		}
+		auto = &tracks[i]
+	}
+	return auto
+}
+
+func (d *nativeDownloader) fetchToFile(ctx context.Context, uri, dst string) error {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", innertubeUserAgent)
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("resp status %s", resp.Status)
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Total size from a "Content-Range: bytes 0-99/1234" header.
+func rangeTotal(resp *http.Response) int64 {
+	var total int64
+	cr := resp.Header.Get("Content-Range")
+	if i := strings.LastIndex(cr, "/"); i >= 0 {
+		fmt.Sscan(cr[i+1:], &total)
+	}
+	return total
+}
+
+// Copies the audio into a regular container without re-encoding it.
+func (d *nativeDownloader) remux(ctx context.Context, src, dst string) error {
+	args := []string{"-i", src, "-vn", "-c:a", "copy"}
+	if strings.HasSuffix(dst, ".m4a") {
+		args = append(args, "-movflags", "+faststart")
+	}
+	return runFFmpeg(ctx, d.ffmpeg, append(args, dst)...)
+}
+
+// Finds the video id in watch, shorts and youtu.be urls.
+func YoutubeVideoID(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if id := u.Query().Get("v"); id != "" {
+		return id, nil
+	}
+	dir, id := path.Split(u.Path)
+	if id != "" && (u.Host == "youtu.be" || dir == "/shorts/" || dir == "/embed/") {
+		return id, nil
+	}
+	return "", fmt.Errorf("no video id in %s", uri)
+}
@@ -0,0 +1,204 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nlsun/rss-reflector/pkg/upstream"
+)
+
+// A fake YouTube that answers player requests with the given status and
+// formats. Stream urls point back at it, and serve what streams holds.
+type fakeYoutube struct {
+	*httptest.Server
+	status  string
+	formats []adaptiveFormat
+	streams map[string][]byte
+}
+
+func newFakeYoutube(t *testing.T, status string, formats []adaptiveFormat) *fakeYoutube {
+	f := &fakeYoutube{status: status, streams: map[string][]byte{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/youtubei/v1/player", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			VideoID string `json:"videoId"`
+		}
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&req) != nil {
+			http.Error(w, "bad player request", http.StatusBadRequest)
+			return
+		}
+		var resp playerResponse
+		resp.PlayabilityStatus.Status = f.status
+		resp.StreamingData.AdaptiveFormats = f.formats
+		resp.VideoDetails.Title = "Title of " + req.VideoID
+		resp.VideoDetails.Author = "Someone"
+		resp.VideoDetails.LengthSeconds = "42"
+		resp.Captions.PlayerCaptionsTracklistRenderer.CaptionTracks = []captionTrack{
+			{BaseURL: f.URL + "/captions?lang=en&kind=asr", LanguageCode: "en", Kind: "asr"},
+			{BaseURL: f.URL + "/captions?lang=en", LanguageCode: "en"},
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("/stream/", func(w http.ResponseWriter, r *http.Request) {
+		b, ok := f.streams[strings.TrimPrefix(r.URL.Path, "/stream/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+	})
+	mux.HandleFunc("/captions", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "WEBVTT\n\n00:00.000 --> 00:01.000\n%s %s\n", r.URL.Query().Get("kind"), r.URL.Query().Get("fmt"))
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Adds a stream and returns its format.
+func (f *fakeYoutube) stream(name, mimeType string, bitrate int, data []byte) adaptiveFormat {
+	f.streams[name] = data
+	return adaptiveFormat{
+		URL:           f.URL + "/stream/" + name,
+		MimeType:      mimeType,
+		Bitrate:       bitrate,
+		ContentLength: fmt.Sprint(len(data)),
+	}
+}
+
+func newTestNative(t *testing.T, baseURL string, subLangs []string) *nativeDownloader {
+	client, err := upstream.New(upstream.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newNativeDownloader(baseURL, "", subLangs, client)
+}
+
+func TestPickAudioFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		formats  []adaptiveFormat
+		wantItag int
+		wantExt  string
+	}{
+		{
+			name: "mp4 preferred over a better webm",
+			formats: []adaptiveFormat{
+				{Itag: 251, URL: "u", MimeType: `audio/webm; codecs="opus"`, Bitrate: 160000},
+				{Itag: 140, URL: "u", MimeType: `audio/mp4; codecs="mp4a.40.2"`, Bitrate: 128000},
+			},
+			wantItag: 140,
+			wantExt:  "m4a",
+		},
+		{
+			name: "highest bitrate of the container",
+			formats: []adaptiveFormat{
+				{Itag: 139, URL: "u", MimeType: "audio/mp4", Bitrate: 48000},
+				{Itag: 140, URL: "u", MimeType: "audio/mp4", Bitrate: 128000},
+			},
+			wantItag: 140,
+			wantExt:  "m4a",
+		},
+		{
+			name: "streams without a url skipped",
+			formats: []adaptiveFormat{
+				{Itag: 140, MimeType: "audio/mp4", Bitrate: 128000},
+				{Itag: 251, URL: "u", MimeType: "audio/webm", Bitrate: 160000},
+			},
+			wantItag: 251,
+			wantExt:  "opus",
+		},
+		{
+			name: "video only",
+			formats: []adaptiveFormat{
+				{Itag: 137, URL: "u", MimeType: "video/mp4", Bitrate: 4000000},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, _, ext := pickAudioFormat(tt.formats)
+			if tt.wantItag == 0 {
+				if format != nil {
+					t.Fatalf("got itag %d, want none", format.Itag)
+				}
+				return
+			}
+			if format == nil || format.Itag != tt.wantItag || ext != tt.wantExt {
+				t.Fatalf("got %+v %q, want itag %d %q", format, ext, tt.wantItag, tt.wantExt)
+			}
+		})
+	}
+}
+
+func TestNativeDownload(t *testing.T) {
+	fy := newFakeYoutube(t, "OK", nil)
+	audio := bytes.Repeat([]byte("m4a audio "), 1000)
+	fy.formats = []adaptiveFormat{
+		fy.stream("low", "audio/mp4", 48000, []byte("low quality")),
+		fy.stream("best", "audio/mp4", 128000, audio),
+		fy.stream("opus", "audio/webm", 160000, []byte("opus audio")),
+		fy.stream("video", "video/mp4", 4000000, []byte("video")),
+	}
+	d := newTestNative(t, fy.URL, []string{"en"})
+
+	outPrefix := filepath.Join(t.TempDir(), "out")
+	var phases []Phase
+	res, err := d.Download(context.Background(), "https://www.youtube.com/watch?v=abcdefghijk", outPrefix, func(p Progress) {
+		phases = append(phases, p.Phase)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Path != outPrefix+".m4a" {
+		t.Errorf("path %s, want %s.m4a", res.Path, outPrefix)
+	}
+	if b, err := ioutil.ReadFile(res.Path); err != nil || !bytes.Equal(b, audio) {
+		t.Errorf("downloaded %d bytes, %v, want the best mp4 stream", len(b), err)
+	}
+	if res.Info.ID != "abcdefghijk" || res.Info.Title != "Title of abcdefghijk" || res.Info.Duration != 42 {
+		t.Errorf("info %+v", res.Info)
+	}
+	if len(phases) == 0 || phases[0] != PhaseExtract || phases[len(phases)-1] != PhaseDownload {
+		t.Errorf("progress phases %v", phases)
+	}
+	if len(res.Subtitles) != 1 || res.Subtitles[0].Lang != "en" {
+		t.Fatalf("subtitles %+v", res.Subtitles)
+	}
+	// Captions by people win over automatic ones, and come as WebVTT.
+	if b, _ := ioutil.ReadFile(res.Subtitles[0].Path); !strings.Contains(string(b), " vtt") || strings.Contains(string(b), "asr") {
+		t.Errorf("captions %q", b)
+	}
+}
+
+func TestNativeDownloadExtractorErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		formats []adaptiveFormat
+	}{
+		{name: "unplayable", status: "LOGIN_REQUIRED", formats: []adaptiveFormat{{URL: "u", MimeType: "audio/mp4"}}},
+		{name: "ciphered streams only", status: "OK", formats: []adaptiveFormat{{MimeType: "audio/mp4"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fy := newFakeYoutube(t, tt.status, tt.formats)
+			d := newTestNative(t, fy.URL, nil)
+			_, err := d.Download(context.Background(), "https://youtu.be/abcdefghijk", filepath.Join(t.TempDir(), "out"), func(Progress) {})
+			var extErr *ExtractorError
+			if !errors.As(err, &extErr) {
+				t.Fatalf("got %v, want an extractor error so the next downloader is tried", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package content
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// How long to wait for the pipes once the process group is killed.
+const killWaitDelay = 5 * time.Second
+
+// Passes each line of output to onLine as it is printed. When ctx is done
+// the process group is killed.
+func runCommand(ctx context.Context, onLine func(string), name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	setProcessGroup(cmd)
+	cmd.WaitDelay = killWaitDelay
+
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	scanDone := make(chan struct{})
+	go func() {
+		defer close(scanDone)
+		sc := bufio.NewScanner(pr)
+		sc.Split(scanLines)
+		for sc.Scan() {
+			onLine(sc.Text())
+		}
+		// Keep draining so the process never blocks on a full pipe.
+		io.Copy(ioutil.Discard, pr)
+	}()
+
+	err := cmd.Run()
+	pw.Close()
+	<-scanDone
+	// Finished work stands even if ctx ended since.
+	if cmd.ProcessState != nil && cmd.ProcessState.Success() {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%s killed: %w", name, ctxErr)
+	}
+	return err
+}
+
+// bufio.ScanLines that also splits on the \r progress bars redraw with.
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// Runs ffmpeg quietly, returning its output in the error if it fails.
+func runFFmpeg(ctx context.Context, ffmpeg string, args ...string) error {
+	var output []string
+	err := runCommand(ctx, func(line string) {
+		if line != "" {
+			output = append(output, line)
+		}
+	}, ffmpeg, append([]string{"-y", "-v", "error"}, args...)...)
+	if err != nil {
+		return fmt.Errorf("ffmpeg: %w\n%s", err, strings.Join(output, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package content
+
+import (
+	"os/exec"
+)
+
+// Process groups are not supported here, only the direct child is killed.
+func setProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package content
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Lets children such as ffmpeg be killed along with the command.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
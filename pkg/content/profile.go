@@ -0,0 +1,211 @@
+package content
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Audio processing applied after the download.
+type Profile struct {
+	Name        string  // Name used in urls
+	Loudnorm    bool    // Normalize loudness to EBU R128
+	TrimSilence bool    // Shorten long pauses
+	Tempo       float64 // Playback speed, 0 or 1 to keep it
+	Format      string  // Output format, see profileFormats, empty to keep it
+	Bitrate     string  // Output bitrate such as 32k, empty for the encoder default
+}
+
+type profileFormat struct {
+	ext   string // File extension
+	codec string // ffmpeg audio encoder
+}
+
+var profileFormats = map[string]profileFormat{
+	"mp3":  {".mp3", "libmp3lame"},
+	"m4a":  {".m4a", "aac"},
+	"opus": {".opus", "libopus"},
+	"ogg":  {".ogg", "libvorbis"},
+}
+
+const (
+	// https://ffmpeg.org/ffmpeg-filters.html#loudnorm
+	loudnormFilter = "loudnorm=I=-16:TP=-1.5:LRA=11"
+	// Pauses over a second are cut down to a second.
+	trimSilenceFilter = "silenceremove=stop_periods=-1:stop_duration=1:stop_threshold=-50dB"
+
+	// Range of a single atempo filter.
+	minAtempo = 0.5
+	maxAtempo = 2.0
+	minTempo  = 0.25
+	maxTempo  = 4.0
+)
+
+var (
+	profileNameRe = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+	bitrateRe     = regexp.MustCompile(`^[0-9]+k?$`)
+)
+
+// Parses "name:option,option", e.g. "fast:loudnorm,tempo=1.5" or
+// "mobile:format=opus,bitrate=32k".
+func ParseProfile(spec string) (Profile, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	p := Profile{Name: strings.TrimSpace(parts[0])}
+	if !profileNameRe.MatchString(p.Name) {
+		return Profile{}, fmt.Errorf("bad profile name %q", p.Name)
+	}
+	if len(parts) < 2 {
+		return p, nil
+	}
+	for _, opt := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(strings.TrimSpace(opt), "=", 2)
+		switch kv[0] {
+		case "":
+		case "loudnorm":
+			p.Loudnorm = true
+		case "silence":
+			p.TrimSilence = true
+		case "tempo":
+			if len(kv) < 2 {
+				return Profile{}, fmt.Errorf("profile %s: tempo needs a value", p.Name)
+			}
+			tempo, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil {
+				return Profile{}, fmt.Errorf("profile %s: %w", p.Name, err)
+			}
+			p.Tempo = tempo
+		case "format", "bitrate":
+			if len(kv) < 2 {
+				return Profile{}, fmt.Errorf("profile %s: %s needs a value", p.Name, kv[0])
+			}
+			if kv[0] == "format" {
+				p.Format = kv[1]
+			} else {
+				p.Bitrate = kv[1]
+			}
+		default:
+			return Profile{}, fmt.Errorf("profile %s: unknown option %q", p.Name, kv[0])
+		}
+	}
+	return p, p.validate()
+}
+
+func (p Profile) validate() error {
+	if p.Tempo != 0 && (p.Tempo < minTempo || p.Tempo > maxTempo) {
+		return fmt.Errorf("profile %s: tempo must be between %g and %g", p.Name, minTempo, maxTempo)
+	}
+	if _, ok := profileFormats[p.Format]; p.Format != "" && !ok {
+		return fmt.Errorf("profile %s: unknown format %q", p.Name, p.Format)
+	}
+	if p.Bitrate != "" && !bitrateRe.MatchString(p.Bitrate) {
+		return fmt.Errorf("profile %s: bad bitrate %q", p.Name, p.Bitrate)
+	}
+	return nil
+}
+
+// Mime type of files the profile produces, empty if it keeps the format.
+func (p Profile) MimeType() string {
+	if f, ok := profileFormats[p.Format]; ok {
+		return MimeType(f.ext)
+	}
+	return ""
+}
+
+func (p Profile) speed() float64 {
+	if p.Tempo == 0 {
+		return 1
+	}
+	return p.Tempo
+}
+
+// The ffmpeg audio filter for the profile, empty if it changes nothing.
+func (p Profile) filter() string {
+	var filters []string
+	if p.TrimSilence {
+		filters = append(filters, trimSilenceFilter)
+	}
+	// atempo only goes so far, larger changes are chained.
+	for tempo := p.speed(); tempo != 1; {
+		step := tempo
+		if step > maxAtempo {
+			step = maxAtempo
+		} else if step < minAtempo {
+			step = minAtempo
+		}
+		filters = append(filters, fmt.Sprintf("atempo=%g", step))
+		tempo /= step
+	}
+	// Last, so the level is measured on what is actually heard.
+	if p.Loudnorm {
+		filters = append(filters, loudnormFilter)
+	}
+	return strings.Join(filters, ",")
+}
+
+// Where a time in the unprocessed audio ends up, ignoring trimmed pauses.
+func (p Profile) mapTime(t float64) float64 {
+	return t / p.speed()
+}
+
+func (p Profile) mapChapters(chapters []Chapter) []Chapter {
+	if p.speed() == 1 {
+		return chapters
+	}
+	var out []Chapter
+	for _, c := range chapters {
+		out = append(out, Chapter{Start: p.mapTime(c.Start), End: p.mapTime(c.End), Title: c.Title})
+	}
+	return out
+}
+
+// Looks up a configured profile by name.
+func (pp PostprocessConfig) profile(name string) (Profile, bool) {
+	for _, p := range pp.Profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
+
+// Applies the profile to src, replacing it. Returns the path of the result.
+func (f fetcherTask) applyProfile(ctx context.Context, src string, p Profile) (string, error) {
+	filter := p.filter()
+	format, reformat := profileFormats[p.Format]
+	if filter == "" && !reformat && p.Bitrate == "" {
+		return src, nil
+	}
+	f.progress(Progress{Phase: PhasePostprocess, Percent: 100})
+
+	ext := filepath.Ext(src)
+	base := strings.TrimSuffix(src, ext)
+	if reformat {
+		ext = format.ext
+	}
+	args := []string{"-i", src, "-vn", "-map_metadata", "0"}
+	if filter != "" {
+		args = append(args, "-af", filter)
+	}
+	if reformat {
+		args = append(args, "-c:a", format.codec)
+	}
+	if p.Bitrate != "" {
+		args = append(args, "-b:a", p.Bitrate)
+	}
+	tmp := base + ".profile" + ext
+	dst := base + ext
+	logger.Printf("applying profile %s to %s: %q", p.Name, src, args)
+	if err := runFFmpeg(ctx, f.pp.FFmpeg, append(args, tmp)...); err != nil {
+		return "", err
+	}
+	if dst != src {
+		if err := os.Remove(src); err != nil {
+			return "", err
+		}
+	}
+	return dst, os.Rename(tmp, dst)
+}
@@ -0,0 +1,80 @@
+package content
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProfile(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Profile
+		wantErr bool
+	}{
+		{spec: "plain", want: Profile{Name: "plain"}},
+		{spec: "fast:loudnorm,tempo=1.5", want: Profile{Name: "fast", Loudnorm: true, Tempo: 1.5}},
+		{spec: " quiet : silence, loudnorm ,", want: Profile{Name: "quiet", TrimSilence: true, Loudnorm: true}},
+		{spec: "mobile:format=opus,bitrate=32k", want: Profile{Name: "mobile", Format: "opus", Bitrate: "32k"}},
+		{spec: "", wantErr: true},
+		{spec: "bad name:loudnorm", wantErr: true},
+		{spec: "x:tempo", wantErr: true},
+		{spec: "x:tempo=fast", wantErr: true},
+		{spec: "x:tempo=5", wantErr: true},
+		{spec: "x:tempo=0.1", wantErr: true},
+		{spec: "x:format=flac", wantErr: true},
+		{spec: "x:format", wantErr: true},
+		{spec: "x:bitrate=lots", wantErr: true},
+		{spec: "x:louder", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseProfile(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: err %v, want error %v", tt.spec, err, tt.wantErr)
+		} else if !tt.wantErr && got != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestProfileFilter(t *testing.T) {
+	tests := []struct {
+		profile Profile
+		want    string
+	}{
+		{profile: Profile{}, want: ""},
+		{profile: Profile{Tempo: 1}, want: ""},
+		{profile: Profile{Tempo: 1.5}, want: "atempo=1.5"},
+		{profile: Profile{Tempo: 3}, want: "atempo=2,atempo=1.5"},
+		{profile: Profile{Tempo: 0.25}, want: "atempo=0.5,atempo=0.5"},
+		{
+			profile: Profile{TrimSilence: true, Loudnorm: true, Tempo: 1.25},
+			want:    trimSilenceFilter + ",atempo=1.25," + loudnormFilter,
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.profile.filter(); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.profile, got, tt.want)
+		}
+	}
+}
+
+func TestProfileMapChapters(t *testing.T) {
+	chapters := []Chapter{{Start: 0, End: 30, Title: "One"}, {Start: 30, End: 90, Title: "Two"}}
+	got := Profile{Tempo: 1.5}.mapChapters(chapters)
+	want := []Chapter{{Start: 0, End: 20, Title: "One"}, {Start: 20, End: 60, Title: "Two"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := (Profile{Loudnorm: true}).mapChapters(chapters); !reflect.DeepEqual(got, chapters) {
+		t.Errorf("unchanged speed: got %+v", got)
+	}
+}
+
+func TestProfileMimeType(t *testing.T) {
+	if got := (Profile{Format: "opus"}).MimeType(); got != "audio/ogg" {
+		t.Errorf("opus: got %q", got)
+	}
+	if got := (Profile{}).MimeType(); got != "" {
+		t.Errorf("kept format: got %q", got)
+	}
+}
@@ -0,0 +1,112 @@
+package content
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Phase string
+
+// Progress of a single download as reported by youtube-dl.
+type Progress struct {
+	Phase      Phase   `json:"phase"`                 // What the downloader is doing
+	Percent    float64 `json:"percent"`               // Download percentage
+	TotalBytes int64   `json:"total_bytes,omitempty"` // Size of the download
+	Speed      int64   `json:"speed,omitempty"`       // Bytes per second
+	ETA        int64   `json:"eta,omitempty"`         // Seconds remaining
+}
+
+const (
+	PhaseExtract     Phase = "extract"
+	PhaseDownload    Phase = "download"
+	PhasePostprocess Phase = "postprocess"
+)
+
+var (
+	// [download]  45.3% of ~3.45MiB at  1.23MiB/s ETA 00:02 (frag 3/10)
+	downloadLineRe = regexp.MustCompile(`^\[download\]\s+([\d.]+)%\s+of\s+~?\s*(\S+)(?:\s+at\s+(\S+))?(?:\s+ETA\s+(\S+))?`)
+	// [youtube] abc: Downloading webpage
+	toolLineRe = regexp.MustCompile(`^\[([^\]]+)\]`)
+
+	sizeUnits = map[string]float64{
+		"B":   1,
+		"KiB": 1 << 10,
+		"MiB": 1 << 20,
+		"GiB": 1 << 30,
+		"TiB": 1 << 40,
+		"KB":  1e3,
+		"MB":  1e6,
+		"GB":  1e9,
+		"TB":  1e12,
+	}
+
+	// Tags youtube-dl and yt-dlp use for their post-processors.
+	postprocessTags = map[string]bool{
+		"ffmpeg":         true,
+		"ExtractAudio":   true,
+		"Merger":         true,
+		"FixupM4a":       true,
+		"FixupM3u8":      true,
+		"EmbedThumbnail": true,
+		"Metadata":       true,
+		"SponsorBlock":   true,
+		"ModifyChapters": true,
+	}
+)
+
+// Returns false if the line carried no progress.
+func (p *Progress) parseLine(line string) bool {
+	line = strings.TrimSpace(line)
+	m := toolLineRe.FindStringSubmatch(line)
+	if m == nil {
+		return false
+	}
+	switch tag := m[1]; {
+	case tag == "download":
+		p.Phase = PhaseDownload
+		if m := downloadLineRe.FindStringSubmatch(line); m != nil {
+			p.Percent, _ = strconv.ParseFloat(m[1], 64)
+			p.TotalBytes = parseSize(m[2])
+			p.Speed = parseSize(strings.TrimSuffix(m[3], "/s"))
+			p.ETA = parseClock(m[4])
+		}
+	case postprocessTags[tag]:
+		p.Phase = PhasePostprocess
+		p.Speed = 0
+		p.ETA = 0
+	case p.Phase == "":
+		p.Phase = PhaseExtract
+	default:
+		return false
+	}
+	return true
+}
+
+// Parses sizes such as "3.45MiB", returns 0 if unknown.
+func parseSize(s string) int64 {
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i <= 0 {
+		return 0
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0
+	}
+	return int64(n * sizeUnits[s[i:]])
+}
+
+// Parses durations such as "01:02:03" into seconds, returns 0 if unknown.
+func parseClock(s string) int64 {
+	var secs int64
+	for _, part := range strings.Split(s, ":") {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return 0
+		}
+		secs = secs*60 + n
+	}
+	return secs
+}
@@ -0,0 +1,70 @@
+package content
+
+import "testing"
+
+func TestProgressParseLine(t *testing.T) {
+	// Lines as yt-dlp prints them for one download, in order.
+	tests := []struct {
+		line string
+		ok   bool
+		want Progress
+	}{
+		{line: "not a progress line", ok: false},
+		{line: "[youtube] abcdefghijk: Downloading webpage", ok: true, want: Progress{Phase: PhaseExtract}},
+		{line: "[info] abcdefghijk: Downloading 1 format(s): 251", ok: false, want: Progress{Phase: PhaseExtract}},
+		{line: "[download] Destination: out.webm", ok: true, want: Progress{Phase: PhaseDownload}},
+		{
+			line: "[download]  45.3% of ~3.50MiB at  1.00MiB/s ETA 00:02 (frag 3/10)",
+			ok:   true,
+			want: Progress{Phase: PhaseDownload, Percent: 45.3, TotalBytes: 3670016, Speed: 1 << 20, ETA: 2},
+		},
+		{
+			line: "[download] 100% of 2.00KB in 01:02:03",
+			ok:   true,
+			want: Progress{Phase: PhaseDownload, Percent: 100, TotalBytes: 2000},
+		},
+		{line: "[ExtractAudio] Destination: out.mp3", ok: true, want: Progress{Phase: PhasePostprocess, Percent: 100, TotalBytes: 2000}},
+		{line: "[youtube] something late", ok: false, want: Progress{Phase: PhasePostprocess, Percent: 100, TotalBytes: 2000}},
+	}
+	var p Progress
+	for _, tt := range tests {
+		if ok := p.parseLine(tt.line); ok != tt.ok {
+			t.Errorf("%q: got %v, want %v", tt.line, ok, tt.ok)
+		}
+		if p != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.line, p, tt.want)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"3.5MiB":  3670016,
+		"12KB":    12000,
+		"1GiB":    1 << 30,
+		"100B":    100,
+		"Unknown": 0,
+		"":        0,
+		"5XB":     0,
+	}
+	for s, want := range tests {
+		if got := parseSize(s); got != want {
+			t.Errorf("%q: got %d, want %d", s, got, want)
+		}
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	tests := map[string]int64{
+		"00:02":    2,
+		"01:02:03": 3723,
+		"42":       42,
+		"":         0,
+		"Unknown":  0,
+	}
+	for s, want := range tests {
+		if got := parseClock(s); got != want {
+			t.Errorf("%q: got %d, want %d", s, got, want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nlsun/rss-reflector/pkg/upstream"
+)
+
+// A stretch of audio, in seconds.
+type Segment struct {
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+	Category string  `json:"category,omitempty"`
+}
+
+type sponsorBlockSegment struct {
+	Category   string     `json:"category"`
+	ActionType string     `json:"actionType"`
+	Segment    [2]float64 `json:"segment"`
+}
+
+// Looks up the segments to skip in a video.
+func lookupSponsorSegments(ctx context.Context, client *upstream.Client, api, videoID string, categories []string) ([]Segment, error) {
+	cats, err := json.Marshal(categories)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	q.Set("videoID", videoID)
+	q.Set("categories", string(cats))
+	qUrl := strings.TrimSuffix(api, "/") + "/api/skipSegments?" + q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, qUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	} else if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("sponsorblock resp status %s", resp.Status)
+	}
+
+	var found []sponsorBlockSegment
+	if err := json.NewDecoder(resp.Body).Decode(&found); err != nil {
+		return nil, err
+	}
+	var segments []Segment
+	for _, s := range found {
+		if s.ActionType != "" && s.ActionType != "skip" {
+			continue
+		}
+		segments = append(segments, Segment{Start: s.Segment[0], End: s.Segment[1], Category: s.Category})
+	}
+	return mergeSegments(segments), nil
+}
+
+// Sorts the segments and joins the ones that overlap.
+func mergeSegments(segments []Segment) []Segment {
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Start < segments[j].Start })
+	var merged []Segment
+	for _, s := range segments {
+		if s.End <= s.Start {
+			continue
+		}
+		if n := len(merged); n > 0 && s.Start <= merged[n-1].End {
+			if s.End > merged[n-1].End {
+				merged[n-1].End = s.End
+			}
+			if !strings.Contains(merged[n-1].Category, s.Category) {
+				merged[n-1].Category += "," + s.Category
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+// Audio filter dropping the segments and closing the gaps.
+func cutFilter(segments []Segment) string {
+	var between []string
+	for _, s := range segments {
+		between = append(between, fmt.Sprintf("between(t,%.3f,%.3f)", s.Start, s.End))
+	}
+	return fmt.Sprintf("aselect='not(%s)',asetpts=N/SR/TB", strings.Join(between, "+"))
+}
+
+// Looks up the segments to cut from the task's video.
+func (f fetcherTask) sponsorSegments(ctx context.Context) ([]Segment, error) {
+	id, err := YoutubeVideoID(f.req.Uri)
+	if err != nil {
+		return nil, err
+	}
+	return lookupSponsorSegments(ctx, f.pp.Client, f.pp.SponsorBlockAPI, id, f.pp.SponsorBlockCategories)
+}
+
+// Removes the segments from src, replacing it.
+func (f fetcherTask) cutSponsorSegments(ctx context.Context, src string, segments []Segment) error {
+	if len(segments) == 0 {
+		logger.Printf("no sponsor segments for %s", f.req.Uri)
+		return nil
+	}
+
+	f.progress(Progress{Phase: PhasePostprocess, Percent: 100})
+	ext := filepath.Ext(src)
+	dst := strings.TrimSuffix(src, ext) + ".cut" + ext
+	logger.Printf("cutting %d sponsor segments from %s", len(segments), src)
+	if err := runFFmpeg(ctx, f.pp.FFmpeg, "-i", src, "-vn", "-map_metadata", "0", "-af", cutFilter(segments), dst); err != nil {
+		return err
+	}
+	return os.Rename(dst, src)
+}
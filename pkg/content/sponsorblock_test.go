@@ -0,0 +1,114 @@
+package content
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nlsun/rss-reflector/pkg/upstream"
+	"github.com/nlsun/rss-reflector/pkg/util"
+)
+
+func newSponsorBlock(t *testing.T, status int, body string) (*httptest.Server, *upstream.Client) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/skipSegments" || r.URL.Query().Get("videoID") != "abcdefghijk" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	client, err := upstream.New(upstream.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, client
+}
+
+func TestLookupSponsorSegments(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    []Segment
+		wantErr bool
+	}{
+		{
+			name:   "merged and sorted",
+			status: http.StatusOK,
+			body: `[{"category":"outro","actionType":"skip","segment":[50,60]},
+				{"category":"sponsor","actionType":"skip","segment":[10,20]},
+				{"category":"selfpromo","actionType":"skip","segment":[15,25]},
+				{"category":"sponsor","actionType":"mute","segment":[30,35]}]`,
+			want: []Segment{{Start: 10, End: 25, Category: "sponsor,selfpromo"}, {Start: 50, End: 60, Category: "outro"}},
+		},
+		{name: "no segments", status: http.StatusNotFound},
+		{name: "api down", status: http.StatusBadGateway, wantErr: true},
+		{name: "bad json", status: http.StatusOK, body: "{", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, client := newSponsorBlock(t, tt.status, tt.body)
+			got, err := lookupSponsorSegments(context.Background(), client, srv.URL, "abcdefghijk", []string{"sponsor"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Writes a file for every download.
+type fileDownloader struct{ countingDownloader }
+
+func (d *fileDownloader) Download(ctx context.Context, uri, outPrefix string, progress func(Progress)) (*Result, error) {
+	path := outPrefix + ".mp3"
+	return &Result{Path: path}, ioutil.WriteFile(path, []byte("audio"), 0644)
+}
+
+func TestSponsorBlockDownCachesUncut(t *testing.T) {
+	srv, client := newSponsorBlock(t, http.StatusInternalServerError, "")
+	dir := t.TempDir()
+	for _, d := range []string{"tmp", "data", "meta"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := TaskRequest{Src: YoutubeSource, Uri: "https://www.youtube.com/watch?v=abcdefghijk", SponsorBlock: true}
+	f := fetcherTask{
+		req:         req,
+		tmpdir:      filepath.Join(dir, "tmp"),
+		datadir:     filepath.Join(dir, "data"),
+		metadir:     filepath.Join(dir, "meta"),
+		downloaders: []Downloader{&fileDownloader{}},
+		pp:          PostprocessConfig{SponsorBlockAPI: srv.URL, Client: client, FFmpeg: "/nonexistent/ffmpeg"},
+		maxndf:      10,
+		progress:    func(Progress) {},
+	}
+	path, err := f.doTaskHelper(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(path); !strings.HasSuffix(string(b), "audio") {
+		t.Errorf("audio changed to %q", b)
+	}
+
+	cutKey, _ := req.key()
+	req.SponsorBlock = false
+	plainKey, _ := req.key()
+	if want := filepath.Join(dir, "data", plainKey) + ".mp3"; path != want {
+		t.Errorf("cached at %s, want %s", path, want)
+	}
+	if found, _ := util.FindFileWithPrefix(filepath.Join(dir, "data", cutKey) + "."); found != "" {
+		t.Errorf("uncut audio cached as cut at %s", found)
+	}
+}
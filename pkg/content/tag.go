@@ -0,0 +1,127 @@
+package content
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nlsun/rss-reflector/pkg/id3"
+	"github.com/nlsun/rss-reflector/pkg/upstream"
+)
+
+const (
+	// Covers bigger than this are not embedded.
+	maxCoverSize = 5 << 20
+	// How long fetching a cover may take.
+	coverTimeout = 30 * time.Second
+)
+
+// Tags mp3 files from YouTube with what we know about the item.
+func writeTags(ctx context.Context, client *upstream.Client, path string, meta *ItemMeta) error {
+	if strings.ToLower(filepath.Ext(path)) != ".mp3" || meta.Src != YoutubeSource {
+		return nil
+	}
+
+	tag := &id3.Tag{}
+	if info := meta.Info; info != nil {
+		setText(tag, "TIT2", info.Title)
+		setText(tag, "TPE1", info.Uploader)
+		// YYYYMMDD, v2.3 splits it into year and DDMM.
+		if d := info.UploadDate; len(d) == 8 {
+			setText(tag, "TYER", d[:4])
+			setText(tag, "TDAT", d[6:8]+d[4:6])
+		}
+		link := info.WebpageURL
+		if link == "" {
+			link = meta.Uri
+		}
+		tag.SetComment("eng", link)
+		if mimeType, image := fetchCover(ctx, client, info); image != nil {
+			tag.SetPicture(mimeType, id3.PictureFrontCover, image)
+		}
+	}
+	album := meta.Album
+	if album == "" && meta.Info != nil {
+		album = meta.Info.Uploader
+	}
+	setText(tag, "TALB", album)
+
+	var chapters []id3.Chapter
+	for i, c := range meta.Chapters {
+		end := c.End
+		if end < c.Start {
+			end = c.Start
+		}
+		chapters = append(chapters, id3.Chapter{
+			ID:    fmt.Sprintf("chp%d", i),
+			Start: uint32(c.Start * 1000),
+			End:   uint32(end * 1000),
+			Title: c.Title,
+		})
+	}
+	tag.SetChapters(chapters)
+	logger.Printf("writing tags with %d chapters to %s", len(chapters), path)
+	return id3.WriteFile(path, tag)
+}
+
+func setText(tag *id3.Tag, id, text string) {
+	if text != "" {
+		tag.SetText(id, text)
+	}
+}
+
+// Players only reliably show JPEG and PNG, so WebP thumbnails fall back to
+// YouTube's JPEG one.
+func fetchCover(ctx context.Context, client *upstream.Client, info *Info) (string, []byte) {
+	ctx, cancel := context.WithTimeout(ctx, coverTimeout)
+	defer cancel()
+	var candidates []string
+	if info.Thumbnail != "" {
+		candidates = append(candidates, info.Thumbnail)
+	}
+	if info.ID != "" {
+		candidates = append(candidates, "https://i.ytimg.com/vi/"+info.ID+"/hqdefault.jpg")
+	}
+	for _, uri := range candidates {
+		mimeType, image, err := fetchImage(ctx, client, uri)
+		if err != nil {
+			logger.Printf("cover %s: %s", uri, err)
+			continue
+		}
+		return mimeType, image
+	}
+	return "", nil
+}
+
+func fetchImage(ctx context.Context, client *upstream.Client, uri string) (string, []byte, error) {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", nil, fmt.Errorf("resp status %s", resp.Status)
+	}
+	image, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCoverSize+1))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(image) > maxCoverSize {
+		return "", nil, fmt.Errorf("cover larger than %d bytes", maxCoverSize)
+	}
+	switch mimeType := http.DetectContentType(image); mimeType {
+	case "image/jpeg", "image/png":
+		return mimeType, image, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported cover type %s", mimeType)
+	}
+}
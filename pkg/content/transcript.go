@@ -0,0 +1,72 @@
+package content
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/nlsun/rss-reflector/pkg/transcript"
+)
+
+// Stored next to the item metadata, so they are evicted along with it.
+func transcriptPath(metadir, key string) string {
+	return filepath.Join(metadir, key+".vtt")
+}
+
+// Keeps the first subtitle that parses, timed to the processed audio.
+// Returns its language, empty if none.
+func saveTranscript(metadir, key string, subs []Subtitle, mapTime func(float64) (float64, bool)) (string, error) {
+	for _, sub := range subs {
+		t, err := readTranscript(sub.Path)
+		if err != nil {
+			logger.Printf("skipping subtitle %s: %s", sub.Path, err)
+			continue
+		}
+		t.Remap(mapTime)
+		out, err := os.Create(transcriptPath(metadir, key))
+		if err != nil {
+			return "", err
+		}
+		if err := t.WriteVTT(out); err != nil {
+			out.Close()
+			return "", err
+		}
+		return sub.Lang, out.Close()
+	}
+	return "", nil
+}
+
+func readTranscript(path string) (*transcript.Transcript, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return transcript.ParseVTT(in)
+}
+
+func removeSubtitles(subs []Subtitle) {
+	for _, sub := range subs {
+		if err := os.Remove(sub.Path); err != nil && !os.IsNotExist(err) {
+			logger.Printf("removing subtitle %s: %s", sub.Path, err)
+		}
+	}
+}
+
+// Transcript of the cached item and its language, nil if there is none.
+func (f *Fetcher) Transcript(req TaskRequest) (*transcript.Transcript, string, error) {
+	meta, err := f.ItemMeta(req)
+	if err != nil || meta == nil || meta.TranscriptLang == "" {
+		return nil, "", err
+	}
+	key, err := f.normalize(req).key()
+	if err != nil {
+		return nil, "", err
+	}
+	t, err := readTranscript(transcriptPath(f.metadir, key))
+	if os.IsNotExist(err) {
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+	return t, meta.TranscriptLang, nil
+}
@@ -0,0 +1,300 @@
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nlsun/rss-reflector/pkg/cookies"
+	"github.com/nlsun/rss-reflector/pkg/upstream"
+	"github.com/nlsun/rss-reflector/pkg/util"
+)
+
+// Runs youtube-dl or one of its forks.
+type commandDownloader struct {
+	name     string
+	path     string
+	flags    []string
+	subLangs []string
+	client   *upstream.Client // Picks the proxy, if any
+	// Flags that tell the backend where to write and how to report.
+	outputArgs func(outPrefix string) []string
+	// Flags that ask for subtitles in the given languages.
+	subtitleArgs func(langs []string) []string
+	// Picks the downloaded file given the backend output.
+	findOutput func(outPrefix string, lines []string) (string, error)
+}
+
+func newYoutubeDL(path string) *commandDownloader {
+	return &commandDownloader{
+		name:  YoutubeDL,
+		path:  path,
+		flags: []string{"--extract-audio", "--audio-format", "mp3", "--postprocessor-args", "-strict experimental"},
+		outputArgs: func(outPrefix string) []string {
+			// youtube-dl forces you to use their template format if you
+			// are re-encoding.
+			return []string{"--newline", "--no-playlist", "--write-info-json", "--output", outPrefix + `.%(ext)s`}
+		},
+		subtitleArgs: func(langs []string) []string {
+			return []string{"--write-sub", "--write-auto-sub", "--sub-format", "vtt", "--sub-lang", strings.Join(langs, ",")}
+		},
+		findOutput: func(outPrefix string, lines []string) (string, error) {
+			return findMediaFile(outPrefix)
+		},
+	}
+}
+
+func newYtDlp(path string) *commandDownloader {
+	return &commandDownloader{
+		name: YtDlp,
+		path: path,
+		// yt-dlp wants post-processor args scoped to the executable.
+		flags: []string{"--extract-audio", "--audio-format", "mp3", "--postprocessor-args", "ffmpeg:-strict experimental"},
+		outputArgs: func(outPrefix string) []string {
+			// --print implies --quiet and --simulate.
+			return []string{
+				"--newline", "--progress", "--no-simulate", "--no-playlist", "--write-info-json",
+				"--print", "after_move:filepath",
+				"--output", outPrefix + `.%(ext)s`,
+			}
+		},
+		subtitleArgs: func(langs []string) []string {
+			return []string{"--write-subs", "--write-auto-subs", "--sub-format", "vtt", "--sub-langs", strings.Join(langs, ",")}
+		},
+		findOutput: func(outPrefix string, lines []string) (string, error) {
+			// The printed final path is exact, unlike the prefix search.
+			for i := len(lines) - 1; i >= 0; i-- {
+				if strings.HasPrefix(lines[i], filepath.Clean(outPrefix)+".") {
+					return lines[i], nil
+				}
+			}
+			return findMediaFile(outPrefix)
+		},
+	}
+}
+
+func (d *commandDownloader) Name() string {
+	return d.name
+}
+
+func (d *commandDownloader) Version(ctx context.Context) (string, error) {
+	var version string
+	err := runCommand(ctx, func(line string) {
+		if version == "" {
+			version = strings.TrimSpace(line)
+		}
+	}, d.path, "--version")
+	return version, err
+}
+
+// When subtitles fail the download it is tried again without them.
+func (d *commandDownloader) Download(ctx context.Context, uri, outPrefix string, progressFn func(Progress)) (*Result, error) {
+	res, bySubs, err := d.download(ctx, uri, outPrefix, d.subLangs, progressFn)
+	if err != nil && bySubs && ctx.Err() == nil {
+		logger.Printf("%s failed with subtitles, trying without: %s", d.name, err)
+		res, _, err = d.download(ctx, uri, outPrefix, nil, progressFn)
+	}
+	return res, err
+}
+
+// bySubs reports whether the subtitles failed the download.
+func (d *commandDownloader) download(ctx context.Context, uri, outPrefix string, subLangs []string, progressFn func(Progress)) (res *Result, bySubs bool, err error) {
+	cmdFlags := append(append([]string{}, d.flags...), d.outputArgs(outPrefix)...)
+	if len(subLangs) > 0 {
+		cmdFlags = append(cmdFlags, d.subtitleArgs(subLangs)...)
+	}
+	cookieFlags, done, err := cookieArgs(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer done()
+	cmdFlags = append(append(append(cmdFlags, d.proxyArgs()...), cookieFlags...), uri)
+	logger.Printf("%s %+v", d.path, cmdFlags)
+
+	// Progress lines are reported live and left out of the logged output.
+	var lines []string
+	var progress Progress
+	err = runCommand(ctx, func(line string) {
+		if progress.parseLine(line) {
+			progressFn(progress)
+		}
+		if line != "" && !downloadLineRe.MatchString(line) {
+			lines = append(lines, line)
+		}
+	}, d.path, cmdFlags...)
+	if err != nil {
+		return nil, len(subLangs) > 0 && subtitleFailure(lines), d.commandError(ctx, err, lines)
+	}
+	logger.Print(strings.Join(lines, "\n"))
+
+	infof := outPrefix + ".info.json"
+	info, err := readInfoFile(infof)
+	if err != nil {
+		logger.Printf("%s info json: %s", d.name, err)
+	}
+	if err := os.RemoveAll(infof); err != nil {
+		return nil, false, err
+	}
+
+	path, err := d.findOutput(outPrefix, lines)
+	if err != nil {
+		return nil, false, err
+	}
+	if path == "" {
+		return nil, false, fmt.Errorf("%s output with prefix %s not found", d.name, outPrefix)
+	}
+	subs, err := findSubtitles(outPrefix, subLangs)
+	if err != nil {
+		return nil, false, err
+	}
+	return &Result{Path: path, Info: info, Subtitles: subs}, false, nil
+}
+
+// youtube-dl and yt-dlp take turns with the upstream client's requests.
+func (d *commandDownloader) proxyArgs() []string {
+	if p := d.client.Proxy(); p != "" {
+		return []string{"--proxy", p}
+	}
+	return nil
+}
+
+// The jar is copied, as youtube-dl writes cookies back. done removes the copy.
+func cookieArgs(ctx context.Context) (args []string, done func(), err error) {
+	jar := cookies.FromContext(ctx)
+	if jar == nil {
+		return nil, func() {}, nil
+	}
+	path, done, err := jar.Copy()
+	if err != nil {
+		return nil, nil, err
+	}
+	return []string{"--cookies", path}, done, nil
+}
+
+func (d *commandDownloader) Info(ctx context.Context, uri string) (*Info, error) {
+	cookieFlags, done, err := cookieArgs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	cmdFlags := append([]string{"--dump-json", "--skip-download", "--no-playlist"}, d.proxyArgs()...)
+	cmdFlags = append(append(cmdFlags, cookieFlags...), uri)
+	var lines []string
+	var infoLine string
+	err = runCommand(ctx, func(line string) {
+		// Warnings are mixed in, the json is on a line of its own.
+		if strings.HasPrefix(line, "{") {
+			infoLine = line
+		} else if line != "" {
+			lines = append(lines, line)
+		}
+	}, d.path, cmdFlags...)
+	if err != nil {
+		return nil, d.commandError(ctx, err, lines)
+	}
+	var info Info
+	if err := json.Unmarshal([]byte(infoLine), &info); err != nil {
+		return nil, fmt.Errorf("%s info json: %w", d.name, err)
+	}
+	return &info, nil
+}
+
+func (d *commandDownloader) commandError(ctx context.Context, err error, lines []string) error {
+	if ctx.Err() == nil {
+		if msg := extractorErrorMsg(lines); msg != "" {
+			return &ExtractorError{Downloader: d.name, Msg: msg}
+		}
+	}
+	if len(lines) > 0 {
+		return fmt.Errorf("%w\n%s", err, strings.Join(lines, "\n"))
+	}
+	return err
+}
+
+// Finds the media file among the files sharing the prefix.
+func findMediaFile(outPrefix string) (string, error) {
+	files, err := ioutil.ReadDir(filepath.Dir(outPrefix))
+	if err != nil {
+		return "", err
+	}
+	base := filepath.Base(outPrefix) + "."
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, base) || isSidecarFile(name) {
+			continue
+		}
+		return filepath.Join(filepath.Dir(outPrefix), name), nil
+	}
+	return "", nil
+}
+
+func isSidecarFile(name string) bool {
+	for _, suffix := range []string{".info.json", ".vtt", ".part", ".ytdl"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subtitles written as <outPrefix>.<lang>.vtt, in the order of langs.
+func findSubtitles(outPrefix string, langs []string) ([]Subtitle, error) {
+	var subs []Subtitle
+	for _, lang := range langs {
+		path := outPrefix + "." + lang + ".vtt"
+		if ok, err := util.FileExists(path); err != nil {
+			return nil, err
+		} else if ok {
+			subs = append(subs, Subtitle{Lang: lang, Path: path})
+		}
+	}
+	return subs, nil
+}
+
+func readInfoFile(path string) (*Info, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var info Info
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Empty unless the backend failed extracting rather than post-processing.
+func extractorErrorMsg(lines []string) string {
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "ERROR:") {
+			continue
+		}
+		msg := strings.TrimSpace(strings.TrimPrefix(line, "ERROR:"))
+		lower := strings.ToLower(msg)
+		if strings.HasPrefix(lower, "postprocessing") ||
+			strings.Contains(lower, "ffmpeg") ||
+			strings.Contains(lower, "ffprobe") {
+			return ""
+		}
+		return msg
+	}
+	return ""
+}
+
+// Whether the error names subtitles or came while converting them.
+func subtitleFailure(lines []string) bool {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if !strings.HasPrefix(lines[i], "ERROR:") {
+			continue
+		}
+		if strings.Contains(strings.ToLower(lines[i]), "subtitle") {
+			return true
+		}
+		return i > 0 && strings.Contains(strings.ToLower(lines[i-1]), "subtitle")
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package content
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// A yt-dlp stand-in that fails whenever it is asked for subtitles, as
+// yt-dlp does when YouTube refuses them, and on private videos.
+const noSubtitlesScript = `#!/bin/sh
+echo "$@" >> "$0.calls"
+case "$*" in *private0000*) echo "ERROR: [youtube] private0000: Private video"; exit 1;; esac
+for a in "$@"; do
+	if [ "$a" = "--write-subs" ]; then echo "ERROR: Unable to download video subtitles for 'en': HTTP Error 429"; exit 1; fi
+done
+while [ $# -gt 1 ]; do if [ "$1" = "--output" ]; then tmpl="$2"; fi; shift; done
+f=$(echo "$tmpl" | sed 's/%(ext)s/mp3/')
+echo audio > "$f"
+echo "$f"
+`
+
+func TestCommandDownloadWithoutSubtitles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+	dir := t.TempDir()
+	script := filepath.Join(dir, "yt-dlp")
+	if err := ioutil.WriteFile(script, []byte(noSubtitlesScript), 0755); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDownloader(DownloaderConfig{Name: YtDlp, Path: script, SubtitleLangs: []string{"en"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outPrefix := filepath.Join(dir, "out")
+	res, err := d.Download(context.Background(), "https://www.youtube.com/watch?v=abcdefghijk", outPrefix, func(Progress) {})
+	if err != nil {
+		t.Fatalf("subtitles failed the download: %s", err)
+	}
+	if res.Path != outPrefix+".mp3" || len(res.Subtitles) != 0 {
+		t.Errorf("got %+v", res)
+	}
+	calls, _ := ioutil.ReadFile(script + ".calls")
+	if n := strings.Count(string(calls), "\n"); n != 2 {
+		t.Errorf("%d runs, want one with subtitles and one without", n)
+	}
+	if n := strings.Count(string(calls), "--no-playlist"); n != 2 {
+		t.Errorf("%d runs of 2 limited to the video", n)
+	}
+
+	// Other failures aren't retried.
+	if err := ioutil.WriteFile(script+".calls", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.Download(context.Background(), "https://www.youtube.com/watch?v=private0000", outPrefix, func(Progress) {})
+	var extractorErr *ExtractorError
+	if !errors.As(err, &extractorErr) || !strings.Contains(extractorErr.Msg, "Private video") {
+		t.Errorf("got %v, want the private video error", err)
+	}
+	calls, _ = ioutil.ReadFile(script + ".calls")
+	if n := strings.Count(string(calls), "\n"); n != 1 {
+		t.Errorf("%d runs for a private video", n)
+	}
+}
+
+func TestSubtitleFailure(t *testing.T) {
+	tests := []struct {
+		lines []string
+		want  bool
+	}{
+		{[]string{"ERROR: Unable to download video subtitles for 'en': HTTP Error 429"}, true},
+		{[]string{"[SubtitlesConvertor] Converting subtitles", "ERROR: Postprocessing: Conversion failed!"}, true},
+		{[]string{"[youtube] abcdefghijk: Downloading webpage", "ERROR: [youtube] abcdefghijk: Video unavailable"}, false},
+		{[]string{"[info] Writing video subtitles to: out.en.vtt", "[ExtractAudio] Destination: out.mp3", "ERROR: Postprocessing: audio conversion failed"}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := subtitleFailure(tt.lines); got != tt.want {
+			t.Errorf("subtitleFailure(%q) = %v, want %v", tt.lines, got, tt.want)
+		}
+	}
+}
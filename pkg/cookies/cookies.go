@@ -0,0 +1,137 @@
+package cookies
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cookies in the Netscape format youtube-dl reads.
+type Jar struct {
+	Name    string
+	path    string
+	cookies []cookie
+}
+
+type cookie struct {
+	c          *http.Cookie
+	subdomains bool // Also sent to subdomains of the cookie's domain
+}
+
+type ctxKey struct{}
+
+// The jar that requests made under ctx send cookies from.
+func WithJar(ctx context.Context, j *Jar) context.Context {
+	if j == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxKey{}, j)
+}
+
+// Nil unless the context was given a jar.
+func FromContext(ctx context.Context) *Jar {
+	j, _ := ctx.Value(ctxKey{}).(*Jar)
+	return j
+}
+
+// Only the name, so logging a request never shows the cookies.
+func (j *Jar) String() string {
+	return j.Name
+}
+
+// Cookies to send with a request to u.
+func (j *Jar) For(u *url.URL) []*http.Cookie {
+	host := u.Hostname()
+	now := time.Now()
+	var out []*http.Cookie
+	for _, c := range j.cookies {
+		if host != c.c.Domain && !(c.subdomains && strings.HasSuffix(host, "."+c.c.Domain)) {
+			continue
+		}
+		if !strings.HasPrefix(u.Path, c.c.Path) && !(u.Path == "" && c.c.Path == "/") {
+			continue
+		}
+		if c.c.Secure && u.Scheme != "https" {
+			continue
+		}
+		if !c.c.Expires.IsZero() && c.c.Expires.Before(now) {
+			continue
+		}
+		out = append(out, c.c)
+	}
+	return out
+}
+
+// A private copy of the jar, removed by calling done.
+func (j *Jar) Copy() (path string, done func(), err error) {
+	b, err := ioutil.ReadFile(j.path)
+	if err != nil {
+		return "", nil, err
+	}
+	// TempFile creates it readable by the owner only.
+	f, err := ioutil.TempFile(filepath.Dir(j.path), "."+j.Name+"-*.tmp")
+	if err != nil {
+		return "", nil, err
+	}
+	done = func() { os.Remove(f.Name()) }
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		done()
+		return "", nil, err
+	}
+	if err := f.Close(); err != nil {
+		done()
+		return "", nil, err
+	}
+	return f.Name(), done, nil
+}
+
+// Lines are domain, subdomains, path, secure, expiry, name and value.
+func parse(b []byte) ([]cookie, error) {
+	var out []cookie
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimRight(s.Text(), "\r")
+		httpOnly := strings.HasPrefix(line, "#HttpOnly_")
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Split(line, "\t")
+		if len(f) != 7 {
+			return nil, fmt.Errorf("line %d: want 7 tab separated fields, got %d", n, len(f))
+		}
+		expires, err := strconv.ParseInt(f[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad expiry %q", n, f[4])
+		}
+		c := cookie{
+			c: &http.Cookie{
+				Name:     f[5],
+				Value:    f[6],
+				Domain:   strings.TrimPrefix(strings.ToLower(f[0]), "."),
+				Path:     f[2],
+				Secure:   strings.EqualFold(f[3], "TRUE"),
+				HttpOnly: httpOnly,
+			},
+			subdomains: strings.EqualFold(f[1], "TRUE") || strings.HasPrefix(f[0], "."),
+		}
+		if expires > 0 {
+			c.c.Expires = time.Unix(expires, 0)
+		}
+		if c.c.Domain == "" || c.c.Name == "" {
+			return nil, fmt.Errorf("line %d: missing domain or name", n)
+		}
+		out = append(out, c)
+	}
+	return out, s.Err()
+}
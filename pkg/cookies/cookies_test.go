@@ -0,0 +1,81 @@
+package cookies
+
+import (
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		want    []string // Name, domain and path of each cookie
+		wantErr bool
+	}{
+		{
+			name: "comments and blank lines",
+			file: "# Netscape HTTP Cookie File\n\n.youtube.com\tTRUE\t/\tTRUE\t0\tSID\tabc\r\n",
+			want: []string{"SID youtube.com /"},
+		},
+		{
+			name: "http only",
+			file: "#HttpOnly_www.youtube.com\tFALSE\t/watch\tFALSE\t2000000000\tLOGIN\txyz\n",
+			want: []string{"LOGIN www.youtube.com /watch"},
+		},
+		{name: "too few fields", file: "youtube.com\tTRUE\t/\tTRUE\t0\tSID\n", wantErr: true},
+		{name: "bad expiry", file: "youtube.com\tTRUE\t/\tTRUE\tsoon\tSID\tabc\n", wantErr: true},
+		{name: "no name", file: "youtube.com\tTRUE\t/\tTRUE\t0\t\tabc\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs, err := parse([]byte(tt.file))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err %v, want error %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, c := range cs {
+				got = append(got, c.c.Name+" "+c.c.Domain+" "+c.c.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJarFor(t *testing.T) {
+	past := time.Now().Add(-time.Hour).Unix()
+	cs, err := parse([]byte("" +
+		".youtube.com\tTRUE\t/\tFALSE\t0\tall\t1\n" +
+		"www.youtube.com\tFALSE\t/\tTRUE\t0\tsecure\t1\n" +
+		"www.youtube.com\tFALSE\t/api\tFALSE\t0\tapi\t1\n" +
+		"www.youtube.com\tFALSE\t/\tFALSE\t" + strconv.FormatInt(past, 10) + "\texpired\t1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := &Jar{Name: "test", cookies: cs}
+
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{"https://www.youtube.com/watch", []string{"all", "secure"}},
+		{"http://www.youtube.com/watch", []string{"all"}},
+		{"https://www.youtube.com/api/timedtext", []string{"all", "secure", "api"}},
+		{"https://youtube.com", []string{"all"}},
+		{"https://notyoutube.com/", nil},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		var got []string
+		for _, c := range j.For(u) {
+			got = append(got, c.Name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
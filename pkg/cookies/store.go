@@ -0,0 +1,116 @@
+package cookies
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/nlsun/rss-reflector/pkg/util"
+)
+
+// Named jars kept as files only the owner can read.
+type Store struct {
+	dir string
+}
+
+var nameRe = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+const ext = ".txt"
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, util.PrivateDirPerm); err != nil {
+		return nil, err
+	}
+	// MkdirAll leaves an existing directory as it was.
+	if err := os.Chmod(dir, util.PrivateDirPerm); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Reports whether name can be used for a jar.
+func ValidName(name string) bool {
+	return nameRe.MatchString(name)
+}
+
+// Copies the cookie file at src into the store under name.
+func (s *Store) Import(name, src string) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if cs, err := parse(b); err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	} else if len(cs) == 0 {
+		return fmt.Errorf("%s: no cookies", src)
+	}
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, util.PrivateFilePerm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// Returns false if there was no such jar.
+func (s *Store) Remove(name string) (bool, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return false, err
+	}
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Names of the jars, sorted.
+func (s *Store) List() ([]string, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range files {
+		name := strings.TrimSuffix(fi.Name(), ext)
+		if fi.Mode().IsRegular() && strings.HasSuffix(fi.Name(), ext) && ValidName(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// The named jar, read afresh. Nil if there is no such jar.
+func (s *Store) Jar(name string) (*Jar, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	cs, err := parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("cookies %s: %w", name, err)
+	}
+	return &Jar{Name: name, path: p, cookies: cs}, nil
+}
+
+func (s *Store) path(name string) (string, error) {
+	if !ValidName(name) {
+		return "", fmt.Errorf("bad cookies name %q", name)
+	}
+	return filepath.Join(s.dir, name+ext), nil
+}
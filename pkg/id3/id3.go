@@ -0,0 +1,258 @@
+package id3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"unicode/utf16"
+
+	"github.com/nlsun/rss-reflector/pkg/util"
+)
+
+// An ID3v2.3 tag. Frames are written in the order they were added.
+type Tag struct {
+	frames []frame
+}
+
+// A chapter, times are in milliseconds.
+type Chapter struct {
+	ID    string
+	Start uint32
+	End   uint32
+	Title string
+}
+
+type frame struct {
+	id   string
+	data []byte
+}
+
+const (
+	headerSize = 10
+
+	encodingLatin1 byte = 0
+	encodingUTF16  byte = 1
+
+	// Offsets are unused, times are what players look at.
+	noOffset uint32 = 0xFFFFFFFF
+
+	tocID       = "toc"
+	maxChapters = 255
+
+	// Picture type of the front cover.
+	PictureFrontCover byte = 0x03
+)
+
+// Sets a text frame such as TIT2, replacing any previous value.
+func (t *Tag) SetText(id, text string) {
+	t.set(id, encodeText(text))
+}
+
+// Sets the COMM frame. lang is an ISO 639-2 code such as "eng".
+func (t *Tag) SetComment(lang, text string) {
+	var data bytes.Buffer
+	enc := encodingFor(text)
+	data.WriteByte(enc)
+	data.WriteString(padLang(lang))
+	// Empty content descriptor.
+	data.Write(terminator(enc))
+	data.Write(encodeWith(enc, text))
+	t.set("COMM", data.Bytes())
+}
+
+// Sets the APIC frame to an image such as image/jpeg.
+func (t *Tag) SetPicture(mimeType string, pictureType byte, image []byte) {
+	var data bytes.Buffer
+	data.WriteByte(encodingLatin1)
+	data.WriteString(mimeType + "\x00")
+	data.WriteByte(pictureType)
+	// Empty description.
+	data.WriteByte(0)
+	data.Write(image)
+	t.set("APIC", data.Bytes())
+}
+
+// Adds chapter frames along with a table of contents that lists them.
+func (t *Tag) SetChapters(chapters []Chapter) {
+	t.remove("CHAP")
+	t.remove("CTOC")
+	if len(chapters) == 0 {
+		return
+	}
+	// The table of contents counts its entries in a single byte.
+	if len(chapters) > maxChapters {
+		chapters = chapters[:maxChapters]
+	}
+
+	var toc bytes.Buffer
+	toc.WriteString(tocID + "\x00")
+	// Top-level and ordered.
+	toc.WriteByte(0x03)
+	toc.WriteByte(byte(len(chapters)))
+	for _, c := range chapters {
+		toc.WriteString(c.ID + "\x00")
+	}
+	t.frames = append(t.frames, frame{id: "CTOC", data: toc.Bytes()})
+
+	for _, c := range chapters {
+		var chap bytes.Buffer
+		chap.WriteString(c.ID + "\x00")
+		binary.Write(&chap, binary.BigEndian, [4]uint32{c.Start, c.End, noOffset, noOffset})
+		writeFrame(&chap, frame{id: "TIT2", data: encodeText(c.Title)})
+		t.frames = append(t.frames, frame{id: "CHAP", data: chap.Bytes()})
+	}
+}
+
+func (t *Tag) set(id string, data []byte) {
+	for i := range t.frames {
+		if t.frames[i].id == id {
+			t.frames[i].data = data
+			return
+		}
+	}
+	t.frames = append(t.frames, frame{id: id, data: data})
+}
+
+func (t *Tag) remove(id string) {
+	frames := t.frames[:0]
+	for _, f := range t.frames {
+		if f.id != id {
+			frames = append(frames, f)
+		}
+	}
+	t.frames = frames
+}
+
+func (t *Tag) bytes() []byte {
+	var body bytes.Buffer
+	for _, f := range t.frames {
+		writeFrame(&body, f)
+	}
+	var out bytes.Buffer
+	out.WriteString("ID3")
+	// Version 2.3.0, no flags.
+	out.Write([]byte{3, 0, 0})
+	out.Write(synchsafe(uint32(body.Len())))
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+// Writes the tag to the file, replacing any ID3v2 tag already there.
+func WriteFile(path string, t *Tag) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	skip, err := existingTagSize(in)
+	if err != nil {
+		return err
+	}
+	if _, err := in.Seek(skip, io.SeekStart); err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".id3")
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, util.DefaultFilePerm)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(t.bytes()); err != nil {
+		out.Close()
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Size of the ID3v2 tag at the start of r, 0 if there is none.
+func existingTagSize(r io.Reader) (int64, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if string(header[:3]) != "ID3" {
+		return 0, nil
+	}
+	size := int64(headerSize) + int64(unsynchsafe(header[6:10]))
+	// Footer present.
+	if header[5]&0x10 != 0 {
+		size += headerSize
+	}
+	return size, nil
+}
+
+func writeFrame(w *bytes.Buffer, f frame) {
+	if len(f.id) != 4 {
+		panic(fmt.Sprintf("id3: bad frame id %q", f.id))
+	}
+	w.WriteString(f.id)
+	binary.Write(w, binary.BigEndian, uint32(len(f.data)))
+	// No flags.
+	w.Write([]byte{0, 0})
+	w.Write(f.data)
+}
+
+// Encodes as Latin-1 when possible, UTF-16 with a byte order mark otherwise.
+func encodeText(s string) []byte {
+	enc := encodingFor(s)
+	return append([]byte{enc}, encodeWith(enc, s)...)
+}
+
+func encodingFor(s string) byte {
+	for _, r := range s {
+		if r > 0xFF {
+			return encodingUTF16
+		}
+	}
+	return encodingLatin1
+}
+
+// Encodes a terminated string, without the leading encoding byte.
+func encodeWith(enc byte, s string) []byte {
+	if enc == encodingLatin1 {
+		var out []byte
+		for _, r := range s {
+			out = append(out, byte(r))
+		}
+		return append(out, 0)
+	}
+	out := []byte{0xFF, 0xFE}
+	for _, u := range utf16.Encode([]rune(s)) {
+		out = append(out, byte(u), byte(u>>8))
+	}
+	return append(out, 0, 0)
+}
+
+func terminator(enc byte) []byte {
+	if enc == encodingLatin1 {
+		return []byte{0}
+	}
+	// An empty UTF-16 string still carries its byte order mark.
+	return []byte{0xFF, 0xFE, 0, 0}
+}
+
+// Language codes are always three characters, "xxx" is unknown.
+func padLang(lang string) string {
+	return (lang + "xxx")[:3]
+}
+
+func synchsafe(n uint32) []byte {
+	return []byte{byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+}
+
+func unsynchsafe(b []byte) uint32 {
+	return uint32(b[0])<<21 | uint32(b[1])<<14 | uint32(b[2])<<7 | uint32(b[3])
+}
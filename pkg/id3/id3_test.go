@@ -0,0 +1,173 @@
+package id3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Splits the frames back out of a tag's body.
+func readFrames(t *testing.T, body []byte) []frame {
+	var frames []frame
+	for len(body) > 0 {
+		if len(body) < headerSize {
+			t.Fatalf("%d bytes left over", len(body))
+		}
+		size := binary.BigEndian.Uint32(body[4:8])
+		if int(size) > len(body)-headerSize {
+			t.Fatalf("frame %q of %d bytes overruns the tag", body[:4], size)
+		}
+		frames = append(frames, frame{id: string(body[:4]), data: body[headerSize : headerSize+size]})
+		body = body[headerSize+size:]
+	}
+	return frames
+}
+
+// The frames of a whole tag, after checking its header.
+func tagFrames(t *testing.T, tag []byte) []frame {
+	if string(tag[:5]) != "ID3\x03\x00" {
+		t.Fatalf("header %q", tag[:5])
+	}
+	if size := unsynchsafe(tag[6:10]); int(size) != len(tag)-headerSize {
+		t.Fatalf("size %d, want %d", size, len(tag)-headerSize)
+	}
+	return readFrames(t, tag[headerSize:])
+}
+
+func TestSetText(t *testing.T) {
+	var tag Tag
+	tag.SetText("TIT2", "Café")
+	tag.SetText("TPE1", "日本")
+	tag.SetText("TIT2", "Replaced")
+	frames := tagFrames(t, tag.bytes())
+	want := []frame{
+		{id: "TIT2", data: []byte("\x00Replaced\x00")},
+		{id: "TPE1", data: []byte{encodingUTF16, 0xFF, 0xFE, 0xE5, 0x65, 0x2C, 0x67, 0, 0}},
+	}
+	if fmt.Sprint(frames) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", frames, want)
+	}
+
+	tag.SetText("TALB", "Café")
+	if frames := tagFrames(t, tag.bytes()); !bytes.Equal(frames[2].data, []byte{0, 'C', 'a', 'f', 0xE9, 0}) {
+		t.Errorf("latin-1 text %v", frames[2].data)
+	}
+}
+
+func TestSetComment(t *testing.T) {
+	var tag Tag
+	tag.SetComment("en", "https://example.com")
+	frames := tagFrames(t, tag.bytes())
+	if want := "\x00enx\x00https://example.com\x00"; string(frames[0].data) != want {
+		t.Errorf("got %q, want %q", frames[0].data, want)
+	}
+	tag.SetComment("eng", "→")
+	frames = tagFrames(t, tag.bytes())
+	if want := "\x01eng\xff\xfe\x00\x00\xff\xfe\x92\x21\x00\x00"; string(frames[0].data) != want {
+		t.Errorf("got %q, want %q", frames[0].data, want)
+	}
+}
+
+func TestSetPicture(t *testing.T) {
+	var tag Tag
+	tag.SetPicture("image/jpeg", PictureFrontCover, []byte("jpeg"))
+	frames := tagFrames(t, tag.bytes())
+	if want := "\x00image/jpeg\x00\x03\x00jpeg"; frames[0].id != "APIC" || string(frames[0].data) != want {
+		t.Errorf("got %s %q, want %q", frames[0].id, frames[0].data, want)
+	}
+}
+
+func TestSetChapters(t *testing.T) {
+	var tag Tag
+	tag.SetText("TIT2", "Title")
+	tag.SetChapters([]Chapter{
+		{ID: "ch0", Start: 0, End: 1000, Title: "One"},
+		{ID: "ch1", Start: 1000, End: 2500, Title: "Two"},
+	})
+	frames := tagFrames(t, tag.bytes())
+	if len(frames) != 4 || frames[1].id != "CTOC" || frames[2].id != "CHAP" || frames[3].id != "CHAP" {
+		t.Fatalf("frames %v", frames)
+	}
+	if want := "toc\x00\x03\x02ch0\x00ch1\x00"; string(frames[1].data) != want {
+		t.Errorf("toc %q, want %q", frames[1].data, want)
+	}
+	chap := frames[3].data
+	if !bytes.HasPrefix(chap, []byte("ch1\x00")) {
+		t.Fatalf("chapter %q", chap)
+	}
+	chap = chap[4:]
+	var times [4]uint32
+	binary.Read(bytes.NewReader(chap[:16]), binary.BigEndian, &times)
+	if times != [4]uint32{1000, 2500, noOffset, noOffset} {
+		t.Errorf("times %v", times)
+	}
+	sub := readFrames(t, chap[16:])
+	if len(sub) != 1 || sub[0].id != "TIT2" || string(sub[0].data) != "\x00Two\x00" {
+		t.Errorf("chapter frames %v", sub)
+	}
+
+	// Setting again replaces, and the table of contents counts in a byte.
+	many := make([]Chapter, maxChapters+10)
+	for i := range many {
+		many[i] = Chapter{ID: fmt.Sprint("ch", i), Start: uint32(i), End: uint32(i + 1)}
+	}
+	tag.SetChapters(many)
+	frames = tagFrames(t, tag.bytes())
+	if len(frames) != 2+maxChapters || frames[1].data[5] != maxChapters {
+		t.Errorf("%d frames, %d in the table of contents", len(frames), frames[1].data[5])
+	}
+	tag.SetChapters(nil)
+	if frames := tagFrames(t, tag.bytes()); len(frames) != 1 {
+		t.Errorf("frames %v after removing the chapters", frames)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	var old Tag
+	old.SetText("TIT2", "Old title")
+	oldTag := old.bytes()
+	// With a footer, which counts towards what is replaced.
+	oldTag[5] |= 0x10
+	oldTag = append(oldTag, []byte("3DI\x03\x00\x10\x00\x00\x00\x00")...)
+
+	var tag Tag
+	tag.SetText("TIT2", "New title")
+	for name, existing := range map[string][]byte{"untagged": nil, "tagged": oldTag} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audio.mp3")
+			if err := ioutil.WriteFile(path, append(existing, "audio frames"...), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := WriteFile(path, &tag); err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := append(tag.bytes(), "audio frames"...); !bytes.Equal(b, want) {
+				t.Errorf("got %q, want %q", b, want)
+			}
+			if files, _ := ioutil.ReadDir(filepath.Dir(path)); len(files) != 1 {
+				t.Errorf("%d files left", len(files))
+			}
+		})
+	}
+}
+
+func TestSynchsafe(t *testing.T) {
+	for _, n := range []uint32{0, 127, 128, 1 << 20, 1<<28 - 1} {
+		b := synchsafe(n)
+		for _, c := range b {
+			if c&0x80 != 0 {
+				t.Errorf("%d: high bit set in %v", n, b)
+			}
+		}
+		if got := unsynchsafe(b); got != n {
+			t.Errorf("%d: got %d back", n, got)
+		}
+	}
+}
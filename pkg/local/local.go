@@ -0,0 +1,218 @@
+package local
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nlsun/rss-reflector/pkg/content"
+	"github.com/nlsun/rss-reflector/pkg/log"
+)
+
+var logger = log.DefaultLogger
+
+// A directory of media files served as a feed.
+type Dir struct {
+	Name      string // Name used in urls
+	Path      string // Directory on disk
+	Recursive bool   // Include subdirectories
+}
+
+type Item struct {
+	Path        string    // Relative to the directory, slash separated
+	Title       string    // From the sidecar, the file name otherwise
+	Description string    // From the sidecar
+	Author      string    // From the sidecar
+	Date        time.Time // From the sidecar, the modification time otherwise
+	Size        int64     // File size in bytes
+	MimeType    string    // Media type from the extension
+}
+
+// Optional metadata next to a media file, as <file>.json or <name>.json.
+type sidecar struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Author      string `json:"author"`
+	Date        string `json:"date"` // YYYY-MM-DD or RFC 3339
+}
+
+// An index of the media in each directory, rescanned periodically.
+type Library struct {
+	mu    sync.Mutex
+	dirs  map[string]Dir
+	items map[string][]Item // Newest first, by directory name
+	stopC chan struct{}
+}
+
+func NewLibrary(dirs []Dir, poll time.Duration) *Library {
+	l := &Library{
+		dirs:  map[string]Dir{},
+		items: map[string][]Item{},
+		stopC: make(chan struct{}),
+	}
+	for _, d := range dirs {
+		l.dirs[d.Name] = d
+	}
+	l.scanAll()
+	if poll > 0 && len(dirs) > 0 {
+		go l.watch(poll)
+	}
+	return l
+}
+
+// Items of the named directory, newest first.
+func (l *Library) Items(name string) ([]Item, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.dirs[name]; !ok {
+		return nil, false
+	}
+	return l.items[name], true
+}
+
+// Opens an indexed file. Anything else doesn't exist.
+func (l *Library) Open(name, rel string) (*os.File, Item, error) {
+	l.mu.Lock()
+	dir, ok := l.dirs[name]
+	items := l.items[name]
+	l.mu.Unlock()
+	if ok {
+		for _, item := range items {
+			if item.Path == rel {
+				f, err := os.Open(filepath.Join(dir.Path, filepath.FromSlash(rel)))
+				return f, item, err
+			}
+		}
+	}
+	return nil, Item{}, os.ErrNotExist
+}
+
+// Stops watching for new files.
+func (l *Library) Close() {
+	close(l.stopC)
+}
+
+func (l *Library) watch(poll time.Duration) {
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.scanAll()
+		case <-l.stopC:
+			return
+		}
+	}
+}
+
+func (l *Library) scanAll() {
+	for name, dir := range l.dirs {
+		items, err := scan(dir)
+		if err != nil {
+			logger.Printf("scanning %s: %s", dir.Path, err)
+			continue
+		}
+		l.mu.Lock()
+		if n := len(items) - len(l.items[name]); n > 0 && l.items[name] != nil {
+			logger.Printf("found %d new files in %s", n, dir.Path)
+		}
+		l.items[name] = items
+		l.mu.Unlock()
+	}
+}
+
+func scan(dir Dir) ([]Item, error) {
+	var items []Item
+	err := filepath.Walk(dir.Path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if p != dir.Path && (!dir.Recursive || strings.HasPrefix(fi.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		mimeType := content.MimeType(p)
+		if !fi.Mode().IsRegular() || strings.HasPrefix(fi.Name(), ".") || !isMedia(mimeType) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir.Path, p)
+		if err != nil {
+			return err
+		}
+		items = append(items, newItem(p, filepath.ToSlash(rel), fi, mimeType))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Date.After(items[j].Date) })
+	return items, nil
+}
+
+func newItem(p, rel string, fi os.FileInfo, mimeType string) Item {
+	ext := path.Ext(rel)
+	item := Item{
+		Path:     rel,
+		Title:    strings.TrimSuffix(path.Base(rel), ext),
+		Date:     fi.ModTime(),
+		Size:     fi.Size(),
+		MimeType: mimeType,
+	}
+	sc, err := readSidecar(p)
+	if err != nil {
+		logger.Printf("sidecar of %s: %s", p, err)
+	}
+	if sc == nil {
+		return item
+	}
+	if sc.Title != "" {
+		item.Title = sc.Title
+	}
+	item.Description = sc.Description
+	item.Author = sc.Author
+	if sc.Date != "" {
+		if t, err := parseDate(sc.Date); err != nil {
+			logger.Printf("sidecar of %s: %s", p, err)
+		} else {
+			item.Date = t
+		}
+	}
+	return item
+}
+
+// Returns nil if there is no sidecar.
+func readSidecar(p string) (*sidecar, error) {
+	for _, name := range []string{p + ".json", strings.TrimSuffix(p, filepath.Ext(p)) + ".json"} {
+		b, err := ioutil.ReadFile(name)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		var sc sidecar
+		if err := json.Unmarshal(b, &sc); err != nil {
+			return nil, err
+		}
+		return &sc, nil
+	}
+	return nil, nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func isMedia(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "audio/") || strings.HasPrefix(mimeType, "video/")
+}
@@ -0,0 +1,174 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/nlsun/rss-reflector/pkg/util"
+)
+
+// Something queued to listen to later.
+type Entry struct {
+	ID          string    `json:"id"`                    // YouTube video id
+	URL         string    `json:"url"`                   // Watch url
+	Title       string    `json:"title,omitempty"`       // Given when added or looked up later
+	Description string    `json:"description,omitempty"` // Looked up after adding
+	Author      string    `json:"author,omitempty"`      // Looked up after adding
+	Added       time.Time `json:"added"`
+}
+
+// Named queues persisted as JSON files, one per queue.
+type Store struct {
+	mu     *sync.Mutex // Shared with the owners' stores
+	dir    string      // Where the queue files are
+	maxLen int         // Oldest entries are dropped past this, 0 for no limit
+}
+
+var nameRe = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+func NewStore(dir string, maxLen int) (*Store, error) {
+	if err := os.MkdirAll(dir, util.DefaultDirPerm); err != nil {
+		return nil, err
+	}
+	return &Store{mu: &sync.Mutex{}, dir: dir, maxLen: maxLen}, nil
+}
+
+// The queues of owner, apart from everyone else's.
+func (s *Store) Owner(owner string) *Store {
+	if owner == "" {
+		return s
+	}
+	return &Store{mu: s.mu, dir: filepath.Join(s.dir, "@"+url.PathEscape(owner)), maxLen: s.maxLen}
+}
+
+// Reports whether name can be used for a queue.
+func ValidName(name string) bool {
+	return nameRe.MatchString(name)
+}
+
+// Entries of the queue, newest first. An unknown queue is empty.
+func (s *Store) List(name string) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(name)
+}
+
+// Adds an entry to the front of the queue, or moves it there.
+func (s *Store) Add(name string, e Entry) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.read(name)
+	if err != nil {
+		return Entry{}, err
+	}
+	for i, old := range entries {
+		if old.ID == e.ID {
+			if e.Title == "" {
+				e.Title = old.Title
+			}
+			e.Description, e.Author = old.Description, old.Author
+			entries = append(entries[:i], entries[i+1:]...)
+			break
+		}
+	}
+	e.Added = time.Now().UTC()
+	entries = append([]Entry{e}, entries...)
+	if s.maxLen > 0 && len(entries) > s.maxLen {
+		entries = entries[:s.maxLen]
+	}
+	return e, s.write(name, entries)
+}
+
+// Fills in what was looked up about an entry. Fields already set are kept.
+func (s *Store) Update(name string, e Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.read(name)
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		if entries[i].ID != e.ID {
+			continue
+		}
+		if entries[i].Title == "" {
+			entries[i].Title = e.Title
+		}
+		if entries[i].Description == "" {
+			entries[i].Description = e.Description
+		}
+		if entries[i].Author == "" {
+			entries[i].Author = e.Author
+		}
+		return s.write(name, entries)
+	}
+	return nil
+}
+
+// Returns false if the entry wasn't in the queue.
+func (s *Store) Remove(name, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.read(name)
+	if err != nil {
+		return false, err
+	}
+	for i, e := range entries {
+		if e.ID == id {
+			return true, s.write(name, append(entries[:i], entries[i+1:]...))
+		}
+	}
+	return false, nil
+}
+
+func (s *Store) path(name string) (string, error) {
+	if !ValidName(name) {
+		return "", fmt.Errorf("bad queue name %q", name)
+	}
+	return filepath.Join(s.dir, name+".json"), nil
+}
+
+func (s *Store) read(name string) ([]Entry, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Written next to the queue and moved into place.
+func (s *Store) write(name string, entries []Entry) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, util.DefaultDirPerm); err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, util.DefaultFilePerm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
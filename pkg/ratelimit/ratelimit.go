@@ -0,0 +1,225 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Buckets, and keys of a quota, kept at most.
+	maxBuckets   = 10000
+	maxQuotaKeys = 10000
+	// How often buckets that have filled up again are dropped.
+	pruneInterval = time.Minute
+)
+
+// Requests per minute with bursts of up to Burst. Zero is unlimited.
+type Rate struct {
+	PerMinute float64
+	Burst     int
+}
+
+// Parses "<per minute>" or "<per minute>/<burst>", e.g. 10/30.
+func ParseRate(spec string) (Rate, error) {
+	if spec == "" {
+		return Rate{}, nil
+	}
+	parts := strings.SplitN(spec, "/", 2)
+	perMinute, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || perMinute < 0 {
+		return Rate{}, fmt.Errorf("bad rate %q", spec)
+	}
+	r := Rate{PerMinute: perMinute, Burst: int(math.Ceil(perMinute))}
+	if len(parts) == 2 {
+		if r.Burst, err = strconv.Atoi(parts[1]); err != nil || r.Burst < 1 {
+			return Rate{}, fmt.Errorf("bad rate %q", spec)
+		}
+	}
+	return r, nil
+}
+
+func (r Rate) String() string {
+	if r.PerMinute == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%g/min, bursts of %d", r.PerMinute, r.Burst)
+}
+
+// A token bucket for each key, such as a client address.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    Rate
+	buckets map[string]*bucket
+	pruned  time.Time // When buckets were last pruned
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time // When tokens was last brought up to date
+}
+
+func NewLimiter(rate Rate) *Limiter {
+	return &Limiter{rate: rate, buckets: map[string]*bucket{}}
+}
+
+// Takes a token from the key's bucket, or reports how long until there is one.
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	return l.AllowAll([]string{key}, now)
+}
+
+// Takes a token from the bucket of each key if they all have one.
+func (l *Limiter) AllowAll(keys []string, now time.Time) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate.PerMinute == 0 {
+		return true, 0
+	}
+	perSecond := l.rate.PerMinute / 60
+	if now.Sub(l.pruned) >= pruneInterval {
+		l.prune(now)
+	}
+	buckets := make([]*bucket, len(keys))
+	var wait time.Duration
+	for i, key := range keys {
+		b, ok := l.buckets[key]
+		if !ok {
+			if len(l.buckets) >= maxBuckets {
+				l.prune(now)
+			}
+			b = &bucket{tokens: float64(l.rate.Burst), last: now}
+			l.buckets[key] = b
+		}
+		b.tokens = math.Min(float64(l.rate.Burst), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+		b.last = now
+		if w := time.Duration((1 - b.tokens) / perSecond * float64(time.Second)); b.tokens < 1 && w > wait {
+			wait = w
+		}
+		buckets[i] = b
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// Buckets start over at the new rate.
+func (l *Limiter) SetRate(rate Rate) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if rate != l.rate {
+		l.rate, l.buckets = rate, map[string]*bucket{}
+	}
+}
+
+// Must be called with the lock held. Drops full buckets, then the ones used
+// longest ago if there are still too many.
+func (l *Limiter) prune(now time.Time) {
+	l.pruned = now
+	refill := time.Duration(float64(l.rate.Burst) / (l.rate.PerMinute / 60) * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, key)
+		}
+	}
+	if len(l.buckets) < maxBuckets {
+		return
+	}
+	keys := make([]string, 0, len(l.buckets))
+	for key := range l.buckets {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return l.buckets[keys[i]].last.Before(l.buckets[keys[j]].last) })
+	for _, key := range keys[:len(keys)-maxBuckets*3/4] {
+		delete(l.buckets, key)
+	}
+}
+
+// How much each key may use per UTC day, such as bytes or downloads.
+type Quota struct {
+	mu    sync.Mutex
+	limit int64
+	day   string // The day used counts toward
+	used  map[string]int64
+}
+
+func NewQuota(limit int64) *Quota {
+	return &Quota{limit: limit, used: map[string]int64{}}
+}
+
+// Reports whether the key has quota left, or how long until it is reset.
+func (q *Quota) Allow(key string, now time.Time) (bool, time.Duration) {
+	if q == nil {
+		return true, 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.limit == 0 {
+		return true, 0
+	}
+	q.roll(now)
+	if q.used[key] < q.limit {
+		return true, 0
+	}
+	return false, untilTomorrow(now)
+}
+
+// Counts n toward the key's quota.
+func (q *Quota) Use(key string, n int64, now time.Time) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.limit == 0 {
+		return
+	}
+	q.roll(now)
+	if _, ok := q.used[key]; !ok && len(q.used) >= maxQuotaKeys {
+		q.prune()
+	}
+	q.used[key] += n
+}
+
+// What has been used today still counts toward the new limit.
+func (q *Quota) SetLimit(limit int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.limit = limit
+}
+
+// Must be called with the lock held.
+func (q *Quota) roll(now time.Time) {
+	day := now.UTC().Format("2006-01-02")
+	if day != q.day {
+		q.day, q.used = day, map[string]int64{}
+	}
+}
+
+// Must be called with the lock held. Forgets the keys that used the least.
+func (q *Quota) prune() {
+	keys := make([]string, 0, len(q.used))
+	for key := range q.used {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return q.used[keys[i]] < q.used[keys[j]] })
+	for _, key := range keys[:len(keys)-maxQuotaKeys*3/4] {
+		delete(q.used, key)
+	}
+}
+
+func untilTomorrow(now time.Time) time.Duration {
+	now = now.UTC()
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return tomorrow.Sub(now)
+}
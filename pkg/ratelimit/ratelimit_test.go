@@ -0,0 +1,135 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Rate
+		wantErr bool
+	}{
+		{spec: "", want: Rate{}},
+		{spec: "10", want: Rate{PerMinute: 10, Burst: 10}},
+		{spec: "0.5", want: Rate{PerMinute: 0.5, Burst: 1}},
+		{spec: "10/30", want: Rate{PerMinute: 10, Burst: 30}},
+		{spec: "-1", wantErr: true},
+		{spec: "ten", wantErr: true},
+		{spec: "10/0", wantErr: true},
+		{spec: "10/x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: err %v, want error %v", tt.spec, err, tt.wantErr)
+		} else if got != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(Rate{PerMinute: 60, Burst: 2})
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a", now); !ok {
+			t.Fatalf("request %d of the burst refused", i)
+		}
+	}
+	ok, wait := l.Allow("a", now)
+	if ok || wait != time.Second {
+		t.Errorf("over the burst: got %v %s, want a wait of 1s", ok, wait)
+	}
+	if ok, _ := l.Allow("b", now); !ok {
+		t.Error("other key limited")
+	}
+	if ok, _ := l.Allow("a", now.Add(time.Second)); !ok {
+		t.Error("refilled token refused")
+	}
+
+	// Buckets that filled up again go once a minute.
+	l.Allow("c", now.Add(pruneInterval))
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("idle bucket kept")
+	}
+	if _, ok := l.buckets["c"]; !ok {
+		t.Error("new bucket missing")
+	}
+
+	var unlimited *Limiter
+	if ok, _ := unlimited.Allow("a", now); !ok {
+		t.Error("nil limiter limited")
+	}
+}
+
+func TestLimiterAllowAll(t *testing.T) {
+	l := NewLimiter(Rate{PerMinute: 60, Burst: 1})
+	now := time.Now()
+	if ok, _ := l.Allow("token", now); !ok {
+		t.Fatal("first request refused")
+	}
+	// The empty token bucket turns the request away without using the
+	// address's token.
+	if ok, wait := l.AllowAll([]string{"ip", "token"}, now); ok || wait != time.Second {
+		t.Errorf("got %v %s, want a wait of 1s", ok, wait)
+	}
+	if ok, _ := l.Allow("ip", now); !ok {
+		t.Error("address token taken by a refused request")
+	}
+}
+
+func TestLimiterBusyBuckets(t *testing.T) {
+	l := NewLimiter(Rate{PerMinute: 1, Burst: 1})
+	now := time.Now()
+	// None fill up again within the test, so the oldest have to go.
+	for i := 0; i <= maxBuckets; i++ {
+		l.Allow(fmt.Sprint(i), now.Add(time.Duration(i)*time.Millisecond))
+	}
+	if len(l.buckets) > maxBuckets {
+		t.Fatalf("%d buckets", len(l.buckets))
+	}
+	if _, ok := l.buckets["0"]; ok {
+		t.Error("oldest bucket kept")
+	}
+	if ok, _ := l.Allow(fmt.Sprint(maxBuckets), now.Add(time.Second)); ok {
+		t.Error("newest bucket dropped")
+	}
+}
+
+func TestQuota(t *testing.T) {
+	q := NewQuota(3)
+	day := time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC)
+	q.Use("a", 2, day)
+	if ok, _ := q.Allow("a", day); !ok {
+		t.Error("refused with quota left")
+	}
+	q.Use("a", 1, day)
+	ok, wait := q.Allow("a", day)
+	if ok || wait != time.Hour {
+		t.Errorf("used up: got %v %s, want a wait of 1h", ok, wait)
+	}
+	if ok, _ := q.Allow("b", day); !ok {
+		t.Error("other key refused")
+	}
+	if ok, _ := q.Allow("a", day.Add(time.Hour)); !ok {
+		t.Error("refused the next day")
+	}
+}
+
+func TestQuotaKeepsHeavyUsers(t *testing.T) {
+	q := NewQuota(10)
+	now := time.Now()
+	q.Use("heavy", 10, now)
+	for i := 0; i < maxQuotaKeys; i++ {
+		q.Use(fmt.Sprint(i), 1, now)
+	}
+	if len(q.used) > maxQuotaKeys {
+		t.Fatalf("%d keys", len(q.used))
+	}
+	if ok, _ := q.Allow("heavy", now); ok {
+		t.Error("key that used up its quota forgotten")
+	}
+}
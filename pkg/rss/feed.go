@@ -0,0 +1,50 @@
+package rss
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	feedI "github.com/mmcdole/gofeed"
+)
+
+// Reflects any RSS or Atom feed, serving its enclosures.
+func GenFeedRSS(ctx context.Context, feedURL string, opts Options) (string, error) {
+	inFeed, err := fetchFeed(ctx, opts.Client, feedURL)
+	if err != nil {
+		return "", err
+	}
+	// Durations and such can't be looked up for arbitrary media.
+	inFeed.Items = opts.Filter.apply(ctx, opts.Client, inFeed.Items, nil)
+	opts.Rewrite.apply(inFeed)
+	return buildRSS(inFeed, opts, feedEnclosure)
+}
+
+// The first audio or video enclosure of the item.
+func feedEnclosure(item *feedI.Item, opts Options, contentQuery url.Values) (enclosure, bool, error) {
+	for _, enc := range item.Enclosures {
+		if enc.URL == "" || !isMediaType(enc.Type) {
+			continue
+		}
+		q := url.Values{}
+		for k, v := range contentQuery {
+			q[k] = v
+		}
+		q.Set(opts.URLParam, enc.URL)
+		typ := opts.EnclosureType
+		if typ == "" {
+			typ = enc.Type
+		}
+		link, err := reflectorLink(opts.Base, opts.ContentPrePath, q, opts.Sign)
+		if err != nil {
+			return enclosure{}, false, err
+		}
+		return enclosure{url: link, typ: typ}, true, nil
+	}
+	return enclosure{}, false, nil
+}
+
+// Feeds often leave the type out.
+func isMediaType(t string) bool {
+	return t == "" || strings.HasPrefix(t, "audio/") || strings.HasPrefix(t, "video/")
+}
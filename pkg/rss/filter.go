@@ -0,0 +1,222 @@
+package rss
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	feedI "github.com/mmcdole/gofeed"
+
+	"github.com/nlsun/rss-reflector/pkg/upstream"
+)
+
+// Decides which items of a feed are kept. The zero value keeps everything.
+type Filter struct {
+	Include     *regexp.Regexp // Titles must match, if set
+	Exclude     *regexp.Regexp // Titles must not match, if set
+	MinDuration time.Duration  // Shorter videos are dropped, if set
+	MaxDuration time.Duration  // Longer videos are dropped, if set
+	DropShorts  bool           // Drop YouTube Shorts
+	DropLive    bool           // Drop upcoming premieres, live streams and their recordings
+	After       time.Time      // Older items are dropped, if set
+}
+
+// The configuration form of a Filter.
+type FilterConfig struct {
+	Include     string `json:"include,omitempty"`
+	Exclude     string `json:"exclude,omitempty"`
+	MinDuration string `json:"min_duration,omitempty"` // e.g. 90s or 5m
+	MaxDuration string `json:"max_duration,omitempty"`
+	DropShorts  bool   `json:"drop_shorts,omitempty"`
+	DropLive    bool   `json:"drop_live,omitempty"`
+	After       string `json:"after,omitempty"` // YYYY-MM-DD or RFC 3339
+}
+
+// What filters need to know about a video that isn't in the feed.
+type VideoDetails struct {
+	Duration time.Duration
+	Live     bool // Upcoming, live, or recorded from a live stream
+}
+
+const (
+	// Videos checked at once while filtering a feed.
+	maxVideoChecks = 4
+	// Videos whose Shorts status is remembered before starting over.
+	maxShortsCache = 1000
+)
+
+var shortsCache = struct {
+	sync.Mutex
+	shorts map[string]bool
+}{shorts: map[string]bool{}}
+
+func (c FilterConfig) Compile() (*Filter, error) {
+	f := &Filter{DropShorts: c.DropShorts, DropLive: c.DropLive}
+	var err error
+	if c.Include != "" {
+		if f.Include, err = regexp.Compile(c.Include); err != nil {
+			return nil, fmt.Errorf("include: %w", err)
+		}
+	}
+	if c.Exclude != "" {
+		if f.Exclude, err = regexp.Compile(c.Exclude); err != nil {
+			return nil, fmt.Errorf("exclude: %w", err)
+		}
+	}
+	if c.MinDuration != "" {
+		if f.MinDuration, err = time.ParseDuration(c.MinDuration); err != nil {
+			return nil, fmt.Errorf("min duration: %w", err)
+		}
+	}
+	if c.MaxDuration != "" {
+		if f.MaxDuration, err = time.ParseDuration(c.MaxDuration); err != nil {
+			return nil, fmt.Errorf("max duration: %w", err)
+		}
+	}
+	if c.After != "" {
+		if f.After, err = parseDate(c.After); err != nil {
+			return nil, fmt.Errorf("after: %w", err)
+		}
+	}
+	return f, nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// Duration and live status aren't in YouTube feeds.
+func (f *Filter) needsDetails() bool {
+	return f.MinDuration > 0 || f.MaxDuration > 0 || f.DropLive
+}
+
+// Drops the items the filter doesn't keep. Cheap checks go first.
+func (f *Filter) apply(ctx context.Context, client *upstream.Client, items []*feedI.Item, lookup func(context.Context, string) (*VideoDetails, error)) []*feedI.Item {
+	if f == nil {
+		return items
+	}
+	var kept []*feedI.Item
+	for _, item := range items {
+		if f.keepEntry(item) {
+			kept = append(kept, item)
+		}
+	}
+	if !f.DropShorts && (!f.needsDetails() || lookup == nil) {
+		return kept
+	}
+
+	keep := make([]bool, len(kept))
+	sem := make(chan struct{}, maxVideoChecks)
+	var wg sync.WaitGroup
+	for i, item := range kept {
+		wg.Add(1)
+		go func(i int, item *feedI.Item) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			keep[i] = f.keepVideo(ctx, client, item, lookup)
+		}(i, item)
+	}
+	wg.Wait()
+
+	var out []*feedI.Item
+	for i, item := range kept {
+		if keep[i] {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// Checks what the feed entry itself tells us.
+func (f *Filter) keepEntry(item *feedI.Item) bool {
+	if f.Include != nil && !f.Include.MatchString(item.Title) {
+		return false
+	}
+	if f.Exclude != nil && f.Exclude.MatchString(item.Title) {
+		return false
+	}
+	if !f.After.IsZero() && item.PublishedParsed != nil && item.PublishedParsed.Before(f.After) {
+		return false
+	}
+	return true
+}
+
+// Checks what has to be looked up. Videos that fail the lookup are kept.
+func (f *Filter) keepVideo(ctx context.Context, client *upstream.Client, item *feedI.Item, lookup func(context.Context, string) (*VideoDetails, error)) bool {
+	if f.DropShorts && isShort(ctx, client, item) {
+		return false
+	}
+	if !f.needsDetails() || lookup == nil {
+		return true
+	}
+	details, err := lookup(ctx, item.Link)
+	if err != nil {
+		logger.Printf("filter lookup %s: %s", item.Link, err)
+		return true
+	}
+	return f.keepDetails(details)
+}
+
+func (f *Filter) keepDetails(d *VideoDetails) bool {
+	if f.DropLive && d.Live {
+		return false
+	}
+	// Unknown durations, such as of upcoming videos, are never out of bounds.
+	if d.Duration > 0 {
+		if f.MinDuration > 0 && d.Duration < f.MinDuration {
+			return false
+		}
+		if f.MaxDuration > 0 && d.Duration > f.MaxDuration {
+			return false
+		}
+	}
+	return true
+}
+
+// Newer feeds link Shorts as such, older ones only have a Shorts page.
+func isShort(ctx context.Context, client *upstream.Client, item *feedI.Item) bool {
+	if strings.Contains(item.Link, "/shorts/") {
+		return true
+	}
+	id := videoID(item)
+	if id == "" {
+		return false
+	}
+
+	shortsCache.Lock()
+	short, ok := shortsCache.shorts[id]
+	shortsCache.Unlock()
+	if ok {
+		return short
+	}
+
+	req, err := http.NewRequest(http.MethodHead, "https://www.youtube.com/shorts/"+url.PathEscape(id), nil)
+	if err != nil {
+		return false
+	}
+	// A Short answers on its /shorts/ url, anything else redirects.
+	resp, err := client.WithoutRedirects().Do(req.WithContext(ctx))
+	if err != nil {
+		logger.Printf("shorts check %s: %s", id, err)
+		return false
+	}
+	resp.Body.Close()
+	short = resp.StatusCode == http.StatusOK
+
+	shortsCache.Lock()
+	if len(shortsCache.shorts) >= maxShortsCache {
+		shortsCache.shorts = map[string]bool{}
+	}
+	shortsCache.shorts[id] = short
+	shortsCache.Unlock()
+	return short
+}
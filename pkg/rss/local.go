@@ -0,0 +1,32 @@
+package rss
+
+import (
+	"context"
+	"net/url"
+	"path"
+
+	feedI "github.com/mmcdole/gofeed"
+)
+
+// Builds a feed of files the reflector serves itself.
+func GenLocalRSS(ctx context.Context, feed *feedI.Feed, opts Options) (string, error) {
+	feed.Items = opts.Filter.apply(ctx, opts.Client, feed.Items, nil)
+	opts.Rewrite.apply(feed)
+	return buildRSS(feed, opts, localEnclosure)
+}
+
+func localEnclosure(item *feedI.Item, opts Options, contentQuery url.Values) (enclosure, bool, error) {
+	if len(item.Enclosures) == 0 {
+		return enclosure{}, false, nil
+	}
+	enc := item.Enclosures[0]
+	link, err := reflectorLink(opts.Base, path.Join(opts.ContentPrePath, enc.URL), contentQuery, nil)
+	if err != nil {
+		return enclosure{}, false, err
+	}
+	return enclosure{
+		url:    link,
+		typ:    enc.Type,
+		length: enc.Length,
+	}, true, nil
+}
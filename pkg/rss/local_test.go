@@ -0,0 +1,30 @@
+package rss
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	feedI "github.com/mmcdole/gofeed"
+)
+
+func TestGenLocalRSSLinkQuery(t *testing.T) {
+	feed := &feedI.Feed{Title: "talks", Items: []*feedI.Item{{
+		Title:      "One",
+		GUID:       "local:talks/one.mp3",
+		Enclosures: []*feedI.Enclosure{{URL: "talks/one file.mp3", Type: "audio/mpeg", Length: "5"}},
+	}}}
+	out, err := GenLocalRSS(context.Background(), feed, Options{
+		Base:           url.URL{Scheme: "https", Host: "pods.example", Path: "/rr"},
+		ContentPrePath: "/content/local",
+		LinkQuery:      url.Values{"token": {"secret"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `url="https://pods.example/rr/content/local/talks/one%20file.mp3?token=secret"`
+	if !strings.Contains(out, want) {
+		t.Errorf("no %s in\n%s", want, out)
+	}
+}
@@ -0,0 +1,100 @@
+package rss
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	feedI "github.com/mmcdole/gofeed"
+)
+
+// A YouTube feed along with what is done to its items.
+type Source struct {
+	Path     string   // Feed path on YouTube, e.g. feeds/videos.xml
+	RawQuery string   // Feed query, e.g. channel_id=<id>
+	Rewrite  *Rewrite // Changes made to the items, optional
+	Filter   *Filter  // Decides which items are kept, optional
+}
+
+// Shorthands for the feed parameter of each kind of source.
+var sourceKinds = map[string]string{
+	"channel":  "channel_id",
+	"playlist": "playlist_id",
+	"user":     "user",
+}
+
+const youtubeFeedPath = "feeds/videos.xml"
+
+// Parses "channel:<id>", "playlist:<id>", "user:<name>" or a feed path.
+func ParseSource(spec string) (Source, error) {
+	if i := strings.Index(spec, ":"); i > 0 && !strings.Contains(spec[:i], "/") {
+		param, ok := sourceKinds[spec[:i]]
+		if !ok || spec[i+1:] == "" {
+			return Source{}, fmt.Errorf("bad source %q", spec)
+		}
+		return Source{Path: youtubeFeedPath, RawQuery: url.Values{param: {spec[i+1:]}}.Encode()}, nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil {
+		return Source{}, err
+	}
+	if u.IsAbs() || u.Path == "" || u.RawQuery == "" {
+		return Source{}, fmt.Errorf("bad source %q", spec)
+	}
+	return Source{Path: strings.TrimPrefix(u.Path, "/"), RawQuery: u.RawQuery}, nil
+}
+
+// Combines several feeds into one, newest first. Sources that fail are left
+// out unless all of them do.
+func GenMergedRSS(ctx context.Context, title string, sources []Source, opts Options) (string, error) {
+	feeds := make([]*feedI.Feed, len(sources))
+	errs := make([]error, len(sources))
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func(i int, src Source) {
+			defer wg.Done()
+			feeds[i], errs[i] = fetchSource(ctx, opts.Client, src, opts.Lookup)
+		}(i, src)
+	}
+	wg.Wait()
+
+	merged := &feedI.Feed{Title: title, Description: title}
+	seen := map[string]bool{}
+	var lastErr error
+	for i, feed := range feeds {
+		if errs[i] != nil {
+			logger.Printf("merge %s: source %s?%s: %s", title, sources[i].Path, sources[i].RawQuery, errs[i])
+			lastErr = errs[i]
+			continue
+		}
+		for _, item := range feed.Items {
+			id := videoID(item)
+			if id == "" {
+				id = item.GUID
+			}
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			merged.Items = append(merged.Items, item)
+		}
+	}
+	if lastErr != nil && len(merged.Items) == 0 {
+		return "", lastErr
+	}
+
+	sort.SliceStable(merged.Items, func(i, j int) bool {
+		a, b := merged.Items[i].PublishedParsed, merged.Items[j].PublishedParsed
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return a.After(*b)
+	})
+	merged.Items = opts.Filter.apply(ctx, opts.Client, merged.Items, opts.Lookup)
+	opts.Rewrite.apply(merged)
+	return buildRSS(merged, opts, youtubeEnclosure)
+}
@@ -0,0 +1,63 @@
+package rss
+
+import (
+	"encoding/xml"
+
+	feedO "github.com/gorilla/feeds"
+)
+
+// Podcasting 2.0 namespace, see https://podcastindex.org/namespace/1.0
+const podcastNS = "https://podcastindex.org/namespace/1.0"
+
+const chaptersType = "application/json+chapters"
+
+// Transcript formats offered for every transcript, by file extension.
+var transcriptTypes = []struct{ Ext, Type string }{
+	{"vtt", "text/vtt"},
+	{"srt", "application/srt"},
+	{"txt", "text/plain"},
+}
+
+// gorilla/feeds can't add namespaced elements, so they are wrapped in.
+type podcastRssXml struct {
+	XMLName   xml.Name `xml:"rss"`
+	Version   string   `xml:"version,attr"`
+	PodcastNS string   `xml:"xmlns:podcast,attr"`
+	Channel   *podcastChannel
+}
+
+type podcastChannel struct {
+	*feedO.RssFeed
+	Items []*podcastItem
+}
+
+type podcastItem struct {
+	*feedO.RssItem
+	Chapters    *podcastChapters
+	Transcripts []*podcastTranscript
+}
+
+type podcastChapters struct {
+	XMLName xml.Name `xml:"podcast:chapters"`
+	Url     string   `xml:"url,attr"`
+	Type    string   `xml:"type,attr"`
+}
+
+type podcastTranscript struct {
+	XMLName  xml.Name `xml:"podcast:transcript"`
+	Url      string   `xml:"url,attr"`
+	Type     string   `xml:"type,attr"`
+	Language string   `xml:"language,attr,omitempty"`
+}
+
+func newPodcastRss(feed *feedO.RssFeed) *podcastRssXml {
+	channel := &podcastChannel{RssFeed: feed}
+	for _, item := range feed.Items {
+		channel.Items = append(channel.Items, &podcastItem{RssItem: item})
+	}
+	return &podcastRssXml{Version: "2.0", PodcastNS: podcastNS, Channel: channel}
+}
+
+func (p *podcastRssXml) FeedXml() interface{} {
+	return p
+}
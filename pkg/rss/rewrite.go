@@ -0,0 +1,141 @@
+package rss
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	feedI "github.com/mmcdole/gofeed"
+)
+
+// Changes made to the items of a feed. The zero value changes nothing.
+type Rewrite struct {
+	Titles           []Replacement    // Applied to titles in order
+	TitlePrefix      string           // Prepended to titles
+	TitleSuffix      string           // Appended to titles
+	StripDescription []*regexp.Regexp // Removed from descriptions
+	StripTracking    bool             // Remove tracking parameters from links in descriptions
+	Author           string           // Replaces the feed and item authors
+}
+
+type Replacement struct {
+	Pattern *regexp.Regexp
+	With    string // May refer to groups as $1 or ${name}
+}
+
+// The configuration form of a Rewrite, with patterns as strings.
+type RewriteConfig struct {
+	Titles           []ReplacementConfig `json:"titles,omitempty"`
+	TitlePrefix      string              `json:"title_prefix,omitempty"`
+	TitleSuffix      string              `json:"title_suffix,omitempty"`
+	StripDescription []string            `json:"strip_description,omitempty"`
+	StripTracking    bool                `json:"strip_tracking,omitempty"`
+	Author           string              `json:"author,omitempty"`
+}
+
+type ReplacementConfig struct {
+	Pattern string `json:"pattern"`
+	With    string `json:"with"`
+}
+
+var (
+	linkRe = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+	// Query parameters that only serve to track who followed a link.
+	trackingParams = []string{"fbclid", "gclid", "igshid", "si", "feature", "mc_cid", "mc_eid"}
+)
+
+func (c RewriteConfig) Compile() (*Rewrite, error) {
+	r := &Rewrite{
+		TitlePrefix:   c.TitlePrefix,
+		TitleSuffix:   c.TitleSuffix,
+		StripTracking: c.StripTracking,
+		Author:        c.Author,
+	}
+	for _, t := range c.Titles {
+		re, err := regexp.Compile(t.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("title pattern: %w", err)
+		}
+		r.Titles = append(r.Titles, Replacement{Pattern: re, With: t.With})
+	}
+	for _, p := range c.StripDescription {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("description pattern: %w", err)
+		}
+		r.StripDescription = append(r.StripDescription, re)
+	}
+	return r, nil
+}
+
+// Rewrites the feed in place.
+func (r *Rewrite) apply(feed *feedI.Feed) {
+	if r == nil {
+		return
+	}
+	feed.Author = r.author(feed.Author)
+	for _, item := range feed.Items {
+		item.Title = r.title(item.Title)
+		item.Description = r.description(item.Description)
+		item.Author = r.author(item.Author)
+	}
+}
+
+func (r *Rewrite) title(s string) string {
+	for _, t := range r.Titles {
+		s = t.Pattern.ReplaceAllString(s, t.With)
+	}
+	return r.TitlePrefix + s + r.TitleSuffix
+}
+
+func (r *Rewrite) description(s string) string {
+	for _, re := range r.StripDescription {
+		s = re.ReplaceAllString(s, "")
+	}
+	if r.StripTracking {
+		s = linkRe.ReplaceAllStringFunc(s, stripTrackingParams)
+	}
+	return strings.TrimSpace(s)
+}
+
+func (r *Rewrite) author(p *feedI.Person) *feedI.Person {
+	if r.Author == "" {
+		return p
+	}
+	out := &feedI.Person{Name: r.Author}
+	if p != nil {
+		out.Email = p.Email
+	}
+	return out
+}
+
+func stripTrackingParams(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.RawQuery == "" {
+		return link
+	}
+	q := u.Query()
+	changed := false
+	for k := range q {
+		if strings.HasPrefix(k, "utm_") || contains(trackingParams, k) {
+			q.Del(k)
+			changed = true
+		}
+	}
+	if !changed {
+		return link
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -1,8 +1,10 @@
 package rss
 
 import (
+	"bytes"
 	"context"
-	"fmt"
+	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"path"
@@ -11,10 +13,40 @@ import (
 	feedI "github.com/mmcdole/gofeed"
 
 	"github.com/nlsun/rss-reflector/pkg/log"
+	"github.com/nlsun/rss-reflector/pkg/upstream"
 )
 
 var logger = log.DefaultLogger
 
+// Describes how the reflected feed links back to the reflector.
+type Options struct {
+	Base              url.URL    // Scheme, host and path prefix the links point to
+	ContentPrePath    string     // Path prefix of content links
+	ChaptersPrePath   string     // Path prefix of chapters links
+	TranscriptPrePath string     // Path prefix of transcript links
+	LinkQuery         url.Values // Added to the query of every link
+	AlbumParam        string     // Content link parameter carrying the feed title, empty to leave it out
+	URLParam          string     // Content link parameter carrying the media url of reflected feeds
+	SourceParam       string     // Content link parameter carrying the query of the YouTube feed an item came from, empty to leave it out
+	EnclosureType     string     // Type of the served media, empty for the upstream or default type
+	// Type a video is served as, over EnclosureType. Optional.
+	MediaType func(videoURL string) string
+	// Whether chapters are known for a video. Optional.
+	HasChapters func(videoURL, description string) bool
+	// Language of the stored transcript of a video. Optional.
+	TranscriptLang func(videoURL string) string
+	// Changes made to the feed's items. Optional.
+	Rewrite *Rewrite
+	// Decides which items are kept. Optional.
+	Filter *Filter
+	// Looks up what filters need to know about a video. Optional.
+	Lookup func(ctx context.Context, videoURL string) (*VideoDetails, error)
+	// Signs content, chapters and transcript links. Optional.
+	Sign func(u *url.URL) error
+	// Fetches upstream feeds. Optional.
+	Client *upstream.Client
+}
+
 //Your podcast doesn’t seem to contain any episodes. Try adding an episode with this format
 //<item>
 //  <title>Interesting episode title</title>
@@ -24,7 +56,34 @@ var logger = log.DefaultLogger
 //  <enclosure url="http://example.com/episode1.mp3" length="5860687" type="audio/mpeg" />
 //</item>
 
-func GenYoutubeRSS(ctx context.Context, qPath, qRawQuery, dstHost, prePath string) (string, error) {
+func GenYoutubeRSS(ctx context.Context, qPath, qRawQuery string, opts Options) (string, error) {
+	src := Source{Path: qPath, RawQuery: qRawQuery, Rewrite: opts.Rewrite, Filter: opts.Filter}
+	inFeed, err := fetchSource(ctx, opts.Client, src, opts.Lookup)
+	if err != nil {
+		return "", err
+	}
+	return buildRSS(inFeed, opts, youtubeEnclosure)
+}
+
+// Fetches the upstream feed with the source's filter and rewrite applied.
+func fetchSource(ctx context.Context, client *upstream.Client, src Source, lookup func(context.Context, string) (*VideoDetails, error)) (*feedI.Feed, error) {
+	feed, err := fetchYoutubeFeed(ctx, client, src.Path, src.RawQuery)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range feed.Items {
+		item.Description = mediaDescription(item)
+		if item.Custom == nil {
+			item.Custom = map[string]string{}
+		}
+		item.Custom[sourceKey] = src.RawQuery
+	}
+	feed.Items = src.Filter.apply(ctx, client, feed.Items, lookup)
+	src.Rewrite.apply(feed)
+	return feed, nil
+}
+
+func fetchYoutubeFeed(ctx context.Context, client *upstream.Client, qPath, qRawQuery string) (*feedI.Feed, error) {
 	logger.Printf("parsing: %s %s", qPath, qRawQuery)
 
 	qUrl := url.URL{
@@ -33,15 +92,42 @@ func GenYoutubeRSS(ctx context.Context, qPath, qRawQuery, dstHost, prePath strin
 		Path:     qPath,
 		RawQuery: qRawQuery,
 	}
-	logger.Print("query uri: ", qUrl.String())
+	return fetchFeed(ctx, client, qUrl.String())
+}
 
-	req, err := http.NewRequest(http.MethodGet, qUrl.String(), nil)
+// Fetches the feed conditionally, falling back to the last response while
+// upstream is failing.
+func fetchFeed(ctx context.Context, client *upstream.Client, feedURL string) (*feedI.Feed, error) {
+	feed, err := fetchFeedHelper(ctx, client, feedURL)
+	if err != nil && transient(ctx, err) {
+		if cached, ok := upstreamFeeds.get(upstreamKey(ctx, feedURL)); ok {
+			logger.Printf("%s failed, using the last good response: %s", feedURL, err)
+			return feedI.NewParser().Parse(cached.reader())
+		}
+	}
+	return feed, err
+}
+
+func fetchFeedHelper(ctx context.Context, client *upstream.Client, feedURL string) (*feedI.Feed, error) {
+	logger.Print("query uri: ", feedURL)
+
+	req, err := http.NewRequest(http.MethodGet, feedURL, nil)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	key := upstreamKey(ctx, feedURL)
+	cached, haveCached := upstreamFeeds.get(key)
+	if haveCached {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
 	}
-	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
@@ -49,20 +135,94 @@ func GenYoutubeRSS(ctx context.Context, qPath, qRawQuery, dstHost, prePath strin
 		}
 	}()
 
+	if resp.StatusCode == http.StatusNotModified && haveCached {
+		logger.Print("not modified: ", feedURL)
+		return feedI.NewParser().Parse(cached.reader())
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return "", fmt.Errorf("resp status %s", resp.Status)
+		return nil, &statusError{code: resp.StatusCode, status: resp.Status}
 	}
 
-	inFeed, err := feedI.NewParser().Parse(resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	feed, err := feedI.NewParser().Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	upstreamFeeds.put(key, upstreamFeed{
+		body:         body,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	})
+	return feed, nil
+}
+
+// Reported when upstream answers with an error status.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "resp status " + e.status
+}
+
+// Whether upstream failed on its side rather than the caller giving up.
+func transient(ctx context.Context, err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code >= 500 || se.code == http.StatusTooManyRequests
+	}
+	return ctx.Err() == nil
+}
+
+// Where an item's media is served from and what it is.
+type enclosure struct {
+	url    string
+	typ    string
+	length string // Size in bytes, empty if unknown
+}
+
+// Custom item field holding the query of the YouTube feed it came from.
+const sourceKey = "reflector:source"
+
+// Finds the enclosure of an item, false if it has none.
+type enclosureFunc func(item *feedI.Item, opts Options, contentQuery url.Values) (enclosure, bool, error)
+
+func youtubeEnclosure(item *feedI.Item, opts Options, contentQuery url.Values) (enclosure, bool, error) {
+	if src := item.Custom[sourceKey]; opts.SourceParam != "" && src != "" {
+		q := url.Values{}
+		for k, v := range contentQuery {
+			q[k] = v
+		}
+		q.Set(opts.SourceParam, src)
+		contentQuery = q
+	}
+	ytLink, err := parseYoutubeLink(item.Link, opts.Base, opts.ContentPrePath, contentQuery, opts.Sign)
+	if err != nil {
+		return enclosure{}, false, err
+	}
+	typ := opts.EnclosureType
+	if opts.MediaType != nil {
+		if t := opts.MediaType(item.Link); t != "" {
+			typ = t
+		}
 	}
+	if typ == "" {
+		typ = "audio/mpeg"
+	}
+	return enclosure{url: ytLink, typ: typ}, true, nil
+}
 
+// Builds the reflected feed. Items without an enclosure are left out.
+func buildRSS(inFeed *feedI.Feed, opts Options, enclosureFor enclosureFunc) (string, error) {
 	outFeed := &feedO.Feed{
-		Title:       revStr(inFeed.Title),
+		Title:       inFeed.Title,
 		Link:        &feedO.Link{Href: inFeed.Link},
-		Description: revStr(inFeed.Description),
-		Author:      &feedO.Author{Name: revStr(inFeed.Author.Name), Email: revStr(inFeed.Author.Email)},
+		Description: inFeed.Description,
+		Author:      feedAuthor(inFeed.Author),
 	}
 	if inFeed.UpdatedParsed != nil {
 		outFeed.Updated = *inFeed.UpdatedParsed
@@ -71,18 +231,37 @@ func GenYoutubeRSS(ctx context.Context, qPath, qRawQuery, dstHost, prePath strin
 		outFeed.Created = *inFeed.PublishedParsed
 	}
 
+	// The feed title goes along so the file can be tagged with it.
+	contentQuery := url.Values{}
+	for k, v := range opts.LinkQuery {
+		contentQuery[k] = v
+	}
+	if opts.AlbumParam != "" && inFeed.Title != "" {
+		contentQuery.Set(opts.AlbumParam, inFeed.Title)
+	}
+
+	var items []*feedI.Item
+	var enclosures []enclosure
 	for _, item := range inFeed.Items {
-		ytLink, err := parseYoutubeLink(item.Link, dstHost, prePath)
+		enc, ok, err := enclosureFor(item, opts, contentQuery)
 		if err != nil {
 			return "", err
+		} else if !ok {
+			continue
 		}
+		items = append(items, item)
+		enclosures = append(enclosures, enc)
+	}
+	inFeed.Items = items
+
+	for i, item := range inFeed.Items {
 		o := &feedO.Item{
-			Title: revStr(item.Title),
+			Title: item.Title,
 			// This Link is not used in the final XML, it's just used to
 			// pass information to the next parsing stage.
-			Link:        &feedO.Link{Href: ytLink},
-			Description: revStr(item.Description),
-			Author:      &feedO.Author{Name: revStr(item.Author.Name), Email: revStr(item.Author.Email)},
+			Link:        &feedO.Link{Href: enclosures[i].url},
+			Description: item.Description,
+			Author:      feedAuthor(item.Author),
 			Id:          item.GUID,
 		}
 		if item.UpdatedParsed != nil {
@@ -97,55 +276,124 @@ func GenYoutubeRSS(ctx context.Context, qPath, qRawQuery, dstHost, prePath strin
 
 	// The reason this dance is necessary is because gorilla/feeds has a bug
 	// where it does not internally convert the Link to an Enclosure.
-	rssThing := &feedO.Rss{outFeed}
+	rssThing := &feedO.Rss{Feed: outFeed}
 	finalRssFeed := rssThing.RssFeed()
 	for i := range finalRssFeed.Items {
 		finalRssFeed.Items[i].Enclosure = &feedO.RssEnclosure{
-			// A possible issue is that we leave the `Length` blank.
-			// We do this because we don't actually know anything about the
-			// contents of the link.
+			// A possible issue is that we usually leave the `Length`
+			// blank. We do this because we don't actually know anything
+			// about the contents of the link.
 			// It seems, however, that rss feed readers are generally ok with
 			// this.
-			Url: finalRssFeed.Items[i].Link,
-			// XXX Type is current hardcoded, we should be able to configure
-			// these through special query parameters in the url, since
-			// we construct these urls.
-			Type: "audio/mpeg",
+			Url:    finalRssFeed.Items[i].Link,
+			Type:   enclosures[i].typ,
+			Length: enclosures[i].length,
 		}
 		// Clear out the unused Link
 		finalRssFeed.Items[i].Link = ""
 	}
 
-	return feedO.ToXML(finalRssFeed)
+	podcastFeed := newPodcastRss(finalRssFeed)
+	for i, item := range inFeed.Items {
+		if opts.HasChapters == nil || !opts.HasChapters(item.Link, item.Description) {
+			continue
+		}
+		chaptersLink, err := parseYoutubeLink(item.Link, opts.Base, opts.ChaptersPrePath, opts.LinkQuery, opts.Sign)
+		if err != nil {
+			return "", err
+		}
+		podcastFeed.Channel.Items[i].Chapters = &podcastChapters{Url: chaptersLink, Type: chaptersType}
+	}
+	for i, item := range inFeed.Items {
+		if opts.TranscriptLang == nil {
+			break
+		}
+		lang := opts.TranscriptLang(item.Link)
+		if lang == "" {
+			continue
+		}
+		id := videoID(item)
+		if id == "" {
+			continue
+		}
+		for _, t := range transcriptTypes {
+			link, err := reflectorLink(opts.Base, path.Join(opts.TranscriptPrePath, id+"."+t.Ext), opts.LinkQuery, opts.Sign)
+			if err != nil {
+				return "", err
+			}
+			podcastFeed.Channel.Items[i].Transcripts = append(podcastFeed.Channel.Items[i].Transcripts,
+				&podcastTranscript{Url: link, Type: t.Type, Language: lang})
+		}
+	}
+
+	return feedO.ToXML(podcastFeed)
 }
 
-func parseYoutubeLink(link, host, prePath string) (string, error) {
-	u, err := url.Parse(link)
-	if err != nil {
-		return "", err
+func feedAuthor(author *feedI.Person) *feedO.Author {
+	if author == nil {
+		return &feedO.Author{}
 	}
-	u.Scheme = "http"
-	u.Host = host
-	u.Path = path.Join(prePath, u.Path)
-	return u.String(), nil
+	return &feedO.Author{Name: author.Name, Email: author.Email}
+}
+
+// YouTube puts the video description in <media:group>, not in the entry.
+func mediaDescription(item *feedI.Item) string {
+	for _, group := range item.Extensions["media"]["group"] {
+		for _, desc := range group.Children["description"] {
+			return desc.Value
+		}
+	}
+	return item.Description
+}
+
+// YouTube feeds carry the id in <yt:videoId>, the watch link has it too.
+func videoID(item *feedI.Item) string {
+	for _, ext := range item.Extensions["yt"]["videoId"] {
+		if ext.Value != "" {
+			return ext.Value
+		}
+	}
+	if u, err := url.Parse(item.Link); err == nil {
+		return u.Query().Get("v")
+	}
+	return ""
 }
 
-func revStr(input string) string {
-	// https://stackoverflow.com/questions/1752414/how-to-reverse-a-string-in-go/1754209#1754209
-	// https://groups.google.com/forum/#!topic/golang-nuts/oPuBaYJ17t4
+// Links back to the reflector at p under the base url, signed before the
+// base is applied.
+func reflectorLink(base url.URL, p string, linkQuery url.Values, sign func(*url.URL) error) (string, error) {
+	u := &url.URL{Path: p}
+	if len(linkQuery) > 0 {
+		u.RawQuery = linkQuery.Encode()
+	}
+	return withBase(base, u, sign)
+}
 
-	// Get Unicode code points.
-	n := 0
-	rune := make([]rune, len(input))
-	for _, r := range input {
-		rune[n] = r
-		n++
+// Turns a YouTube link into one to the same path under prePath.
+func parseYoutubeLink(link string, base url.URL, prePath string, linkQuery url.Values, sign func(*url.URL) error) (string, error) {
+	yt, err := url.Parse(link)
+	if err != nil {
+		return "", err
 	}
-	rune = rune[0:n]
-	// Reverse
-	for i := 0; i < n/2; i++ {
-		rune[i], rune[n-1-i] = rune[n-1-i], rune[i]
+	u := &url.URL{Path: path.Join(prePath, yt.Path), RawQuery: yt.RawQuery}
+	if len(linkQuery) > 0 {
+		q := yt.Query()
+		for k, v := range linkQuery {
+			q[k] = v
+		}
+		u.RawQuery = q.Encode()
 	}
-	// Convert back to UTF-8.
-	return string(rune)
+	return withBase(base, u, sign)
+}
+
+func withBase(base url.URL, u *url.URL, sign func(*url.URL) error) (string, error) {
+	if sign != nil {
+		if err := sign(u); err != nil {
+			return "", err
+		}
+	}
+	u.Scheme = base.Scheme
+	u.Host = base.Host
+	u.Path = path.Join("/", base.Path, u.Path)
+	return u.String(), nil
 }
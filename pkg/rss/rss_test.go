@@ -0,0 +1,75 @@
+package rss
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	feedI "github.com/mmcdole/gofeed"
+
+	"github.com/nlsun/rss-reflector/pkg/upstream"
+)
+
+func TestYoutubeEnclosureSource(t *testing.T) {
+	item := &feedI.Item{
+		Link:   "https://www.youtube.com/watch?v=abcdefghijk",
+		Custom: map[string]string{sourceKey: "playlist_id=PL1"},
+	}
+	opts := Options{
+		Base:           url.URL{Scheme: "https", Host: "pods.example"},
+		ContentPrePath: "/content/youtube",
+	}
+	contentQuery := url.Values{"album": {"Show"}}
+
+	enc, ok, err := youtubeEnclosure(item, opts, contentQuery)
+	if err != nil || !ok {
+		t.Fatal(ok, err)
+	}
+	if want := "https://pods.example/content/youtube/watch?album=Show&v=abcdefghijk"; enc.url != want {
+		t.Errorf("without a source param got %s, want %s", enc.url, want)
+	}
+
+	opts.SourceParam = "from"
+	if enc, _, _ = youtubeEnclosure(item, opts, contentQuery); enc.url != "https://pods.example/content/youtube/watch?album=Show&from=playlist_id%3DPL1&v=abcdefghijk" {
+		t.Errorf("got %s", enc.url)
+	}
+	if len(contentQuery) != 1 {
+		t.Errorf("content query of the other items changed to %v", contentQuery)
+	}
+}
+
+func TestFetchFeedFallsBackOnlyWhileFailing(t *testing.T) {
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte(`<rss version="2.0"><channel><title>Show</title></channel></rss>`))
+		}
+	}))
+	defer srv.Close()
+	client, err := upstream.New(upstream.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := fetchFeed(ctx, client, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	status = http.StatusBadGateway
+	if feed, err := fetchFeed(ctx, client, srv.URL); err != nil || feed.Title != "Show" {
+		t.Errorf("while failing got %v, %v, want the last good response", feed, err)
+	}
+	status = http.StatusGone
+	if _, err := fetchFeed(ctx, client, srv.URL); err == nil {
+		t.Error("gone feed served from the last good response")
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	status = http.StatusOK
+	if _, err := fetchFeed(cancelled, client, srv.URL); err == nil {
+		t.Error("cancelled fetch served from the last good response")
+	}
+}
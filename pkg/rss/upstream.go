@@ -0,0 +1,54 @@
+package rss
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"github.com/nlsun/rss-reflector/pkg/cookies"
+)
+
+// Upstream feeds to remember before starting over.
+const maxCachedUpstream = 1000
+
+// The last good response of each upstream feed.
+type upstreamCache struct {
+	mu    sync.Mutex
+	feeds map[string]upstreamFeed
+}
+
+type upstreamFeed struct {
+	body         []byte
+	etag         string
+	lastModified string
+}
+
+var upstreamFeeds = &upstreamCache{feeds: map[string]upstreamFeed{}}
+
+func (c *upstreamCache) get(feedURL string) (upstreamFeed, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.feeds[feedURL]
+	return f, ok
+}
+
+func (c *upstreamCache) put(feedURL string, f upstreamFeed) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.feeds) >= maxCachedUpstream {
+		c.feeds = map[string]upstreamFeed{}
+	}
+	c.feeds[feedURL] = f
+}
+
+// Feeds fetched with cookies are remembered apart.
+func upstreamKey(ctx context.Context, feedURL string) string {
+	if jar := cookies.FromContext(ctx); jar != nil {
+		return feedURL + "#c-" + jar.Name
+	}
+	return feedURL
+}
+
+func (f upstreamFeed) reader() *bytes.Reader {
+	return bytes.NewReader(f.body)
+}
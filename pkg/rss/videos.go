@@ -0,0 +1,14 @@
+package rss
+
+import (
+	"context"
+
+	feedI "github.com/mmcdole/gofeed"
+)
+
+// Builds a feed of YouTube videos at hand, such as a queue.
+func GenVideosRSS(ctx context.Context, feed *feedI.Feed, opts Options) (string, error) {
+	feed.Items = opts.Filter.apply(ctx, opts.Client, feed.Items, opts.Lookup)
+	opts.Rewrite.apply(feed)
+	return buildRSS(feed, opts, youtubeEnclosure)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/nlsun/rss-reflector/pkg/access"
+	"github.com/nlsun/rss-reflector/pkg/content"
+)
+
+var errForbidden = errors.New("forbidden")
+
+// Carries the query of the YouTube feed a content link came from, so rules
+// allowing the feed allow its items. Such links are always signed.
+const fromParam string = "from"
+
+// Channel lookups to remember before starting over.
+const maxCachedChannels = 10000
+
+// Video id to channel id. Videos don't change channels.
+type channelCache struct {
+	mu  sync.Mutex
+	ids map[string]string
+}
+
+func newChannelCache() *channelCache {
+	return &channelCache{ids: map[string]string{}}
+}
+
+func (c *channelCache) get(videoID string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, ok := c.ids[videoID]
+	return id, ok
+}
+
+func (c *channelCache) put(videoID, channelID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.ids) >= maxCachedChannels {
+		c.ids = map[string]string{}
+	}
+	c.ids[videoID] = channelID
+}
+
+// Writes why the request was refused.
+func (s *State) handleForbidden(w http.ResponseWriter, r *http.Request, err error) {
+	logger.Print(err)
+	w.WriteHeader(http.StatusForbidden)
+	fmt.Fprint(w, "403 rss-reflector ", err)
+}
+
+func (s *State) checkAccess(sub access.Subject) error {
+	if err := s.settings().access.Check(sub); err != nil {
+		return fmt.Errorf("%w: %s", errForbidden, err)
+	}
+	return nil
+}
+
+// Checks a YouTube feed by the ids in its upstream query.
+func (s *State) checkYoutubeFeed(feedPath, upstreamQuery string) error {
+	q, _ := url.ParseQuery(upstreamQuery)
+	u := url.URL{Scheme: "https", Host: "www.youtube.com", Path: "/" + feedPath, RawQuery: upstreamQuery}
+	return s.checkAccess(access.Subject{
+		ChannelID:  q.Get("channel_id"),
+		PlaylistID: q.Get("playlist_id"),
+		User:       q.Get("user"),
+		URL:        u.String(),
+	})
+}
+
+// YouTube videos are checked by the feed of their signed link and by their
+// channel.
+func (s *State) checkContent(ctx context.Context, req content.TaskRequest, from string) error {
+	sub := access.Subject{URL: req.Uri}
+	if req.Src == content.YoutubeSource {
+		fq, _ := url.ParseQuery(from)
+		sub.ChannelID, sub.PlaylistID, sub.User = fq.Get("channel_id"), fq.Get("playlist_id"), fq.Get("user")
+		if sub.ChannelID == "" && s.settings().access.NeedsChannel(sub) {
+			channelID, err := s.channelOf(ctx, req)
+			if err != nil {
+				return fmt.Errorf("%w: can't tell the channel of %s: %s", errForbidden, req.Uri, err)
+			}
+			sub.ChannelID = channelID
+		}
+	}
+	return s.checkAccess(sub)
+}
+
+// Looked up once for each video, or read from the cached download.
+func (s *State) channelOf(ctx context.Context, req content.TaskRequest) (string, error) {
+	videoID, err := content.YoutubeVideoID(req.Uri)
+	if err != nil {
+		return "", err
+	}
+	if id, ok := s.channels.get(videoID); ok {
+		return id, nil
+	}
+	if meta, err := s.fetcher.ItemMeta(req); err != nil {
+		logger.Print(err)
+	} else if meta != nil && meta.Info != nil && meta.Info.ChannelID != "" {
+		s.channels.put(videoID, meta.Info.ChannelID)
+		return meta.Info.ChannelID, nil
+	}
+	info, err := s.fetcher.Info(ctx, youtubeWatchURL(videoID))
+	if err != nil {
+		return "", err
+	}
+	if info.ChannelID == "" {
+		return "", fmt.Errorf("no channel in video info")
+	}
+	s.channels.put(videoID, info.ChannelID)
+	return info.ChannelID, nil
+}
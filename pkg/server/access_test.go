@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nlsun/rss-reflector/pkg/access"
+	"github.com/nlsun/rss-reflector/pkg/content"
+)
+
+func TestCheckContentFrom(t *testing.T) {
+	rules, err := access.Config{Allow: []string{"playlist:PL1", "user:someone", "channel:UC1"}}.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &State{set: &settings{access: rules}, channels: newChannelCache()}
+	// Known already, so the checks never look it up.
+	s.channels.put("abcdefghijk", "UC2")
+	req := content.TaskRequest{Src: content.YoutubeSource, Uri: "https://www.youtube.com/watch?v=abcdefghijk"}
+
+	tests := []struct {
+		name string
+		uri  string
+		from string
+		want error
+	}{
+		{name: "item of an allowed playlist", from: "playlist_id=PL1"},
+		{name: "item of an allowed user", from: "user=someone"},
+		{name: "item of an allowed channel", from: "channel_id=UC1"},
+		{name: "item of another playlist", from: "playlist_id=PL2", want: errForbidden},
+		{name: "no feed", want: errForbidden},
+		{name: "playlist in the url", uri: "https://www.youtube.com/watch?v=abcdefghijk&list=PL1", want: errForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := req
+			if tt.uri != "" {
+				req.Uri = tt.uri
+			}
+			if err := s.checkContent(context.Background(), req, tt.from); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// Podcast apps can't set headers.
+	tokenPrefix string = "/t/"
+	tokenParam  string = "token"
+
+	authRealm string = `Basic realm="rss-reflector"`
+)
+
+// Takes the token from the path, the query or HTTP Basic auth, and puts it
+// in the query so served links carry it.
+func (s *State) authorize(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.tokens == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		secret, fromQuery := "", false
+		if strings.HasPrefix(r.URL.Path, tokenPrefix) {
+			rest := strings.TrimPrefix(r.URL.Path, tokenPrefix)
+			i := strings.Index(rest, "/")
+			if i < 0 {
+				s.handleError(w, r, http.StatusNotFound)
+				return
+			}
+			secret, r.URL.Path = rest[:i], rest[i:]
+		} else if t := r.URL.Query().Get(tokenParam); t != "" {
+			secret, fromQuery = t, true
+		} else if _, password, ok := r.BasicAuth(); ok {
+			secret = password
+		}
+
+		name, ok := s.tokens.Check(secret)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", authRealm)
+			s.handleError(w, r, http.StatusUnauthorized)
+			return
+		}
+		logger.Printf("request %s by token %s", r.URL.Path, name)
+		if !fromQuery {
+			r.URL.RawQuery = withToken(r.URL.RawQuery, secret)
+		}
+		h.ServeHTTP(w, r.WithContext(withTokenName(r.Context(), name)))
+	})
+}
+
+type tokenNameKey struct{}
+
+// Name of the token the request was authorized by, empty when auth is off.
+func tokenName(r *http.Request) string {
+	return ctxTokenName(r.Context())
+}
+
+// For work done on behalf of a request under another context.
+func withTokenName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, tokenNameKey{}, name)
+}
+
+func ctxTokenName(ctx context.Context) string {
+	name, _ := ctx.Value(tokenNameKey{}).(string)
+	return name
+}
+
+func withToken(rawQuery, secret string) string {
+	param := tokenParam + "=" + url.QueryEscape(secret)
+	if rawQuery == "" {
+		return param
+	}
+	return rawQuery + "&" + param
+}
+
+// Query string that gets links on served pages past auth.
+func tokenQuery(r *http.Request) string {
+	if t := r.URL.Query().Get(tokenParam); t != "" {
+		return "?" + tokenParam + "=" + url.QueryEscape(t)
+	}
+	return ""
+}
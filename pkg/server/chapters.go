@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nlsun/rss-reflector/pkg/content"
+)
+
+// Podcasting 2.0 JSON chapters, see
+// https://github.com/Podcastindex-org/podcast-namespace/blob/main/chapters/jsonChapters.md
+type jsonChapters struct {
+	Version  string        `json:"version"`
+	Chapters []jsonChapter `json:"chapters"`
+}
+
+type jsonChapter struct {
+	StartTime float64 `json:"startTime"`
+	EndTime   float64 `json:"endTime,omitempty"`
+	Title     string  `json:"title"`
+}
+
+const jsonChaptersVersion = "1.2.0"
+
+func (s *State) handleChapters(w http.ResponseWriter, r *http.Request) {
+	qPath := strings.TrimPrefix(r.URL.Path, chaptersPathSlash)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleEvents(ctx, cancel, w.(http.CloseNotifier).CloseNotify(), "handleChapters")
+
+	req, ok := s.contentRequest(qPath, r.URL.RawQuery)
+	if !ok {
+		s.handleError(w, r, http.StatusNotFound)
+		return
+	}
+	if err := s.verify(chaptersPath, req, r.URL.Query()); err != nil {
+		logger.Printf("chapters %s: %s", req.Uri, err)
+		s.handleError(w, r, http.StatusForbidden)
+		return
+	}
+	if err := s.checkCookies(r, req, true); err != nil {
+		s.handleForbidden(w, r, err)
+		return
+	}
+	chapters, err := s.fetcher.Chapters(ctx, req)
+	if err != nil {
+		logger.Print(err)
+		s.handleError(w, r, http.StatusInternalServerError)
+		return
+	}
+	if len(chapters) == 0 {
+		s.handleError(w, r, http.StatusNotFound)
+		return
+	}
+
+	resp := jsonChapters{Version: jsonChaptersVersion, Chapters: []jsonChapter{}}
+	for _, c := range chapters {
+		resp.Chapters = append(resp.Chapters, jsonChapter{StartTime: c.Start, EndTime: c.End, Title: c.Title})
+	}
+	w.Header().Set("Content-Type", "application/json+chapters")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Print(err)
+	}
+}
+
+// Only chapters already known are advertised, feeds never cause lookups.
+func (s *State) hasChapters(linkQuery url.Values) func(videoURL, description string) bool {
+	return func(videoURL, description string) bool {
+		if len(content.ParseDescriptionChapters(description, 0)) > 0 {
+			return true
+		}
+		req, ok := s.youtubeRequest(videoURL, linkQuery)
+		if !ok {
+			return false
+		}
+		meta, err := s.fetcher.ItemMeta(req)
+		if err != nil {
+			logger.Print(err)
+			return false
+		}
+		return meta != nil && len(meta.Chapters) > 0
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/nlsun/rss-reflector/pkg/content"
+)
+
+// Where the fetcher keeps its cache in a data directory.
+func FetcherPath(dataDir string) string {
+	return filepath.Join(dataDir, "fetcher")
+}
+
+// Sets up what Fetch needs. It locks the cache like a server does.
+func NewFetchState(cfg Config) (*State, error) {
+	s, err := newState(cfg, true)
+	if err != nil {
+		return nil, err
+	}
+	// Links may name a jar to fetch with.
+	if err := s.setUpAuth(cfg); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Sets up what Feed needs. It only reads the cache.
+func NewFeedState(cfg Config) (*State, error) {
+	s, err := newState(cfg, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.setUpFeeds(cfg, 0); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Sets up what a server would and reports on the downloaders.
+func Check(cfg Config) ([]content.DownloaderStatus, error) {
+	s, err := newState(cfg, false)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	if err := s.setUpFeeds(cfg, 0); err != nil {
+		return nil, err
+	}
+	if err := s.setUpAuth(cfg); err != nil {
+		return nil, err
+	}
+	return s.Downloaders(), nil
+}
+
+// Stops the fetcher and the local library. For servers that are never run.
+func (s *State) Close() {
+	s.cancel()
+	s.fetcher.Close()
+	if s.local != nil {
+		s.local.Close()
+	}
+}
+
+// The configured downloaders and whether they can be used.
+func (s *State) Downloaders() []content.DownloaderStatus {
+	return s.fetcher.Downloaders()
+}
+
+// Downloads target, a video url or reflector content link, into the cache
+// and returns the cached file.
+func (s *State) Fetch(ctx context.Context, target string) (string, error) {
+	req, err := s.fetchRequest(target)
+	if err != nil {
+		return "", err
+	}
+	if err := s.checkContent(ctx, req, ""); err != nil {
+		return "", err
+	}
+	path, err := s.fetcher.SubmitTask(ctx, req)
+	s.fetcher.FinishTask()
+	return path, err
+}
+
+func (s *State) fetchRequest(target string) (content.TaskRequest, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return content.TaskRequest{}, fmt.Errorf("%w: %s", errBadRequest, err)
+	}
+	var req content.TaskRequest
+	ok := false
+	switch {
+	case strings.HasPrefix(u.Path, contentPathSlash) && u.Host == "":
+		req, ok = s.contentRequest(strings.TrimPrefix(u.Path, contentPathSlash), u.RawQuery)
+	case youtubeHosts[u.Host]:
+		id, err := content.YoutubeVideoID(target)
+		if err != nil {
+			return content.TaskRequest{}, fmt.Errorf("%w: %s", errBadRequest, err)
+		}
+		_, own := splitQuery(u.RawQuery)
+		req, ok = s.youtubeRequest(youtubeWatchURL(id), own)
+	case isHTTPURL(target):
+		req, ok = s.feedRequest(url.Values{urlParam: {target}}.Encode())
+	}
+	if !ok {
+		return content.TaskRequest{}, fmt.Errorf("%w: cannot fetch %q", errBadRequest, target)
+	}
+	return req, nil
+}
+
+// Generates the feed for target, a feed url or reflector path, as it would
+// be served.
+func (s *State) Feed(ctx context.Context, target string) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", errBadRequest, err)
+	}
+	switch {
+	case strings.HasPrefix(u.Path, rssPathSlash) && u.Host == "":
+		return s.generateRSS(ctx, s.localBaseURL(), strings.TrimPrefix(u.Path, rssPathSlash), u.RawQuery)
+	case youtubeHosts[u.Host]:
+		return s.generateRSS(ctx, s.localBaseURL(), ytPrefix+strings.TrimPrefix(u.Path, "/"), u.RawQuery)
+	}
+	return s.generateRSS(ctx, s.localBaseURL(), feedName, url.Values{urlParam: {target}}.Encode())
+}
+
+func (s *State) localBaseURL() url.URL {
+	if u := s.settings().publicURL; u != nil {
+		return *u
+	}
+	host, port, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		return url.URL{Scheme: "http", Host: s.addr}
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	return url.URL{Scheme: "http", Host: net.JoinHostPort(host, port)}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+
+	"github.com/nlsun/rss-reflector/pkg/content"
+	"github.com/nlsun/rss-reflector/pkg/cookies"
+)
+
+// Names the cookie jar a content link was fetched with.
+const cookiesParam string = "cookies"
+
+// Where cookie jars are kept in a data directory.
+func CookiesPath(dataDir string) string {
+	return filepath.Join(dataDir, "cookies")
+}
+
+// Picks the jar the feed is fetched with: the feed's own, else the token's.
+func (s *State) feedCookies(ctx context.Context, feed FeedConfig, linkQuery url.Values) (context.Context, error) {
+	linkQuery.Del(cookiesParam)
+	token := ctxTokenName(ctx)
+	if s.cookies == nil || token == "" {
+		return ctx, nil
+	}
+	name := feed.Cookies
+	if name == "" {
+		if !cookies.ValidName(token) {
+			return ctx, nil
+		}
+		name = token
+	}
+	jar, err := s.cookies.Jar(name)
+	if err != nil {
+		return nil, err
+	} else if jar == nil {
+		if feed.Cookies != "" {
+			logger.Printf("no cookies %s, fetching without", name)
+		}
+		return ctx, nil
+	}
+	linkQuery.Set(cookiesParam, jar.Name)
+	return cookies.WithJar(ctx, jar), nil
+}
+
+// The jar a link names, nil if it names none.
+func (s *State) linkCookies(own url.Values) (*cookies.Jar, bool) {
+	name := own.Get(cookiesParam)
+	if name == "" {
+		return nil, true
+	}
+	if s.cookies == nil {
+		return nil, false
+	}
+	jar, err := s.cookies.Jar(name)
+	if err != nil {
+		logger.Print(err)
+	}
+	return jar, jar != nil
+}
+
+// Content fetched with a jar is only served to tokens that may use it.
+// Feed jars are only used for signed links.
+func (s *State) checkCookies(r *http.Request, req content.TaskRequest, signed bool) error {
+	if req.Cookies == nil {
+		return nil
+	}
+	name, token := req.Cookies.Name, tokenName(r)
+	if token != "" && (name == token || signed && s.settings().feedJars[name]) {
+		return nil
+	}
+	return fmt.Errorf("%w: cookies %s not allowed", errForbidden, name)
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nlsun/rss-reflector/pkg/content"
+	"github.com/nlsun/rss-reflector/pkg/cookies"
+)
+
+func TestCheckCookies(t *testing.T) {
+	s := &State{set: &settings{feedJars: map[string]bool{"shared": true}}}
+	tests := []struct {
+		name   string
+		token  string
+		jar    string
+		signed bool
+		want   error
+	}{
+		{name: "no cookies"},
+		{name: "own jar", token: "alice", jar: "alice", signed: true},
+		{name: "own jar unsigned", token: "alice", jar: "alice"},
+		{name: "another token's jar", token: "alice", jar: "bob", signed: true, want: errForbidden},
+		{name: "feed jar from a feed", token: "alice", jar: "shared", signed: true},
+		{name: "feed jar for any url", token: "alice", jar: "shared", want: errForbidden},
+		{name: "no token", jar: "shared", signed: true, want: errForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/content/youtube/watch?v=abcdefghijk", nil)
+			r = r.WithContext(withTokenName(r.Context(), tt.token))
+			req := content.TaskRequest{Src: content.YoutubeSource, Uri: "https://www.youtube.com/watch?v=abcdefghijk"}
+			if tt.jar != "" {
+				req.Cookies = &cookies.Jar{Name: tt.jar}
+			}
+			if err := s.checkCookies(r, req, tt.signed); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+
+	"github.com/nlsun/rss-reflector/pkg/access"
+	"github.com/nlsun/rss-reflector/pkg/content"
+	"github.com/nlsun/rss-reflector/pkg/rss"
+)
+
+// Feeds are reflected at /rss/feed?url=, their media at /content/feed?url=.
+const feedName string = "feed"
+
+func (s *State) reflectedRSS(ctx context.Context, base url.URL, rawQuery string) (string, error) {
+	_, own := splitQuery(rawQuery)
+	feedQuery, linkQuery := splitFeedParams(own)
+	feedURL := feedQuery.Get(urlParam)
+	if !isHTTPURL(feedURL) {
+		return "", fmt.Errorf("%w: bad feed url %q", errBadRequest, feedURL)
+	}
+	if err := s.checkAccess(access.Subject{URL: feedURL}); err != nil {
+		return "", err
+	}
+
+	feeds := s.settings().feeds
+	feed, ok := feeds[feedURL]
+	if !ok {
+		feed = feeds["*"]
+	}
+	filter, err := feed.filter(feedQuery)
+	if err != nil {
+		return "", err
+	}
+	if ctx, err = s.feedCookies(ctx, feed, linkQuery); err != nil {
+		return "", err
+	}
+	opts := rss.Options{
+		Base:           base,
+		ContentPrePath: path.Join(contentPath, feedName),
+		LinkQuery:      linkQuery,
+		AlbumParam:     albumParam,
+		URLParam:       urlParam,
+		EnclosureType:  s.fetcher.ProfileMimeType(linkQuery.Get(profileParam)),
+		Rewrite:        feed.Rewrite,
+		Filter:         filter,
+		Client:         s.urlClient,
+		Sign:           s.signLink,
+	}
+	return rss.GenFeedRSS(ctx, feedURL, opts)
+}
+
+func (s *State) feedRequest(rawQuery string) (content.TaskRequest, bool) {
+	_, own := splitQuery(rawQuery)
+	mediaURL := own.Get(urlParam)
+	if !isHTTPURL(mediaURL) {
+		return content.TaskRequest{}, false
+	}
+	return s.ownRequest(content.FeedSource, mediaURL, own)
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nlsun/rss-reflector/pkg/upstream"
+)
+
+const (
+	// Generated feeds to keep. The oldest ones go first.
+	maxCachedFeeds = 1000
+
+	// How long refreshing a stale feed in the background may take.
+	feedRefreshTimeout = 2 * time.Minute
+)
+
+type feedState int
+
+const (
+	feedMissing feedState = iota
+	feedFresh
+	feedStale // Served while it is refreshed
+)
+
+// Generated feeds by request. A zero ttl keeps nothing.
+type feedCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration // How long a feed is fresh
+	stale time.Duration // How long after that it is served while refreshing
+	feeds map[string]*cachedFeed
+}
+
+type cachedFeed struct {
+	path       string // Request path, for invalidation
+	owner      string // Token it was generated for, for invalidation
+	body       []byte
+	gzipped    []byte
+	etag       string
+	modified   time.Time // When the body last changed
+	generated  time.Time
+	refreshing bool
+}
+
+func newFeedCache(ttl, stale time.Duration) *feedCache {
+	return &feedCache{ttl: ttl, stale: stale, feeds: map[string]*cachedFeed{}}
+}
+
+// A cached feed is never changed, only replaced.
+func (c *feedCache) lookup(key string, now time.Time) (*cachedFeed, feedState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.feeds[key]
+	switch {
+	case !ok:
+		return nil, feedMissing
+	case now.Sub(f.generated) < c.ttl:
+		return f, feedFresh
+	case now.Sub(f.generated) < c.ttl+c.stale:
+		return f, feedStale
+	}
+	return nil, feedMissing
+}
+
+// Reports whether the caller is the first to refresh the feed.
+func (c *feedCache) startRefresh(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.feeds[key]
+	if !ok || f.refreshing {
+		return false
+	}
+	refreshing := *f
+	refreshing.refreshing = true
+	c.feeds[key] = &refreshing
+	return true
+}
+
+// Gives up on a refresh, the stale feed stays until it expires.
+func (c *feedCache) endRefresh(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.feeds[key]; ok && f.refreshing {
+		done := *f
+		done.refreshing = false
+		c.feeds[key] = &done
+	}
+}
+
+// Keeps the modification time if the body is unchanged.
+func (c *feedCache) put(key, path, owner string, body []byte, now time.Time) (*cachedFeed, error) {
+	sum := sha256.Sum256(body)
+	f := &cachedFeed{
+		path:      path,
+		owner:     owner,
+		body:      body,
+		etag:      `"` + hex.EncodeToString(sum[:16]) + `"`,
+		modified:  now,
+		generated: now,
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	f.gzipped = buf.Bytes()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.feeds[key]; ok && old.etag == f.etag {
+		f.modified = old.modified
+	}
+	if c.ttl <= 0 {
+		return f, nil
+	}
+	if _, ok := c.feeds[key]; !ok && len(c.feeds) >= maxCachedFeeds {
+		c.evictOldest()
+	}
+	c.feeds[key] = f
+	return f, nil
+}
+
+// Drops every feed, as they may have been generated with other settings.
+func (c *feedCache) reset(ttl, stale time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl, c.stale, c.feeds = ttl, stale, map[string]*cachedFeed{}
+}
+
+// Drops all feeds served at path to owner, whatever their query.
+func (c *feedCache) invalidate(path, owner string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, f := range c.feeds {
+		if f.path == path && f.owner == owner {
+			delete(c.feeds, key)
+		}
+	}
+}
+
+// Must be called with the lock held.
+func (c *feedCache) evictOldest() {
+	var oldestKey string
+	var oldest time.Time
+	for key, f := range c.feeds {
+		if oldestKey == "" || f.generated.Before(oldest) {
+			oldestKey, oldest = key, f.generated
+		}
+	}
+	delete(c.feeds, oldestKey)
+}
+
+// Serves the feed from the cache. Stale feeds are refreshed in the background.
+func (s *State) serveRSS(w http.ResponseWriter, r *http.Request, generate func(ctx context.Context) (string, error)) {
+	// The links in a feed depend on where it was requested from.
+	base := s.baseURL(r)
+	key := base.String() + " " + r.URL.RequestURI()
+	now := time.Now()
+
+	feed, state := s.feedCache.lookup(key, now)
+	switch state {
+	case feedStale:
+		if s.feedCache.startRefresh(key) {
+			go s.refreshRSS(key, r.URL.Path, tokenName(r), generate)
+		}
+	case feedMissing:
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go handleEvents(ctx, cancel, w.(http.CloseNotifier).CloseNotify(), "handleRSS")
+		rssStr, err := generate(ctx)
+		if errors.Is(err, errNotFound) {
+			s.handleError(w, r, http.StatusNotFound)
+			return
+		} else if errors.Is(err, errForbidden) || errors.Is(err, upstream.ErrNotPublic) {
+			s.handleForbidden(w, r, err)
+			return
+		} else if errors.Is(err, errBadRequest) {
+			logger.Print(err)
+			s.handleError(w, r, http.StatusBadRequest)
+			return
+		} else if err != nil {
+			logger.Print(err)
+			s.handleError(w, r, http.StatusInternalServerError)
+			return
+		}
+		if feed, err = s.feedCache.put(key, r.URL.Path, tokenName(r), []byte(rssStr), now); err != nil {
+			logger.Print(err)
+			s.handleError(w, r, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/xml; charset=utf-8")
+	h.Set("ETag", feed.etag)
+	h.Set("Last-Modified", feed.modified.UTC().Format(http.TimeFormat))
+	h.Set("Vary", "Accept-Encoding")
+	if notModified(r, feed) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	body := feed.body
+	if acceptsGzip(r) {
+		h.Set("Content-Encoding", "gzip")
+		body = feed.gzipped
+	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	if _, err := w.Write(body); err != nil {
+		logger.Print(err)
+	}
+}
+
+func (s *State) refreshRSS(key, path, owner string, generate func(ctx context.Context) (string, error)) {
+	ctx, cancel := context.WithTimeout(context.Background(), feedRefreshTimeout)
+	defer cancel()
+	rssStr, err := generate(ctx)
+	if err == nil {
+		_, err = s.feedCache.put(key, path, owner, []byte(rssStr), time.Now())
+	}
+	if err != nil {
+		logger.Printf("refreshing %s: %s", key, err)
+		s.feedCache.endRefresh(key)
+	}
+}
+
+// If-None-Match wins over If-Modified-Since, as in RFC 7232.
+func notModified(r *http.Request, feed *cachedFeed) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == feed.etag {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !feed.modified.Truncate(time.Second).After(ims)
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(coding, ";")
+		if strings.TrimSpace(parts[0]) != "gzip" {
+			continue
+		}
+		for _, param := range parts[1:] {
+			if q := strings.Replace(strings.TrimSpace(param), " ", "", -1); q == "q=0" || q == "q=0.0" || q == "q=0.00" || q == "q=0.000" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
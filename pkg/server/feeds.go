@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/nlsun/rss-reflector/pkg/rss"
+)
+
+// Settings for a feed.
+type FeedConfig struct {
+	Rewrite *rss.Rewrite     // Changes made to items
+	Filter  rss.FilterConfig // Items to keep, feed url parameters override it
+	Cookies string           // Jar the feed and its content are fetched with, needs auth
+}
+
+// Filter parameters of feed urls, not carried over to its links.
+const (
+	includeParam     string = "include"
+	excludeParam     string = "exclude"
+	minDurationParam string = "min_duration"
+	maxDurationParam string = "max_duration"
+	dropShortsParam  string = "drop_shorts"
+	dropLiveParam    string = "drop_live"
+	afterParam       string = "after"
+)
+
+var filterParams = []string{
+	includeParam, excludeParam, minDurationParam, maxDurationParam,
+	dropShortsParam, dropLiveParam, afterParam,
+}
+
+// Feed parameters that aren't filters.
+const (
+	srcParam string = "src" // Source of a merged feed, repeatable
+	urlParam string = "url" // Upstream url of a reflected feed or its media
+)
+
+var feedParams = append([]string{srcParam, urlParam}, filterParams...)
+
+// YouTube feeds are identified by one of these upstream parameters.
+var feedIDParams = []string{"channel_id", "playlist_id", "user"}
+
+func (s *State) feedConfig(upstreamQuery string) FeedConfig {
+	q, _ := url.ParseQuery(upstreamQuery)
+	feeds := s.settings().feeds
+	for _, param := range feedIDParams {
+		if id := q.Get(param); id != "" {
+			if feed, ok := feeds[id]; ok {
+				return feed
+			}
+		}
+	}
+	return feeds["*"]
+}
+
+// Separates the feed's own parameters from the ones its links carry.
+func splitFeedParams(own url.Values) (url.Values, url.Values) {
+	feedQuery, linkQuery := url.Values{}, url.Values{}
+	for k, v := range own {
+		if contains(feedParams, k) {
+			feedQuery[k] = v
+		} else {
+			linkQuery[k] = v
+		}
+	}
+	return feedQuery, linkQuery
+}
+
+// The configured filter with the url's parameters applied over it.
+func (c FeedConfig) filter(feedQuery url.Values) (*rss.Filter, error) {
+	fc := c.Filter
+	for param, field := range map[string]*string{
+		includeParam:     &fc.Include,
+		excludeParam:     &fc.Exclude,
+		minDurationParam: &fc.MinDuration,
+		maxDurationParam: &fc.MaxDuration,
+		afterParam:       &fc.After,
+	} {
+		if v, ok := feedQuery[param]; ok {
+			*field = v[0]
+		}
+	}
+	for param, field := range map[string]*bool{
+		dropShortsParam: &fc.DropShorts,
+		dropLiveParam:   &fc.DropLive,
+	} {
+		if v, ok := feedQuery[param]; ok {
+			*field = isTrue(v[0])
+		}
+	}
+	filter, err := fc.Compile()
+	if err != nil {
+		return nil, fmt.Errorf("%w: feed filter: %s", errBadRequest, err)
+	}
+	return filter, nil
+}
+
+func (s *State) videoDetails(ctx context.Context, videoURL string) (*rss.VideoDetails, error) {
+	info, err := s.fetcher.Details(ctx, videoURL)
+	if err != nil {
+		return nil, err
+	}
+	return &rss.VideoDetails{
+		Duration: time.Duration(info.Duration * float64(time.Second)),
+		Live:     info.Live(),
+	}, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
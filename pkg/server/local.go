@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	feedI "github.com/mmcdole/gofeed"
+
+	"github.com/nlsun/rss-reflector/pkg/rss"
+)
+
+// Served at /rss/local/<name>, files at /content/local/<name>/<path>.
+const localPrefix string = "local/"
+
+func (s *State) localRSS(ctx context.Context, base url.URL, name, rawQuery string) (string, error) {
+	items, ok := s.local.Items(name)
+	if !ok {
+		return "", errNotFound
+	}
+	_, own := splitQuery(rawQuery)
+	feedQuery, linkQuery := splitFeedParams(own)
+	feed := s.settings().feeds[localPrefix+name]
+	filter, err := feed.filter(feedQuery)
+	if err != nil {
+		return "", err
+	}
+
+	inFeed := &feedI.Feed{Title: name, Description: name}
+	for _, item := range items {
+		date := item.Date
+		i := &feedI.Item{
+			Title:           item.Title,
+			Description:     item.Description,
+			GUID:            "local:" + name + "/" + item.Path,
+			PublishedParsed: &date,
+			Enclosures: []*feedI.Enclosure{{
+				URL:    path.Join(name, item.Path),
+				Type:   item.MimeType,
+				Length: strconv.FormatInt(item.Size, 10),
+			}},
+		}
+		if item.Author != "" {
+			i.Author = &feedI.Person{Name: item.Author}
+		}
+		inFeed.Items = append(inFeed.Items, i)
+	}
+	return rss.GenLocalRSS(ctx, inFeed, rss.Options{
+		Base:           base,
+		ContentPrePath: path.Join(contentPath, localPrefix),
+		LinkQuery:      linkQuery,
+		Rewrite:        feed.Rewrite,
+		Filter:         filter,
+		Client:         s.client,
+	})
+}
+
+// ServeContent takes care of ranges and conditional requests.
+func (s *State) handleLocalContent(w http.ResponseWriter, r *http.Request, qPath string) {
+	parts := strings.SplitN(qPath, "/", 2)
+	if len(parts) < 2 {
+		s.handleError(w, r, http.StatusNotFound)
+		return
+	}
+	f, item, err := s.local.Open(parts[0], parts[1])
+	if os.IsNotExist(err) {
+		s.handleError(w, r, http.StatusNotFound)
+		return
+	} else if err != nil {
+		logger.Print(err)
+		s.handleError(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", item.MimeType)
+	fi, err := f.Stat()
+	if err != nil {
+		logger.Print(err)
+		s.handleError(w, r, http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, r, item.Path, fi.ModTime(), f)
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/nlsun/rss-reflector/pkg/rss"
+)
+
+// Served at /rss/merge/<name>, or at /rss/merge?src=...&src=... ad hoc.
+const mergeName string = "merge"
+
+const adhocMergeTitle = "Merged feed"
+
+// Sources keep their own feed settings, the merge's apply on top.
+func (s *State) mergedRSS(ctx context.Context, base url.URL, name, rawQuery string) (string, error) {
+	_, own := splitQuery(rawQuery)
+	feedQuery, linkQuery := splitFeedParams(own)
+
+	specs := feedQuery[srcParam]
+	title := adhocMergeTitle
+	if name != "" {
+		var ok bool
+		if specs, ok = s.settings().merges[name]; !ok {
+			return "", errNotFound
+		}
+		title = name
+	}
+	if len(specs) == 0 {
+		return "", fmt.Errorf("%w: merged feed without sources", errBadRequest)
+	}
+
+	var sources []rss.Source
+	for _, spec := range specs {
+		src, err := rss.ParseSource(spec)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s", errBadRequest, err)
+		}
+		if err := s.checkYoutubeFeed(src.Path, src.RawQuery); err != nil {
+			return "", err
+		}
+		feed := s.feedConfig(src.RawQuery)
+		if src.Filter, err = feed.filter(nil); err != nil {
+			return "", err
+		}
+		src.Rewrite = feed.Rewrite
+		sources = append(sources, src)
+	}
+
+	merge := s.settings().feeds[mergeName+"/"+name]
+	filter, err := merge.filter(feedQuery)
+	if err != nil {
+		return "", err
+	}
+	// The merged feed's jar applies to all its sources.
+	if ctx, err = s.feedCookies(ctx, merge, linkQuery); err != nil {
+		return "", err
+	}
+	opts := s.feedOptions(base, linkQuery)
+	opts.Rewrite = merge.Rewrite
+	opts.Filter = filter
+	return rss.GenMergedRSS(ctx, title, sources, opts)
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Proxies trusted by default.
+var DefaultTrustedProxies = []string{"127.0.0.0/8", "::1/128"}
+
+// Parses addresses and networks such as 10.0.0.1 or 10.0.0.0/8.
+func ParseTrustedProxies(specs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		if !strings.Contains(spec, "/") {
+			ip := net.ParseIP(spec)
+			if ip == nil {
+				return nil, fmt.Errorf("bad proxy address %q", spec)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(spec)
+		if err != nil {
+			return nil, fmt.Errorf("bad proxy network %q", spec)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Where clients reach the reflector: the public url, else the request as
+// trusted proxies forwarded it.
+func (s *State) baseURL(r *http.Request) url.URL {
+	if u := s.settings().publicURL; u != nil {
+		return *u
+	}
+	base := url.URL{Scheme: "http", Host: r.Host}
+	if r.TLS != nil {
+		base.Scheme = "https"
+	}
+	if !s.trusted(remoteIP(r)) {
+		return base
+	}
+	// Taken from what the outermost trusted proxy added.
+	hops := 0
+	if fwd := parseForwarded(r.Header); len(fwd) > 0 {
+		i := originHop(forwardedFor(fwd), s.trusted)
+		hops = len(fwd) - 1 - i
+		if proto := fwd[i]["proto"]; proto == "http" || proto == "https" {
+			base.Scheme = proto
+		}
+		if host := fwd[i]["host"]; host != "" {
+			base.Host = host
+		}
+	} else {
+		if xff := headerList(r.Header, "X-Forwarded-For"); len(xff) > 0 {
+			hops = len(xff) - 1 - originHop(xff, s.trusted)
+		}
+		if proto := fromRight(headerList(r.Header, "X-Forwarded-Proto"), hops); proto == "http" || proto == "https" {
+			base.Scheme = proto
+		}
+		if host := fromRight(headerList(r.Header, "X-Forwarded-Host"), hops); host != "" {
+			base.Host = host
+		}
+	}
+	if prefix := fromRight(headerList(r.Header, "X-Forwarded-Prefix"), hops); strings.HasPrefix(prefix, "/") {
+		base.Path = strings.TrimSuffix(prefix, "/")
+	}
+	return base
+}
+
+// Index of the hop the outermost trusted proxy added, -1 if there are none.
+func originHop(hops []string, trusted func(net.IP) bool) int {
+	if len(hops) == 0 {
+		return -1
+	}
+	for i := len(hops) - 1; i > 0; i-- {
+		if ip := forwardedIP(hops[i]); ip == nil || !trusted(ip) {
+			return i
+		}
+	}
+	return 0
+}
+
+// The value hops before the last one, or the oldest there is.
+func fromRight(values []string, hops int) string {
+	if len(values) == 0 {
+		return ""
+	}
+	if i := len(values) - 1 - hops; i >= 0 {
+		return values[i]
+	}
+	return values[0]
+}
+
+// The client's address, following back trusted proxies.
+func clientIP(r *http.Request, trusted func(net.IP) bool) string {
+	ip := remoteIP(r)
+	if !trusted(ip) {
+		return ip.String()
+	}
+	hops := headerList(r.Header, "X-Forwarded-For")
+	if fwd := parseForwarded(r.Header); len(fwd) > 0 {
+		hops = forwardedFor(fwd)
+	}
+	i := originHop(hops, trusted)
+	if i < 0 {
+		return ip.String()
+	}
+	if hop := forwardedIP(hops[i]); hop != nil {
+		return hop.String()
+	}
+	// An obfuscated client, the proxy it came through is the best there is.
+	if i+1 < len(hops) {
+		return forwardedIP(hops[i+1]).String()
+	}
+	return ip.String()
+}
+
+func (s *State) trusted(ip net.IP) bool {
+	for _, n := range s.settings().trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// Node of a Forwarded for= or X-Forwarded-For, nil if obfuscated.
+func forwardedIP(node string) net.IP {
+	node = strings.Trim(strings.TrimSpace(node), `"`)
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+	return net.ParseIP(strings.Trim(node, "[]"))
+}
+
+// Elements of RFC 7239 Forwarded headers in order.
+func parseForwarded(h http.Header) []map[string]string {
+	var elems []map[string]string
+	for _, v := range h["Forwarded"] {
+		for _, elem := range splitQuoted(v, ',') {
+			params := map[string]string{}
+			for _, pair := range splitQuoted(elem, ';') {
+				kv := strings.SplitN(pair, "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				val := strings.TrimSpace(kv[1])
+				if len(val) >= 2 && val[0] == '"' && val[len(val)-1] == '"' {
+					val = strings.Replace(val[1:len(val)-1], `\`, "", -1)
+				}
+				params[strings.ToLower(strings.TrimSpace(kv[0]))] = val
+			}
+			if len(params) > 0 {
+				elems = append(elems, params)
+			}
+		}
+	}
+	return elems
+}
+
+// Splits on sep outside of quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// The for= nodes of Forwarded elements.
+func forwardedFor(elems []map[string]string) []string {
+	var hops []string
+	for _, elem := range elems {
+		hops = append(hops, elem["for"])
+	}
+	return hops
+}
+
+// Comma separated values of all the headers called name, in order.
+func headerList(h http.Header, name string) []string {
+	var values []string
+	for _, v := range h[name] {
+		for _, s := range strings.Split(v, ",") {
+			values = append(values, strings.TrimSpace(s))
+		}
+	}
+	return values
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseForwarded(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		want    []map[string]string
+	}{
+		{name: "none"},
+		{
+			name:    "one element",
+			headers: []string{`for=192.0.2.60;proto=https;by=203.0.113.43`},
+			want:    []map[string]string{{"for": "192.0.2.60", "proto": "https", "by": "203.0.113.43"}},
+		},
+		{
+			name:    "elements across headers",
+			headers: []string{`for=192.0.2.43, For="[2001:db8:cafe::17]:4711"`, `for=10.0.0.1;Host=example.com`},
+			want: []map[string]string{
+				{"for": "192.0.2.43"},
+				{"for": "[2001:db8:cafe::17]:4711"},
+				{"for": "10.0.0.1", "host": "example.com"},
+			},
+		},
+		{
+			name:    "separators in quotes",
+			headers: []string{`for="_a,b;c";host="ex\"ample.com"`},
+			want:    []map[string]string{{"for": "_a,b;c", "host": `ex"ample.com`}},
+		},
+		{name: "garbage", headers: []string{"nonsense, ;;"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{"Forwarded": tt.headers}
+			if got := parseForwarded(h); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func newProxyState(t *testing.T, trusted ...string) *State {
+	nets, err := ParseTrustedProxies(trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &State{set: &settings{trustedProxies: nets}}
+}
+
+func TestClientIP(t *testing.T) {
+	s := newProxyState(t, "10.0.0.0/8", "::1")
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{name: "direct", remote: "192.0.2.1:1234", want: "192.0.2.1"},
+		{name: "untrusted proxy", remote: "192.0.2.1:1234", headers: map[string]string{"X-Forwarded-For": "198.51.100.7"}, want: "192.0.2.1"},
+		{name: "trusted proxy", remote: "10.0.0.1:1234", headers: map[string]string{"X-Forwarded-For": "198.51.100.7"}, want: "198.51.100.7"},
+		{name: "spoofed hops", remote: "10.0.0.1:1234", headers: map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.7, 10.0.0.2"}, want: "198.51.100.7"},
+		{name: "forwarded wins", remote: "10.0.0.1:1234", headers: map[string]string{"Forwarded": `for="[2001:db8::1]:4711"`, "X-Forwarded-For": "198.51.100.7"}, want: "2001:db8::1"},
+		{name: "obfuscated client", remote: "10.0.0.1:1234", headers: map[string]string{"Forwarded": "for=_hidden, for=10.0.0.2"}, want: "10.0.0.2"},
+		{name: "no hops", remote: "[::1]:1234", want: "::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/rss/feed", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := clientIP(r, s.trusted); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	s := newProxyState(t, "10.0.0.0/8")
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{name: "direct", remote: "192.0.2.1:1", want: "http://reflector.lan"},
+		{name: "untrusted", remote: "192.0.2.1:1", headers: map[string]string{"X-Forwarded-Host": "evil.example"}, want: "http://reflector.lan"},
+		{
+			name:    "one proxy",
+			remote:  "10.0.0.1:1",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.7", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "pods.example", "X-Forwarded-Prefix": "/rr/"},
+			want:    "https://pods.example/rr",
+		},
+		{
+			name:    "client sent its own values",
+			remote:  "10.0.0.1:1",
+			headers: map[string]string{"X-Forwarded-For": "6.6.6.6, 198.51.100.7", "X-Forwarded-Proto": "http, https", "X-Forwarded-Host": "evil.example, pods.example", "X-Forwarded-Prefix": "/evil, /rr"},
+			want:    "https://pods.example/rr",
+		},
+		{
+			name:    "proxy behind a proxy",
+			remote:  "10.0.0.1:1",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.7, 10.0.0.2", "X-Forwarded-Host": "pods.example, internal.lan"},
+			want:    "http://pods.example",
+		},
+		{
+			name:    "proxy that replaces the host",
+			remote:  "10.0.0.1:1",
+			headers: map[string]string{"X-Forwarded-For": "6.6.6.6, 198.51.100.7", "X-Forwarded-Host": "pods.example"},
+			want:    "http://pods.example",
+		},
+		{
+			name:    "forwarded",
+			remote:  "10.0.0.1:1",
+			headers: map[string]string{"Forwarded": "for=6.6.6.6;host=evil.example;proto=http, for=198.51.100.7;host=pods.example;proto=https"},
+			want:    "https://pods.example",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://reflector.lan/rss/feed", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			u := s.baseURL(r)
+			if got := u.String(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets, err := ParseTrustedProxies([]string{"10.0.0.1", " 192.168.0.0/16 ", "", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{"10.0.0.1": true, "10.0.0.2": false, "192.168.5.5": true, "::1": true, "::2": false} {
+		s := &State{set: &settings{trustedProxies: nets}}
+		if got := s.trusted(net.ParseIP(ip)); got != want {
+			t.Errorf("%s trusted %v, want %v", ip, got, want)
+		}
+	}
+	if _, err := ParseTrustedProxies([]string{"10.0.0/8"}); err == nil {
+		t.Error("bad network accepted")
+	}
+}
@@ -0,0 +1,349 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	feedI "github.com/mmcdole/gofeed"
+
+	"github.com/nlsun/rss-reflector/pkg/content"
+	"github.com/nlsun/rss-reflector/pkg/queue"
+	"github.com/nlsun/rss-reflector/pkg/rss"
+)
+
+// Queues are managed at /queue/<name> and served at /rss/queue/<name>.
+const queueName string = "queue"
+
+// Parameters of queue requests. Share targets may only give text.
+const (
+	queueURLParam    string = "url"
+	queueTextParam   string = "text"
+	queueTitleParam  string = "title"
+	queueRemoveParam string = "remove"
+)
+
+var (
+	firstURLRe = regexp.MustCompile(`https?://\S+`)
+
+	youtubeHosts = map[string]bool{
+		"youtube.com":       true,
+		"www.youtube.com":   true,
+		"m.youtube.com":     true,
+		"music.youtube.com": true,
+		"youtu.be":          true,
+	}
+)
+
+var queuePage = template.Must(template.New("queue").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width">
+<title>{{.Name}}</title></head><body>
+<h1>{{.Name}}</h1>
+{{if .Added}}<p>Added {{if .Added.Title}}{{.Added.Title}}{{else}}{{.Added.URL}}{{end}}.</p>{{end}}
+<form method="post" action="{{.Path}}"><input name="url" size="50" placeholder="YouTube url"> <button>Add</button></form>
+<p>Feed: <a href="{{.Feed}}">{{.Feed}}</a></p>
+<p>Bookmarklet: <a href="{{.Bookmarklet}}">add to {{.Name}}</a></p>
+<ol>{{range .Entries}}
+<li><a href="{{.URL}}">{{if .Title}}{{.Title}}{{else}}{{.URL}}{{end}}</a>
+<form method="post" action="{{$.Path}}" style="display:inline"><input type="hidden" name="remove" value="{{.ID}}"><button>Remove</button></form></li>{{end}}
+</ol></body></html>
+`))
+
+type queuePageData struct {
+	Name        string
+	Path        string
+	Feed        string
+	Bookmarklet template.URL
+	Added       *queue.Entry
+	Entries     []queue.Entry
+}
+
+// GET shows the queue and adds the url in the query, POST adds or removes,
+// DELETE of /queue/<name>/<id> removes. Each token has its own queues.
+func (s *State) handleQueue(w http.ResponseWriter, r *http.Request) {
+	qPath := strings.TrimPrefix(r.URL.Path, queuePathSlash)
+	parts := strings.SplitN(qPath, "/", 2)
+	name := parts[0]
+	if !queue.ValidName(name) {
+		s.handleError(w, r, http.StatusNotFound)
+		return
+	}
+	if len(parts) == 2 {
+		if r.Method != http.MethodDelete {
+			s.handleError(w, r, http.StatusMethodNotAllowed)
+			return
+		}
+		s.removeQueued(w, r, name, parts[1])
+		return
+	}
+	owner := tokenName(r)
+	queues := s.queues.Owner(owner)
+
+	if err := r.ParseForm(); err != nil {
+		s.handleError(w, r, http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodPost:
+	default:
+		s.handleError(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	if id := r.Form.Get(queueRemoveParam); id != "" && r.Method == http.MethodPost {
+		_, err := queues.Remove(name, id)
+		s.queueChanged(owner, name)
+		if err != nil {
+			logger.Print(err)
+			s.handleError(w, r, http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, s.baseURL(r).Path+r.URL.Path+tokenQuery(r), http.StatusSeeOther)
+		return
+	}
+
+	var added *queue.Entry
+	if shared := sharedURL(r.Form); shared != "" {
+		_, own := splitQuery(r.URL.RawQuery)
+		e, err := s.enqueue(r, name, shared, r.Form.Get(queueTitleParam), own)
+		var tooMany *tooManyError
+		if errors.Is(err, errBadRequest) {
+			logger.Print(err)
+			s.handleError(w, r, http.StatusBadRequest)
+			return
+		} else if errors.Is(err, errForbidden) {
+			s.handleForbidden(w, r, err)
+			return
+		} else if errors.As(err, &tooMany) {
+			logger.Print(err)
+			s.handleTooMany(w, r, tooMany.wait)
+			return
+		} else if err != nil {
+			logger.Print(err)
+			s.handleError(w, r, http.StatusInternalServerError)
+			return
+		}
+		if r.Method == http.MethodPost && !acceptsHTML(r) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			if err := json.NewEncoder(w).Encode(e); err != nil {
+				logger.Print(err)
+			}
+			return
+		}
+		added = &e
+	} else if r.Method == http.MethodPost {
+		s.handleError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	entries, err := queues.List(name)
+	if err != nil {
+		logger.Print(err)
+		s.handleError(w, r, http.StatusInternalServerError)
+		return
+	}
+	if !acceptsHTML(r) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(entries); err != nil {
+			logger.Print(err)
+		}
+		return
+	}
+	base := s.baseURL(r)
+	pagePath := base.Path + r.URL.Path
+	root := base.Scheme + "://" + base.Host
+	tq := tokenQuery(r)
+	addURL := root + pagePath + "?" + queueURLParam + "="
+	if tq != "" {
+		addURL = root + pagePath + tq + "&" + queueURLParam + "="
+	}
+	data := queuePageData{
+		Name:        name,
+		Path:        pagePath + tq,
+		Feed:        root + base.Path + rssPathSlash + queueName + "/" + name + tq,
+		Added:       added,
+		Entries:     entries,
+		Bookmarklet: template.URL(fmt.Sprintf("javascript:location.href=%q+encodeURIComponent(location.href)", addURL)),
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := queuePage.Execute(w, data); err != nil {
+		logger.Print(err)
+	}
+}
+
+func (s *State) removeQueued(w http.ResponseWriter, r *http.Request, name, id string) {
+	owner := tokenName(r)
+	found, err := s.queues.Owner(owner).Remove(name, id)
+	s.queueChanged(owner, name)
+	if err != nil {
+		logger.Print(err)
+		s.handleError(w, r, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		s.handleError(w, r, http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func sharedURL(form url.Values) string {
+	if u := form.Get(queueURLParam); u != "" {
+		return u
+	}
+	return firstURLRe.FindString(form.Get(queueTextParam))
+}
+
+// Browsers get pages, everything else JSON.
+func acceptsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// Adds a YouTube video to the queue and downloads it in the background.
+func (s *State) enqueue(r *http.Request, name, rawURL, title string, own url.Values) (queue.Entry, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || !youtubeHosts[u.Host] {
+		return queue.Entry{}, fmt.Errorf("%w: not a YouTube url %q", errBadRequest, rawURL)
+	}
+	id, err := content.YoutubeVideoID(rawURL)
+	if err != nil {
+		return queue.Entry{}, fmt.Errorf("%w: %s", errBadRequest, err)
+	}
+	req, ok := s.youtubeRequest(youtubeWatchURL(id), own)
+	if !ok {
+		return queue.Entry{}, fmt.Errorf("%w: bad parameters %v", errBadRequest, own)
+	}
+	if err := s.checkContent(context.Background(), req, ""); err != nil {
+		return queue.Entry{}, err
+	}
+	// Nothing signs what is shared, so only the token's own jar may be used.
+	if err := s.checkCookies(r, req, false); err != nil {
+		return queue.Entry{}, err
+	}
+	// Adding starts a download, so it is limited like content requests.
+	keys := s.clientKeys(r)
+	if err := rateLeft(s.contentLimiter, keys); err != nil {
+		return queue.Entry{}, err
+	}
+	cached, err := s.fetcher.Cached(req)
+	if err != nil {
+		logger.Print(err)
+	}
+	if !cached {
+		if err := s.startDownload(keys); err != nil {
+			return queue.Entry{}, err
+		}
+	}
+	owner := tokenName(r)
+	e, err := s.queues.Owner(owner).Add(name, queue.Entry{ID: id, URL: req.Uri, Title: title})
+	s.queueChanged(owner, name)
+	if err != nil {
+		return queue.Entry{}, err
+	}
+	if !s.queuePrefetch(prefetch{owner: owner, name: name, entry: e, req: req}) {
+		logger.Printf("queue %s: too many videos waiting, %s is fetched when played", name, e.URL)
+	}
+	return e, nil
+}
+
+// Videos added to a queue wait here to be fetched one at a time.
+type prefetch struct {
+	owner string
+	name  string
+	entry queue.Entry
+	req   content.TaskRequest
+}
+
+// How many added videos may wait to be fetched.
+const maxPrefetches = 100
+
+// Returns false if too many are waiting already.
+func (s *State) queuePrefetch(p prefetch) bool {
+	select {
+	case s.prefetches <- p:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *State) handlePrefetches() {
+	for {
+		select {
+		case p := <-s.prefetches:
+			s.prefetch(p.owner, p.name, p.entry, p.req)
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *State) prefetch(owner, name string, e queue.Entry, req content.TaskRequest) {
+	ctx := s.ctx
+	if info, err := s.fetcher.Info(ctx, e.URL); err != nil {
+		logger.Printf("queue %s: looking up %s: %s", name, e.URL, err)
+	} else if err := s.queues.Owner(owner).Update(name, queue.Entry{ID: e.ID, Title: info.Title, Description: info.Description, Author: info.Uploader}); err != nil {
+		logger.Printf("queue %s: %s", name, err)
+	} else {
+		s.queueChanged(owner, name)
+	}
+	_, err := s.fetcher.SubmitTask(ctx, req)
+	s.fetcher.FinishTask()
+	if err != nil {
+		logger.Printf("queue %s: fetching %s: %s", name, e.URL, err)
+	}
+}
+
+// Cached feeds of the owner's queue are out of date.
+func (s *State) queueChanged(owner, name string) {
+	s.feedCache.invalidate(rssPathSlash+queueName+"/"+name, owner)
+}
+
+func (s *State) queueRSS(ctx context.Context, base url.URL, name, rawQuery string) (string, error) {
+	if !queue.ValidName(name) {
+		return "", errNotFound
+	}
+	entries, err := s.queues.Owner(ctxTokenName(ctx)).List(name)
+	if err != nil {
+		return "", err
+	}
+	_, own := splitQuery(rawQuery)
+	feedQuery, linkQuery := splitFeedParams(own)
+	feed := s.settings().feeds[queueName+"/"+name]
+	filter, err := feed.filter(feedQuery)
+	if err != nil {
+		return "", err
+	}
+	if ctx, err = s.feedCookies(ctx, feed, linkQuery); err != nil {
+		return "", err
+	}
+
+	inFeed := &feedI.Feed{Title: name, Description: name}
+	for _, e := range entries {
+		added := e.Added
+		item := &feedI.Item{
+			Title:           e.Title,
+			Link:            e.URL,
+			Description:     e.Description,
+			GUID:            "queue:" + e.ID,
+			PublishedParsed: &added,
+		}
+		if item.Title == "" {
+			item.Title = e.URL
+		}
+		if e.Author != "" {
+			item.Author = &feedI.Person{Name: e.Author}
+		}
+		inFeed.Items = append(inFeed.Items, item)
+	}
+	opts := s.feedOptions(base, linkQuery)
+	opts.Rewrite = feed.Rewrite
+	opts.Filter = filter
+	return rss.GenVideosRSS(ctx, inFeed, opts)
+}
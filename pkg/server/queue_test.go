@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nlsun/rss-reflector/pkg/queue"
+)
+
+func TestQueuePerToken(t *testing.T) {
+	queues, err := queue.NewStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &State{queues: queues, feedCache: newFeedCache(time.Hour, 0)}
+	if _, err := queues.Owner("alice").Add("q", queue.Entry{ID: "abcdefghijk"}); err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r = r.WithContext(withTokenName(r.Context(), token))
+		w := httptest.NewRecorder()
+		s.handleQueue(w, r)
+		return w
+	}
+	list := func(token string) []queue.Entry {
+		var entries []queue.Entry
+		if err := json.NewDecoder(do("GET", queuePathSlash+"q", token).Body).Decode(&entries); err != nil {
+			t.Fatal(err)
+		}
+		return entries
+	}
+
+	if entries := list("bob"); len(entries) != 0 {
+		t.Errorf("bob sees %v", entries)
+	}
+	if w := do("DELETE", queuePathSlash+"q/abcdefghijk", "bob"); w.Code != http.StatusNotFound {
+		t.Errorf("bob removing got %d", w.Code)
+	}
+	if entries := list("alice"); len(entries) != 1 {
+		t.Errorf("alice sees %v", entries)
+	}
+
+	path := rssPathSlash + queueName + "/q"
+	for _, token := range []string{"alice", "bob"} {
+		if _, err := s.feedCache.put(token, path, token, []byte(token), time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w := do("DELETE", queuePathSlash+"q/abcdefghijk", "alice"); w.Code != http.StatusNoContent {
+		t.Errorf("alice removing got %d", w.Code)
+	}
+	if _, state := s.feedCache.lookup("alice", time.Now()); state != feedMissing {
+		t.Error("alice's feed still cached")
+	}
+	if _, state := s.feedCache.lookup("bob", time.Now()); state != feedFresh {
+		t.Error("bob's feed dropped")
+	}
+}
+
+func TestQueuePrefetchBounded(t *testing.T) {
+	s := &State{prefetches: make(chan prefetch, maxPrefetches)}
+	for i := 0; i < maxPrefetches; i++ {
+		if !s.queuePrefetch(prefetch{name: "q", entry: queue.Entry{ID: fmt.Sprint(i)}}) {
+			t.Fatalf("prefetch %d refused", i)
+		}
+	}
+	if s.queuePrefetch(prefetch{name: "q", entry: queue.Entry{ID: "extra"}}) {
+		t.Error("prefetch past the limit queued")
+	}
+}
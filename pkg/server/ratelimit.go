@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nlsun/rss-reflector/pkg/ratelimit"
+)
+
+// Limits and quotas apply to the client address and the token.
+func (s *State) clientKeys(r *http.Request) []string {
+	keys := []string{"ip:" + clientIP(r, s.trusted)}
+	if name := tokenName(r); name != "" {
+		keys = append(keys, "token:"+name)
+	}
+	return keys
+}
+
+// Reported when a client is over a rate or has used up a quota.
+type tooManyError struct {
+	reason string
+	wait   time.Duration // Until the client may try again
+}
+
+func (e *tooManyError) Error() string {
+	return e.reason
+}
+
+// Turns away requests over the limiter's rate.
+func (s *State) limited(l *ratelimit.Limiter, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := rateLeft(l, s.clientKeys(r)); err != nil {
+			logger.Printf("%s on %s", err, r.URL.Path)
+			s.handleTooMany(w, r, err.wait)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// Takes a token from each key's bucket, or none if one of them is empty.
+func rateLeft(l *ratelimit.Limiter, keys []string) *tooManyError {
+	if ok, wait := l.AllowAll(keys, time.Now()); !ok {
+		return &tooManyError{reason: fmt.Sprintf("rate limited %v", keys), wait: wait}
+	}
+	return nil
+}
+
+// Counts a download toward the daily quota of each key, unless one of them
+// has used it up.
+func (s *State) startDownload(keys []string) *tooManyError {
+	if wait, ok := quotaLeft(s.downloadsQuota, keys); !ok {
+		return &tooManyError{reason: fmt.Sprintf("download quota used up for %v", keys), wait: wait}
+	}
+	now := time.Now()
+	for _, key := range keys {
+		s.downloadsQuota.Use(key, 1, now)
+	}
+	return nil
+}
+
+// Counts what is served toward the daily byte quota.
+func (s *State) metered(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys := s.clientKeys(r)
+		if wait, ok := quotaLeft(s.bytesQuota, keys); !ok {
+			logger.Printf("byte quota used up for %v", keys)
+			s.handleTooMany(w, r, wait)
+			return
+		}
+		cw := &countingWriter{ResponseWriter: w}
+		h(cw, r)
+		now := time.Now()
+		for _, key := range keys {
+			s.bytesQuota.Use(key, cw.n, now)
+		}
+	}
+}
+
+func quotaLeft(q *ratelimit.Quota, keys []string) (time.Duration, bool) {
+	now := time.Now()
+	for _, key := range keys {
+		if ok, wait := q.Allow(key, now); !ok {
+			return wait, false
+		}
+	}
+	return 0, true
+}
+
+func (s *State) handleTooMany(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	s.handleError(w, r, http.StatusTooManyRequests)
+}
+
+// Counts the bytes of the response body.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+// Keeps files served with sendfile.
+func (w *countingWriter) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(w.ResponseWriter, r)
+	w.n += n
+	return n, err
+}
+
+// Handlers stop work when the client goes away.
+func (w *countingWriter) CloseNotify() <-chan bool {
+	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nlsun/rss-reflector/pkg/ratelimit"
+)
+
+func TestCountingWriterReadFrom(t *testing.T) {
+	rec := httptest.NewRecorder()
+	var w io.Writer = &countingWriter{ResponseWriter: rec}
+	if _, ok := w.(io.ReaderFrom); !ok {
+		t.Fatal("not a ReaderFrom")
+	}
+	if _, err := io.Copy(w, strings.NewReader("audio")); err != nil {
+		t.Fatal(err)
+	}
+	if n := w.(*countingWriter).n; n != 5 || rec.Body.String() != "audio" {
+		t.Errorf("counted %d of %q", n, rec.Body.String())
+	}
+}
+
+func TestStartDownload(t *testing.T) {
+	s := &State{downloadsQuota: ratelimit.NewQuota(2)}
+	keys := []string{"ip:192.0.2.1", "token:alice"}
+	for i := 0; i < 2; i++ {
+		if err := s.startDownload(keys); err != nil {
+			t.Fatalf("download %d: %v", i, err)
+		}
+	}
+	err := s.startDownload(keys)
+	if err == nil || err.wait <= 0 {
+		t.Fatalf("got %v, want the quota used up", err)
+	}
+	// The token's downloads from another address count too.
+	if err := s.startDownload([]string{"ip:192.0.2.2", "token:alice"}); err == nil {
+		t.Error("token over its quota from another address")
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/nlsun/rss-reflector/pkg/access"
+	"github.com/nlsun/rss-reflector/pkg/rss"
+)
+
+// What can change while the server runs. Replaced, never changed.
+type settings struct {
+	feeds          map[string]FeedConfig // Settings by feed id
+	merges         map[string][]string   // Sources of merged feeds by name
+	access         *access.List          // What may be reflected, nil for anything
+	feedJars       map[string]bool       // Jars of configured feeds, which every token may use
+	signTTL        time.Duration         // How long signatures are valid, 0 for ever
+	publicURL      *url.URL              // Where clients reach the reflector, nil to work it out
+	trustedProxies []*net.IPNet          // Proxies whose forwarding headers are believed
+}
+
+func newSettings(cfg Config, auth bool) (*settings, error) {
+	for name, specs := range cfg.Merges {
+		for _, spec := range specs {
+			if _, err := rss.ParseSource(spec); err != nil {
+				return nil, fmt.Errorf("merged feed %s: %w", name, err)
+			}
+		}
+	}
+	// Without tokens anyone could get what the cookies fetch.
+	feedJars := map[string]bool{}
+	for id, feed := range cfg.Feeds {
+		if feed.Cookies == "" {
+			continue
+		}
+		if !auth {
+			return nil, fmt.Errorf("feed %s: cookies need auth", id)
+		}
+		feedJars[feed.Cookies] = true
+	}
+	return &settings{
+		feeds:          cfg.Feeds,
+		merges:         cfg.Merges,
+		access:         cfg.Access,
+		feedJars:       feedJars,
+		signTTL:        cfg.SignTTL,
+		publicURL:      cfg.PublicURL,
+		trustedProxies: cfg.TrustedProxies,
+	}, nil
+}
+
+func (s *State) settings() *settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.set
+}
+
+// Applies the parts of cfg that can change at runtime. Anything else, such
+// as the address or the downloaders, keeps its value until a restart.
+func (s *State) Reload(cfg Config) error {
+	set, err := newSettings(cfg, s.tokens != nil)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.set = set
+	s.mu.Unlock()
+
+	s.feedLimiter.SetRate(cfg.FeedRate)
+	s.contentLimiter.SetRate(cfg.ContentRate)
+	s.bytesQuota.SetLimit(cfg.DailyBytes)
+	s.downloadsQuota.SetLimit(cfg.DailyDownloads)
+	s.feedCache.reset(cfg.FeedTTL, cfg.FeedStale)
+	logger.Printf("reloaded settings for %d feeds and %d merged feeds", len(cfg.Feeds), len(cfg.Merges))
+	return nil
+}
@@ -2,67 +2,325 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/nlsun/rss-reflector/pkg/access"
+	"github.com/nlsun/rss-reflector/pkg/auth"
 	"github.com/nlsun/rss-reflector/pkg/content"
+	"github.com/nlsun/rss-reflector/pkg/cookies"
+	"github.com/nlsun/rss-reflector/pkg/local"
 	"github.com/nlsun/rss-reflector/pkg/log"
+	"github.com/nlsun/rss-reflector/pkg/queue"
+	"github.com/nlsun/rss-reflector/pkg/ratelimit"
 	"github.com/nlsun/rss-reflector/pkg/rss"
+	"github.com/nlsun/rss-reflector/pkg/upstream"
 	"github.com/nlsun/rss-reflector/pkg/util"
 )
 
 var logger = log.DefaultLogger
 
+var (
+	errNotFound   = errors.New("not found")
+	errBadRequest = errors.New("bad request")
+)
+
+type Config struct {
+	Addr            string                     // Address to listen on
+	DataDir         string                     // Data directory
+	Downloaders     []content.DownloaderConfig // Download backends in fallback order
+	Postprocess     content.PostprocessConfig  // Processing applied after download
+	MaxNumDataFiles int                        // Max number of cached data files
+	TaskTimeout     time.Duration              // Max duration of a single download
+	// Settings by channel, playlist or user id, merge/<name> or "*".
+	Feeds map[string]FeedConfig
+	// Sources of merged feeds by name, see rss.ParseSource.
+	Merges map[string][]string
+	// Directories served as feeds, and how often they are rescanned.
+	LocalDirs []local.Dir
+	LocalPoll time.Duration
+	// Max entries of each queue, 0 for no limit.
+	QueueMax int
+	// Require a token from the store for every request.
+	Auth bool
+	// Sign content links and refuse unsigned content requests. Signatures
+	// expire after SignTTL, never if it is 0.
+	SignURLs bool
+	SignTTL  time.Duration
+	// Which channels, playlists and urls may be reflected. Optional.
+	Access *access.List
+	// Request rates of feed and content endpoints.
+	FeedRate    ratelimit.Rate
+	ContentRate ratelimit.Rate
+	// What each client address and token may download per day, 0 for no
+	// limit.
+	DailyBytes     int64
+	DailyDownloads int64
+	// Where clients reach the reflector. Optional.
+	PublicURL *url.URL
+	// Proxies whose forwarding headers are believed.
+	TrustedProxies []*net.IPNet
+	// How long generated feeds are served, 0 for not at all, and how long
+	// after that they are served stale while regenerated.
+	FeedTTL   time.Duration
+	FeedStale time.Duration
+	// Timeouts, proxies and retries of requests to upstream sites.
+	Upstream upstream.Config
+	// Let /rss/feed and /content/feed fetch loopback and private addresses.
+	AllowPrivateURLs bool
+}
+
 type State struct {
-	addr    string           // Address to listen on
-	fetcher *content.Fetcher // Content fetcher
+	addr     string           // Address to listen on
+	fetcher  *content.Fetcher // Content fetcher
+	local    *local.Library   // Directories served as feeds
+	queues   *queue.Store     // Queues of videos to listen to later
+	tokens   *auth.Store      // Valid tokens, nil when auth is off
+	signer   *auth.Signer     // Signs content links
+	signAll  bool             // Sign every link, not only the ones that must be
+	channels *channelCache    // Channels of videos, for access checks
+
+	prefetches chan prefetch      // Queued videos waiting to be fetched
+	ctx        context.Context    // Cancelled when the server is closed
+	cancel     context.CancelFunc // Closes the server
+
+	mu  sync.RWMutex
+	set *settings // Replaced as a whole on reload
+
+	feedLimiter    *ratelimit.Limiter // Requests to /rss/
+	contentLimiter *ratelimit.Limiter // Requests to /content/
+	bytesQuota     *ratelimit.Quota   // Bytes served from /content/ per day
+	downloadsQuota *ratelimit.Quota   // Downloads started from /content/ per day
+
+	feedCache *feedCache // Generated feeds
+
+	client    *upstream.Client // Requests to upstream sites
+	urlClient *upstream.Client // Requests to feed and media urls clients pass in
+	cookies   *cookies.Store   // Cookie jars, nil when auth is off
 }
 
 const (
 	rssPath     string = "/rss"
 	contentPath string = "/content"
+	jobsPath    string = "/jobs"
+	statusPath  string = "/status"
+
+	chaptersPath   string = "/chapters"
+	transcriptPath string = "/transcript"
+	queuePath      string = "/queue"
 
-	rssPathSlash     string = rssPath + "/"
-	contentPathSlash string = contentPath + "/"
+	rssPathSlash        string = rssPath + "/"
+	contentPathSlash    string = contentPath + "/"
+	jobsPathSlash       string = jobsPath + "/"
+	chaptersPathSlash   string = chaptersPath + "/"
+	transcriptPathSlash string = transcriptPath + "/"
+	queuePathSlash      string = queuePath + "/"
+
+	jobEventsName string = "events" // Server-Sent Events stream of job updates
 
 	ytPrefix string = "youtube/" // Youtube prefix
+
+	// Query parameters meant for the reflector rather than upstream.
+	sponsorBlockParam string = "sponsorblock"
+	albumParam        string = "album"
+	profileParam      string = "profile"
+
+	// How long in-flight requests get to finish on shutdown.
+	shutdownTimeout = 10 * time.Second
+
+	// Keeps proxies from closing idle event streams.
+	eventKeepalive = 15 * time.Second
 )
 
-func NewServer(addr, datadir, ytdl, ytdlFlags string, maxndf int) (*State, error) {
-	logger.Println("addr", addr)
-	logger.Println("data", datadir)
-	logger.Println("youtube-dl", ytdl)
-	logger.Println("youtube-dl flags", ytdlFlags)
-	logger.Println("max num data files", maxndf)
+func NewServer(cfg Config) (*State, error) {
+	logger.Println("addr", cfg.Addr)
+	logger.Println("data", cfg.DataDir)
+	logger.Printf("downloaders %+v", cfg.Downloaders)
+	logger.Printf("postprocess %+v", cfg.Postprocess)
+	logger.Println("max num data files", cfg.MaxNumDataFiles)
+	logger.Println("task timeout", cfg.TaskTimeout)
+	logger.Println("settings for", len(cfg.Feeds), "feeds")
+	logger.Printf("merged feeds %v", cfg.Merges)
+	logger.Printf("local dirs %+v, polled every %s", cfg.LocalDirs, cfg.LocalPoll)
+	logger.Println("max queue length", cfg.QueueMax)
+	logger.Println("auth", cfg.Auth)
+	logger.Println("sign urls", cfg.SignURLs, "for", cfg.SignTTL)
+	logger.Println("access list", cfg.Access != nil)
+	logger.Printf("feed rate %s, content rate %s", cfg.FeedRate, cfg.ContentRate)
+	logger.Printf("daily quotas of %d bytes and %d downloads", cfg.DailyBytes, cfg.DailyDownloads)
+	logger.Printf("public url %v, trusted proxies %v", cfg.PublicURL, cfg.TrustedProxies)
+	logger.Printf("feeds cached for %s, served stale for %s", cfg.FeedTTL, cfg.FeedStale)
+	logger.Printf("upstream %+v", cfg.Upstream)
+	s, err := newState(cfg, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.setUpFeeds(cfg, cfg.LocalPoll); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.setUpAuth(cfg); err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.prefetches = make(chan prefetch, maxPrefetches)
+	s.feedLimiter = ratelimit.NewLimiter(cfg.FeedRate)
+	s.contentLimiter = ratelimit.NewLimiter(cfg.ContentRate)
+	s.bytesQuota = ratelimit.NewQuota(cfg.DailyBytes)
+	s.downloadsQuota = ratelimit.NewQuota(cfg.DailyDownloads)
+	s.feedCache = newFeedCache(cfg.FeedTTL, cfg.FeedStale)
+	go s.handlePrefetches()
+	return s, nil
+}
+
+// Sets up what every command needs. Unless download is set the fetcher
+// only looks things up.
+func newState(cfg Config, download bool) (*State, error) {
+	set, err := newSettings(cfg, cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
 
-	fetcherdir := filepath.Join(datadir, "fetcher")
+	fetcherdir := FetcherPath(cfg.DataDir)
 	if err := os.MkdirAll(fetcherdir, util.DefaultDirPerm); err != nil {
 		return nil, err
 	}
 
-	fetcher, err := content.NewFetcher(fetcherdir, ytdl, ytdlFlags, maxndf)
+	client, err := upstream.New(cfg.Upstream)
 	if err != nil {
 		return nil, err
 	}
+	cfg.Postprocess.Client = client
+	urlClient := client
+	if !cfg.AllowPrivateURLs {
+		urlClient = client.PublicOnly()
+	}
+	cfg.Postprocess.DirectClient = urlClient
 
+	var downloaders []content.Downloader
+	for _, dcfg := range cfg.Downloaders {
+		dcfg.Client = client
+		d, err := content.NewDownloader(dcfg)
+		if err != nil {
+			return nil, err
+		}
+		downloaders = append(downloaders, d)
+	}
+	var fetcher *content.Fetcher
+	if download {
+		fetcher, err = content.NewFetcher(fetcherdir, downloaders, cfg.Postprocess, cfg.MaxNumDataFiles, cfg.TaskTimeout)
+	} else {
+		fetcher, err = content.NewLookupFetcher(fetcherdir, downloaders, cfg.Postprocess)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 	return &State{
-		addr:    addr,
-		fetcher: fetcher,
+		addr:     cfg.Addr,
+		fetcher:  fetcher,
+		signAll:  cfg.SignURLs,
+		channels: newChannelCache(),
+
+		ctx:    ctx,
+		cancel: cancel,
+
+		set: set,
+
+		client:    client,
+		urlClient: urlClient,
 	}, nil
 }
 
+// Adds what feeds are made from and link to.
+func (s *State) setUpFeeds(cfg Config, poll time.Duration) error {
+	queues, err := queue.NewStore(filepath.Join(cfg.DataDir, "queues"), cfg.QueueMax)
+	if err != nil {
+		return err
+	}
+	// Links to the media of reflected feeds are signed either way.
+	signer, err := auth.NewSigner(SigningKeysPath(cfg.DataDir))
+	if err != nil {
+		return err
+	}
+	s.queues = queues
+	s.signer = signer
+	s.local = local.NewLibrary(cfg.LocalDirs, poll)
+	return nil
+}
+
+// Adds the tokens and cookie jars when auth is on.
+func (s *State) setUpAuth(cfg Config) error {
+	if !cfg.Auth {
+		return nil
+	}
+	tokens, err := auth.NewStore(TokensPath(cfg.DataDir))
+	if err != nil {
+		return err
+	}
+	jars, err := cookies.NewStore(CookiesPath(cfg.DataDir))
+	if err != nil {
+		return err
+	}
+	s.tokens, s.cookies = tokens, jars
+	return nil
+}
+
+// Where the tokens are kept in a data directory.
+func TokensPath(dataDir string) string {
+	return filepath.Join(dataDir, "tokens.json")
+}
+
+// Runs until the listener fails or SIGINT or SIGTERM shut the server down.
 func (s *State) Run() error {
-	http.HandleFunc("/", s.handleDefault)
-	http.HandleFunc(rssPathSlash, s.handleRSS)
-	http.HandleFunc(contentPathSlash, s.handleContent)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleDefault)
+	mux.HandleFunc(rssPathSlash, s.limited(s.feedLimiter, s.handleRSS))
+	mux.HandleFunc(contentPathSlash, s.limited(s.contentLimiter, s.metered(s.handleContent)))
+	mux.HandleFunc(jobsPath, s.handleJobs)
+	mux.HandleFunc(jobsPathSlash, s.handleJobs)
+	mux.HandleFunc(statusPath, s.handleStatus)
+	mux.HandleFunc(chaptersPathSlash, s.handleChapters)
+	mux.HandleFunc(transcriptPathSlash, s.handleTranscript)
+	mux.HandleFunc(queuePathSlash, s.handleQueue)
+
+	srv := &http.Server{Addr: s.addr, Handler: s.authorize(mux)}
+
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigC)
+	errC := make(chan error, 1)
+	go func() {
+		logger.Printf("listening on %s", s.addr)
+		errC <- srv.ListenAndServe()
+	}()
 
-	logger.Printf("listening on %s", s.addr)
-	return http.ListenAndServe(s.addr, nil)
+	select {
+	case err := <-errC:
+		s.Close()
+		return err
+	case sig := <-sigC:
+		logger.Printf("received %s, shutting down", sig)
+	}
+
+	s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(ctx)
 }
 
 func (s *State) handleDefault(w http.ResponseWriter, r *http.Request) {
@@ -73,46 +331,204 @@ func (s *State) handleError(w http.ResponseWriter, r *http.Request, status int)
 	w.WriteHeader(status)
 
 	switch status {
+	case http.StatusBadRequest:
+		fmt.Fprint(w, "400 rss-reflector bad request")
 	case http.StatusUnauthorized:
 		fmt.Fprint(w, "401 rss-reflector unauthorized")
+	case http.StatusForbidden:
+		fmt.Fprint(w, "403 rss-reflector forbidden")
 	case http.StatusNotFound:
 		fmt.Fprint(w, "404 rss-reflector not found")
+	case http.StatusMethodNotAllowed:
+		fmt.Fprint(w, "405 rss-reflector method not allowed")
+	case http.StatusTooManyRequests:
+		fmt.Fprint(w, "429 rss-reflector too many requests")
 	case http.StatusInternalServerError:
 		fmt.Fprint(w, "500 rss-reflector internal server error")
+	case http.StatusGatewayTimeout:
+		fmt.Fprint(w, "504 rss-reflector download timed out")
 	}
 }
 
-func (s *State) handleRSS(w http.ResponseWriter, r *http.Request) {
-	logger.Printf("handleRSS request %+v", r)
-	qPath := strings.TrimPrefix(r.URL.Path, rssPathSlash)
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	go handleEvents(ctx, cancel, w.(http.CloseNotifier), "handleRSS")
+func (s *State) handleStatus(w http.ResponseWriter, r *http.Request) {
+	resp := struct {
+		Downloaders []content.DownloaderStatus `json:"downloaders"`
+	}{
+		Downloaders: s.fetcher.Downloaders(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Print(err)
+	}
+}
 
-	reqHost := r.Host
-	if fwdHost := r.Header.Get("x-forwarded-host"); fwdHost != "" {
-		reqHost = strings.Split(fwdHost, ",")[0]
+// /jobs, /jobs/<id> and live updates at /jobs/events.
+func (s *State) handleJobs(w http.ResponseWriter, r *http.Request) {
+	qPath := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, jobsPath), "/")
+	if qPath == jobEventsName {
+		s.handleJobEvents(w, r)
+		return
 	}
-	logger.Printf("handleRSS request from host %s", reqHost)
-	if strings.HasPrefix(qPath, ytPrefix) {
-		reqPrePath := path.Join(contentPath, ytPrefix)
-		rssStr, err := rss.GenYoutubeRSS(ctx, strings.TrimPrefix(qPath, ytPrefix), r.URL.RawQuery, reqHost, reqPrePath)
+
+	var resp interface{} = s.fetcher.Jobs()
+	if qPath != "" {
+		id, err := strconv.ParseInt(qPath, 10, 64)
 		if err != nil {
+			s.handleError(w, r, http.StatusNotFound)
+			return
+		}
+		job, ok := s.fetcher.Job(id)
+		if !ok {
+			s.handleError(w, r, http.StatusNotFound)
+			return
+		}
+		resp = job
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Print(err)
+	}
+}
+
+func (s *State) handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.handleError(w, r, http.StatusInternalServerError)
+		return
+	}
+	updates, unsubscribe := s.fetcher.SubscribeJobs()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Start with the current state so clients don't need a separate request.
+	for _, job := range s.fetcher.Jobs() {
+		if err := writeJobEvent(w, job); err != nil {
 			logger.Print(err)
-			s.handleError(w, r, http.StatusInternalServerError)
 			return
 		}
-		if _, err := w.Write([]byte(rssStr)); err != nil {
-			logger.Print(err.Error())
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case job := <-updates:
+			if err := writeJobEvent(w, job); err != nil {
+				logger.Print(err)
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
 		}
-	} else {
-		s.handleError(w, r, http.StatusNotFound)
+		flusher.Flush()
+	}
+}
+
+func writeJobEvent(w http.ResponseWriter, job content.JobStatus) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: job\ndata: %s\n\n", data)
+	return err
+}
+
+func (s *State) handleRSS(w http.ResponseWriter, r *http.Request) {
+	logger.Printf("handleRSS request %+v", r)
+	qPath := strings.TrimPrefix(r.URL.Path, rssPathSlash)
+	base := s.baseURL(r)
+	logger.Printf("handleRSS request for %s", base.String())
+	rawQuery := r.URL.RawQuery
+	token := tokenName(r)
+	s.serveRSS(w, r, func(ctx context.Context) (string, error) {
+		return s.generateRSS(withTokenName(ctx, token), base, qPath, rawQuery)
+	})
+}
+
+// Generates the feed at qPath under /rss/.
+func (s *State) generateRSS(ctx context.Context, base url.URL, qPath, rawQuery string) (string, error) {
+	switch {
+	case strings.HasPrefix(qPath, ytPrefix):
+		return s.youtubeRSS(ctx, base, strings.TrimPrefix(qPath, ytPrefix), rawQuery)
+	case qPath == feedName:
+		return s.reflectedRSS(ctx, base, rawQuery)
+	case strings.HasPrefix(qPath, queueName+"/"):
+		return s.queueRSS(ctx, base, strings.TrimPrefix(qPath, queueName+"/"), rawQuery)
+	case strings.HasPrefix(qPath, localPrefix):
+		return s.localRSS(ctx, base, strings.TrimPrefix(qPath, localPrefix), rawQuery)
+	case qPath == mergeName || strings.HasPrefix(qPath, mergeName+"/"):
+		return s.mergedRSS(ctx, base, strings.TrimPrefix(strings.TrimPrefix(qPath, mergeName), "/"), rawQuery)
+	}
+	return "", errNotFound
+}
+
+func (s *State) youtubeRSS(ctx context.Context, base url.URL, qPath, rawQuery string) (string, error) {
+	upstreamQuery, own := splitQuery(rawQuery)
+	if err := s.checkYoutubeFeed(qPath, upstreamQuery); err != nil {
+		return "", err
+	}
+	feedQuery, linkQuery := splitFeedParams(own)
+	feed := s.feedConfig(upstreamQuery)
+	filter, err := feed.filter(feedQuery)
+	if err != nil {
+		return "", err
+	}
+	if ctx, err = s.feedCookies(ctx, feed, linkQuery); err != nil {
+		return "", err
+	}
+	opts := s.feedOptions(base, linkQuery)
+	opts.Rewrite = feed.Rewrite
+	opts.Filter = filter
+	return rss.GenYoutubeRSS(ctx, qPath, upstreamQuery, opts)
+}
+
+// How every reflected feed links back to the reflector.
+func (s *State) feedOptions(base url.URL, linkQuery url.Values) rss.Options {
+	// Only the feed itself says where its items come from.
+	linkQuery.Del(fromParam)
+	opts := rss.Options{
+		Base:              base,
+		ContentPrePath:    path.Join(contentPath, ytPrefix),
+		ChaptersPrePath:   path.Join(chaptersPath, ytPrefix),
+		TranscriptPrePath: path.Join(transcriptPath, ytPrefix),
+		LinkQuery:         linkQuery,
+		AlbumParam:        albumParam,
+		EnclosureType:     s.fetcher.ProfileMimeType(linkQuery.Get(profileParam)),
+		MediaType:         s.mediaType(linkQuery),
+		HasChapters:       s.hasChapters(linkQuery),
+		TranscriptLang:    s.transcriptLang(linkQuery),
+		Lookup:            s.videoDetails,
+		Sign:              s.signLink,
+		Client:            s.client,
+	}
+	if s.settings().access != nil {
+		opts.SourceParam = fromParam
+	}
+	return opts
+}
+
+// The type video content links serve, by downloader and profile.
+func (s *State) mediaType(linkQuery url.Values) func(videoURL string) string {
+	return func(videoURL string) string {
+		req, ok := s.youtubeRequest(videoURL, linkQuery)
+		if !ok {
+			return ""
+		}
+		return s.fetcher.MimeType(req)
 	}
 }
 
-func handleEvents(ctx context.Context, cancel context.CancelFunc, w http.CloseNotifier, tag string) {
+// closeC must be obtained before the handler returns.
+func handleEvents(ctx context.Context, cancel context.CancelFunc, closeC <-chan bool, tag string) {
 	select {
-	case <-w.CloseNotify():
+	case <-closeC:
 		logger.Printf("(%s) client prematurely closed request", tag)
 	case <-ctx.Done():
 		// noop, cancel is called later
@@ -123,30 +539,127 @@ func handleEvents(ctx context.Context, cancel context.CancelFunc, w http.CloseNo
 
 func (s *State) handleContent(w http.ResponseWriter, r *http.Request) {
 	qPath := strings.TrimPrefix(r.URL.Path, contentPathSlash)
+	// Local files start no downloads, so their links aren't signed.
+	if strings.HasPrefix(qPath, localPrefix) {
+		s.handleLocalContent(w, r, strings.TrimPrefix(qPath, localPrefix))
+		return
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	go handleEvents(ctx, cancel, w.(http.CloseNotifier), "handleContent")
-
-	if strings.HasPrefix(qPath, ytPrefix) {
-		qUrl := url.URL{
-			Scheme:   "https",
-			Host:     "www.youtube.com",
-			Path:     strings.TrimPrefix(qPath, ytPrefix),
-			RawQuery: r.URL.RawQuery,
+	go handleEvents(ctx, cancel, w.(http.CloseNotifier).CloseNotify(), "handleContent")
+
+	if req, ok := s.contentRequest(qPath, r.URL.RawQuery); ok {
+		if err := s.verify(contentPath, req, r.URL.Query()); err != nil {
+			logger.Printf("content %s: %s", req.Uri, err)
+			s.handleError(w, r, http.StatusForbidden)
+			return
 		}
-		path, err := s.fetcher.SubmitTask(ctx, content.TaskRequest{
-			Src: content.YoutubeSource,
-			Uri: qUrl.String(),
-		})
-		defer s.fetcher.FinishTask()
+		if err := s.checkContent(ctx, req, r.URL.Query().Get(fromParam)); err != nil {
+			s.handleForbidden(w, r, err)
+			return
+		}
+		if err := s.checkCookies(r, req, true); err != nil {
+			s.handleForbidden(w, r, err)
+			return
+		}
+		keys := s.clientKeys(r)
+		cached, err := s.fetcher.Cached(req)
 		if err != nil {
 			logger.Print(err)
+		}
+		if !cached {
+			if err := s.startDownload(keys); err != nil {
+				logger.Print(err)
+				s.handleTooMany(w, r, err.wait)
+				return
+			}
+		}
+		path, err := s.fetcher.SubmitTask(ctx, req)
+		defer s.fetcher.FinishTask()
+		if errors.Is(err, context.DeadlineExceeded) {
+			logger.Print(err)
+			s.handleError(w, r, http.StatusGatewayTimeout)
+			return
+		} else if errors.Is(err, upstream.ErrNotPublic) {
+			s.handleForbidden(w, r, err)
+			return
+		} else if err != nil {
+			logger.Print(err)
 			s.handleError(w, r, http.StatusInternalServerError)
 			return
 		}
 
+		if t := content.MimeType(path); t != "" {
+			w.Header().Set("Content-Type", t)
+		}
 		http.ServeFile(w, r, path)
 	} else {
 		s.handleError(w, r, http.StatusNotFound)
 	}
 }
+
+// The fetcher request for a content path.
+func (s *State) contentRequest(qPath, rawQuery string) (content.TaskRequest, bool) {
+	if qPath == feedName {
+		return s.feedRequest(rawQuery)
+	}
+	if !strings.HasPrefix(qPath, ytPrefix) {
+		return content.TaskRequest{}, false
+	}
+	upstreamQuery, own := splitQuery(rawQuery)
+	qUrl := url.URL{
+		Scheme:   "https",
+		Host:     "www.youtube.com",
+		Path:     strings.TrimPrefix(qPath, ytPrefix),
+		RawQuery: upstreamQuery,
+	}
+	return s.youtubeRequest(qUrl.String(), own)
+}
+
+func (s *State) youtubeRequest(uri string, own url.Values) (content.TaskRequest, bool) {
+	return s.ownRequest(content.YoutubeSource, uri, own)
+}
+
+// Applies the reflector's own parameters to a request.
+func (s *State) ownRequest(src content.Source, uri string, own url.Values) (content.TaskRequest, bool) {
+	req := content.TaskRequest{
+		Src:          src,
+		Uri:          uri,
+		SponsorBlock: isTrue(own.Get(sponsorBlockParam)),
+		Album:        own.Get(albumParam),
+		Profile:      own.Get(profileParam),
+	}
+	if req.Profile != "" && !s.fetcher.HasProfile(req.Profile) {
+		return content.TaskRequest{}, false
+	}
+	var ok bool
+	if req.Cookies, ok = s.linkCookies(own); !ok {
+		return content.TaskRequest{}, false
+	}
+	return req, true
+}
+
+// Separates the reflector's own query parameters from upstream's.
+func splitQuery(rawQuery string) (string, url.Values) {
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery, url.Values{}
+	}
+	own := url.Values{}
+	for _, param := range append([]string{sponsorBlockParam, albumParam, profileParam, tokenParam, cookiesParam, fromParam,
+		auth.SigParam, auth.ExpiresParam, auth.KeyIDParam}, feedParams...) {
+		if v, ok := q[param]; ok {
+			own[param] = v
+			delete(q, param)
+		}
+	}
+	if len(own) == 0 {
+		return rawQuery, own
+	}
+	return q.Encode(), own
+}
+
+func isTrue(v string) bool {
+	b, _ := strconv.ParseBool(v)
+	return b
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nlsun/rss-reflector/pkg/content"
+)
+
+// Where the signing keys are kept in a data directory.
+func SigningKeysPath(dataDir string) string {
+	return filepath.Join(dataDir, "signing-keys.json")
+}
+
+// Signs a content, chapters or transcript link, see rss.Options.Sign.
+func (s *State) signLink(u *url.URL) error {
+	// Handlers see the query sorted.
+	q := u.Query()
+	u.RawQuery = q.Encode()
+	endpoint, req, ok := s.linkRequest(u.Path, u.RawQuery)
+	if !ok {
+		return fmt.Errorf("signing %s: not a content link", u)
+	} else if !s.signed(req, q.Get(fromParam)) {
+		return nil
+	}
+	var expires time.Time
+	if ttl := s.settings().signTTL; ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+	params, err := s.signer.Sign(signedFields(endpoint, req, q.Get(fromParam)), expires)
+	if err != nil {
+		return err
+	}
+	for k, v := range params {
+		q.Set(k, v)
+	}
+	u.RawQuery = q.Encode()
+	return nil
+}
+
+// The request a link to one of the signed endpoints makes, and the endpoint.
+func (s *State) linkRequest(p, rawQuery string) (string, content.TaskRequest, bool) {
+	var req content.TaskRequest
+	ok := false
+	switch {
+	case strings.HasPrefix(p, contentPathSlash):
+		req, ok = s.contentRequest(strings.TrimPrefix(p, contentPathSlash), rawQuery)
+		return contentPath, req, ok
+	case strings.HasPrefix(p, chaptersPathSlash):
+		req, ok = s.contentRequest(strings.TrimPrefix(p, chaptersPathSlash), rawQuery)
+		return chaptersPath, req, ok
+	case strings.HasPrefix(p, transcriptPathSlash):
+		req, _, ok = s.transcriptRequest(strings.TrimPrefix(p, transcriptPathSlash), rawQuery)
+		return transcriptPath, req, ok
+	}
+	return "", req, false
+}
+
+// Nil if req needs no signature.
+func (s *State) verify(endpoint string, req content.TaskRequest, q url.Values) error {
+	from := q.Get(fromParam)
+	if !s.signed(req, from) {
+		return nil
+	}
+	return s.signer.Verify(signedFields(endpoint, req, from), q.Get, time.Now())
+}
+
+// Links fetching any url, with cookies or naming their feed are always
+// signed.
+func (s *State) signed(req content.TaskRequest, from string) bool {
+	return s.signAll || req.Src == content.FeedSource || req.Cookies != nil || from != ""
+}
+
+// Everything the request is cached by, the feed it came from and the
+// endpoint, so a signed link can't be turned into another.
+func signedFields(endpoint string, req content.TaskRequest, from string) []string {
+	sponsorBlock, jar := "", ""
+	if req.SponsorBlock {
+		sponsorBlock = "1"
+	}
+	if req.Cookies != nil {
+		jar = req.Cookies.Name
+	}
+	return []string{endpoint, string(req.Src), req.Uri, sponsorBlock, req.Album, req.Profile, jar, from}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/nlsun/rss-reflector/pkg/auth"
+)
+
+func newSigningState(t *testing.T) *State {
+	signer, err := auth.NewSigner(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &State{signer: signer, signAll: true, set: &settings{}}
+}
+
+func TestSignLink(t *testing.T) {
+	s := newSigningState(t)
+	tests := []struct {
+		name    string
+		link    string
+		changed url.Values // Set on the signed link
+		other   string     // The signed link's query on this path instead
+	}{
+		{
+			name:    "content",
+			link:    "/content/youtube/watch?v=abcdefghijk&t=5&album=Show&sponsorblock=true",
+			changed: url.Values{"album": {"Other"}},
+			other:   "/chapters/youtube/watch",
+		},
+		{
+			name:    "unsigned upstream query",
+			link:    "/content/youtube/watch?v=abcdefghijk",
+			changed: url.Values{"x": {"1"}},
+		},
+		{
+			name:    "chapters",
+			link:    "/chapters/youtube/watch?v=abcdefghijk",
+			changed: url.Values{"sponsorblock": {"true"}},
+			other:   "/content/youtube/watch",
+		},
+		{
+			name:    "transcript",
+			link:    "/transcript/youtube/abcdefghijk.vtt?album=Show",
+			changed: url.Values{"album": {"Other"}},
+			other:   "/transcript/youtube/otherididid.vtt",
+		},
+		{
+			name:    "item of a playlist",
+			link:    "/content/youtube/watch?v=abcdefghijk&from=playlist_id%3DPL1",
+			changed: url.Values{"from": {"playlist_id=PL2"}},
+		},
+		{
+			name:    "feed media",
+			link:    "/content/feed?url=https%3A%2F%2Fexample.com%2Fep1.mp3",
+			changed: url.Values{"url": {"https://example.com/ep2.mp3"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := url.Parse(tt.link)
+			if err := s.signLink(u); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.verifyLink(u.Path, u.RawQuery); err != nil {
+				t.Fatalf("signed link %s: %v", u, err)
+			}
+			q := u.Query()
+			for k, v := range tt.changed {
+				q[k] = v
+			}
+			if err := s.verifyLink(u.Path, q.Encode()); !errors.Is(err, auth.ErrBadSig) {
+				t.Errorf("changed link: got %v, want %v", err, auth.ErrBadSig)
+			}
+			if tt.other == "" {
+				return
+			}
+			if err := s.verifyLink(tt.other, u.RawQuery); !errors.Is(err, auth.ErrBadSig) {
+				t.Errorf("signature on %s: got %v, want %v", tt.other, err, auth.ErrBadSig)
+			}
+		})
+	}
+}
+
+// Verifies a link the way its handler does.
+func (s *State) verifyLink(p, rawQuery string) error {
+	endpoint, req, ok := s.linkRequest(p, rawQuery)
+	if !ok {
+		return errors.New("not a link")
+	}
+	q, _ := url.ParseQuery(rawQuery)
+	return s.verify(endpoint, req, q)
+}
+
+func TestMediaLinksAlwaysSigned(t *testing.T) {
+	s := newSigningState(t)
+	s.signAll = false
+	if err := s.verifyLink("/content/youtube/watch", "v=abcdefghijk"); err != nil {
+		t.Errorf("unsigned YouTube link with signing off: %v", err)
+	}
+	if err := s.verifyLink("/content/youtube/watch", "v=abcdefghijk&from=playlist_id%3DPL1"); !errors.Is(err, auth.ErrUnsigned) {
+		t.Errorf("unsigned link naming its feed: got %v, want %v", err, auth.ErrUnsigned)
+	}
+	media := "url=" + url.QueryEscape("http://169.254.169.254/latest/meta-data")
+	if err := s.verifyLink("/content/feed", media); !errors.Is(err, auth.ErrUnsigned) {
+		t.Errorf("unsigned media link: got %v, want %v", err, auth.ErrUnsigned)
+	}
+	u := &url.URL{Path: "/content/feed", RawQuery: "url=" + url.QueryEscape("https://example.com/ep1.mp3")}
+	if err := s.signLink(u); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.verifyLink(u.Path, u.RawQuery); err != nil {
+		t.Errorf("media link from a feed: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/nlsun/rss-reflector/pkg/content"
+	"github.com/nlsun/rss-reflector/pkg/transcript"
+)
+
+// Content type and writer of each transcript format, by file extension.
+var transcriptFormats = map[string]struct {
+	contentType string
+	write       func(*transcript.Transcript, io.Writer) error
+}{
+	".vtt": {"text/vtt; charset=utf-8", (*transcript.Transcript).WriteVTT},
+	".srt": {"application/srt; charset=utf-8", (*transcript.Transcript).WriteSRT},
+	".txt": {"text/plain; charset=utf-8", (*transcript.Transcript).WriteText},
+}
+
+// Serves /transcript/youtube/<id>.<ext> for downloaded items.
+func (s *State) handleTranscript(w http.ResponseWriter, r *http.Request) {
+	req, ext, ok := s.transcriptRequest(strings.TrimPrefix(r.URL.Path, transcriptPathSlash), r.URL.RawQuery)
+	if !ok {
+		s.handleError(w, r, http.StatusNotFound)
+		return
+	}
+	if err := s.verify(transcriptPath, req, r.URL.Query()); err != nil {
+		logger.Printf("transcript %s: %s", req.Uri, err)
+		s.handleError(w, r, http.StatusForbidden)
+		return
+	}
+	if err := s.checkCookies(r, req, true); err != nil {
+		s.handleForbidden(w, r, err)
+		return
+	}
+	t, _, err := s.fetcher.Transcript(req)
+	if err != nil {
+		logger.Print(err)
+		s.handleError(w, r, http.StatusInternalServerError)
+		return
+	}
+	if t == nil {
+		s.handleError(w, r, http.StatusNotFound)
+		return
+	}
+	format := transcriptFormats[ext]
+	w.Header().Set("Content-Type", format.contentType)
+	if err := format.write(t, w); err != nil {
+		logger.Print(err)
+	}
+}
+
+// The download a transcript comes from, and the extension asked for.
+func (s *State) transcriptRequest(qPath, rawQuery string) (content.TaskRequest, string, bool) {
+	if !strings.HasPrefix(qPath, ytPrefix) {
+		return content.TaskRequest{}, "", false
+	}
+	name := strings.TrimPrefix(qPath, ytPrefix)
+	ext := path.Ext(name)
+	_, ok := transcriptFormats[ext]
+	id := strings.TrimSuffix(name, ext)
+	if !ok || id == "" || strings.Contains(id, "/") {
+		return content.TaskRequest{}, "", false
+	}
+	_, own := splitQuery(rawQuery)
+	req, ok := s.youtubeRequest(youtubeWatchURL(id), own)
+	return req, ext, ok
+}
+
+// The same url the feed links to, so it maps to the same cache entry.
+func youtubeWatchURL(id string) string {
+	u := url.URL{
+		Scheme:   "https",
+		Host:     "www.youtube.com",
+		Path:     "/watch",
+		RawQuery: url.Values{"v": {id}}.Encode(),
+	}
+	return u.String()
+}
+
+// Only stored transcripts are advertised.
+func (s *State) transcriptLang(linkQuery url.Values) func(videoURL string) string {
+	return func(videoURL string) string {
+		req, ok := s.youtubeRequest(videoURL, linkQuery)
+		if !ok {
+			return ""
+		}
+		meta, err := s.fetcher.ItemMeta(req)
+		if err != nil {
+			logger.Print(err)
+			return ""
+		}
+		if meta == nil {
+			return ""
+		}
+		return meta.TranscriptLang
+	}
+}
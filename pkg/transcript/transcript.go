@@ -0,0 +1,143 @@
+package transcript
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A single caption, times are in seconds.
+type Cue struct {
+	Start float64
+	End   float64
+	Text  string
+}
+
+type Transcript struct {
+	Cues []Cue
+}
+
+var (
+	// 00:01:02.345 --> 00:01:04.000 align:start position:0%
+	timingRe = regexp.MustCompile(`^((?:\d+:)?\d{2}:\d{2}[.,]\d{3})\s+-->\s+((?:\d+:)?\d{2}:\d{2}[.,]\d{3})`)
+	// Inline timestamps and styling, e.g. <00:00:01.439><c> word</c>
+	tagRe = regexp.MustCompile(`<[^>]*>`)
+)
+
+// Parses WebVTT, collapsing YouTube's rolling captions.
+func ParseVTT(r io.Reader) (*Transcript, error) {
+	t := &Transcript{}
+	sc := bufio.NewScanner(r)
+	var cur *Cue
+	var lines []string
+	flush := func() {
+		if cur != nil {
+			t.add(*cur, lines)
+		}
+		cur, lines = nil, nil
+	}
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if m := timingRe.FindStringSubmatch(line); m != nil {
+			flush()
+			cur = &Cue{Start: parseTimestamp(m[1]), End: parseTimestamp(m[2])}
+		} else if line == "" {
+			flush()
+		} else if cur != nil {
+			lines = append(lines, line)
+		}
+	}
+	flush()
+	return t, sc.Err()
+}
+
+// Adds a cue, leaving out lines that repeat the end of the previous cue.
+func (t *Transcript) add(c Cue, lines []string) {
+	var prev []string
+	if n := len(t.Cues); n > 0 {
+		prev = strings.Split(t.Cues[n-1].Text, "\n")
+	}
+	var text []string
+	for _, line := range lines {
+		line = strings.TrimSpace(tagRe.ReplaceAllString(line, ""))
+		if line == "" || contains(prev, line) {
+			continue
+		}
+		text = append(text, line)
+	}
+	if len(text) == 0 {
+		return
+	}
+	c.Text = strings.Join(text, "\n")
+	t.Cues = append(t.Cues, c)
+}
+
+func contains(lines []string, line string) bool {
+	for _, l := range lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
+
+// Moves every cue through mapTime. Cues mapTime drops are removed.
+func (t *Transcript) Remap(mapTime func(float64) (float64, bool)) {
+	cues := t.Cues[:0]
+	for _, c := range t.Cues {
+		start, ok := mapTime(c.Start)
+		if !ok {
+			continue
+		}
+		end, _ := mapTime(c.End)
+		if end <= start {
+			continue
+		}
+		cues = append(cues, Cue{Start: start, End: end, Text: c.Text})
+	}
+	t.Cues = cues
+}
+
+func (t *Transcript) WriteVTT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, "WEBVTT\n\n")
+	for _, c := range t.Cues {
+		fmt.Fprintf(bw, "%s --> %s\n%s\n\n", formatTimestamp(c.Start, "."), formatTimestamp(c.End, "."), c.Text)
+	}
+	return bw.Flush()
+}
+
+func (t *Transcript) WriteSRT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for i, c := range t.Cues {
+		fmt.Fprintf(bw, "%d\n%s --> %s\n%s\n\n", i+1, formatTimestamp(c.Start, ","), formatTimestamp(c.End, ","), c.Text)
+	}
+	return bw.Flush()
+}
+
+// Plain text, one line per caption line.
+func (t *Transcript) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, c := range t.Cues {
+		fmt.Fprintln(bw, c.Text)
+	}
+	return bw.Flush()
+}
+
+func parseTimestamp(s string) float64 {
+	s = strings.Replace(s, ",", ".", 1)
+	var secs float64
+	for _, part := range strings.Split(s, ":") {
+		n, _ := strconv.ParseFloat(part, 64)
+		secs = secs*60 + n
+	}
+	return secs
+}
+
+func formatTimestamp(secs float64, msSep string) string {
+	ms := int64(secs*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, msSep, ms%1000)
+}
@@ -0,0 +1,104 @@
+package transcript
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Rolling captions as YouTube generates them: each cue repeats the line
+// before it.
+const youtubeVTT = `WEBVTT
+Kind: captions
+Language: en
+
+00:00:00.000 --> 00:00:02.500 align:start position:0%
+hello<00:00:00.500><c> there</c>
+
+00:00:02.500 --> 00:00:02.510 align:start position:0%
+hello there
+ 
+
+00:00:02.510 --> 00:00:05.000 align:start position:0%
+hello there
+general<00:00:03.000><c> kenobi</c>
+
+NOTE a comment
+
+cue-id
+01:02.000 --> 01:03,500
+<v Speaker>last words</v>
+`
+
+func TestParseVTT(t *testing.T) {
+	tr, err := ParseVTT(strings.NewReader(youtubeVTT))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Cue{
+		{Start: 0, End: 2.5, Text: "hello there"},
+		{Start: 2.51, End: 5, Text: "general kenobi"},
+		{Start: 62, End: 63.5, Text: "last words"},
+	}
+	if !reflect.DeepEqual(tr.Cues, want) {
+		t.Errorf("got %+v, want %+v", tr.Cues, want)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tr := &Transcript{Cues: []Cue{
+		{Start: 1.5, End: 3723.25, Text: "one"},
+		{Start: 3723.25, End: 3724, Text: "two\nlines"},
+	}}
+	var vtt, srt, text bytes.Buffer
+	if err := tr.WriteVTT(&vtt); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.WriteSRT(&srt); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	if want := "WEBVTT\n\n00:00:01.500 --> 01:02:03.250\none\n\n01:02:03.250 --> 01:02:04.000\ntwo\nlines\n\n"; vtt.String() != want {
+		t.Errorf("vtt %q, want %q", vtt.String(), want)
+	}
+	if want := "1\n00:00:01,500 --> 01:02:03,250\none\n\n2\n01:02:03,250 --> 01:02:04,000\ntwo\nlines\n\n"; srt.String() != want {
+		t.Errorf("srt %q, want %q", srt.String(), want)
+	}
+	if want := "one\ntwo\nlines\n"; text.String() != want {
+		t.Errorf("text %q, want %q", text.String(), want)
+	}
+
+	// What is written parses back the same.
+	back, err := ParseVTT(&vtt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back.Cues, tr.Cues) {
+		t.Errorf("parsed back %+v", back.Cues)
+	}
+}
+
+func TestRemap(t *testing.T) {
+	tr := &Transcript{Cues: []Cue{
+		{Start: 0, End: 10, Text: "kept"},
+		{Start: 12, End: 18, Text: "cut"},
+		{Start: 20, End: 30, Text: "moved"},
+	}}
+	// Cut 10 to 20.
+	tr.Remap(func(t float64) (float64, bool) {
+		switch {
+		case t < 10:
+			return t, true
+		case t < 20:
+			return 10, false
+		}
+		return t - 10, true
+	})
+	want := []Cue{{Start: 0, End: 10, Text: "kept"}, {Start: 10, End: 20, Text: "moved"}}
+	if !reflect.DeepEqual(tr.Cues, want) {
+		t.Errorf("got %+v, want %+v", tr.Cues, want)
+	}
+}
@@ -0,0 +1,80 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestPublicOnly(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	c, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	for _, u := range []string{srv.URL, "http://localhost:1/"} {
+		req, _ := http.NewRequest(http.MethodGet, u, nil)
+		if _, err := c.PublicOnly().Do(req); !errors.Is(err, ErrNotPublic) {
+			t.Errorf("%s: got %v, want %v", u, err, ErrNotPublic)
+		}
+	}
+}
+
+func TestPublicOnlyDial(t *testing.T) {
+	if err := dialPublicOnly("tcp", "127.0.0.1:80", nil); !errors.Is(err, ErrNotPublic) {
+		t.Errorf("got %v, want %v", err, ErrNotPublic)
+	}
+	if err := dialPublicOnly("tcp", "[2606:2800:220:1:248:1893:25c8:1946]:443", nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPublicOnlyRedirect(t *testing.T) {
+	c, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc := c.PublicOnly().clients[0]
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://169.254.169.254/latest/meta-data", nil)
+	if err := hc.CheckRedirect(req, nil); !errors.Is(err, ErrNotPublic) {
+		t.Errorf("redirect to the metadata service: got %v, want %v", err, ErrNotPublic)
+	}
+}
@@ -0,0 +1,329 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nlsun/rss-reflector/pkg/cookies"
+	"github.com/nlsun/rss-reflector/pkg/log"
+)
+
+var logger = log.DefaultLogger
+
+// Reported while requests to a host are stopped after it kept failing.
+var ErrCircuitOpen = errors.New("upstream circuit open")
+
+// Longest Retry-After that is waited for rather than given up on.
+const maxRetryAfter = 30 * time.Second
+
+type Config struct {
+	UserAgent string // Sent with every request, empty for Go's default
+	// How long to wait for the response headers, 0 for no limit.
+	Timeout time.Duration
+	// Timeouts of particular hosts, which also apply to their subdomains.
+	HostTimeouts map[string]time.Duration
+	// http, https or socks5 proxy urls used in turn.
+	Proxies []string
+	// Retries of GET and HEAD requests after network errors, 5xx and 429.
+	Retries int
+	// Wait before the first retry, doubled for every further one.
+	RetryWait time.Duration
+	// Failures in a row that stop requests to the host, 0 for never.
+	BreakerFailures int
+	BreakerCooldown time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		UserAgent:       "rss-reflector",
+		Timeout:         30 * time.Second,
+		Retries:         2,
+		RetryWait:       time.Second,
+		BreakerFailures: 5,
+		BreakerCooldown: time.Minute,
+	}
+}
+
+// Makes requests to upstream sites. A nil Client uses DefaultConfig.
+type Client struct {
+	cfg     Config
+	clients []*http.Client // One per proxy, or one that connects directly
+	next    *uint32        // Index of the next client to use
+	breaker *breaker
+	// Refuse hosts that aren't on the internet.
+	publicOnly bool
+}
+
+var defaultClient *Client
+
+func init() {
+	var err error
+	if defaultClient, err = New(DefaultConfig()); err != nil {
+		panic(err)
+	}
+}
+
+func New(cfg Config) (*Client, error) {
+	c := &Client{cfg: cfg, next: new(uint32), breaker: newBreaker(cfg.BreakerFailures, cfg.BreakerCooldown)}
+	for _, p := range cfg.Proxies {
+		u, err := url.Parse(p)
+		if err != nil {
+			return nil, fmt.Errorf("bad proxy %q: %w", p, err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("bad proxy %q: want http, https or socks5", p)
+		}
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.Proxy = http.ProxyURL(u)
+		c.clients = append(c.clients, &http.Client{Transport: t})
+	}
+	if len(c.clients) == 0 {
+		c.clients = []*http.Client{{}}
+	}
+	return c, nil
+}
+
+// A client that returns redirects rather than following them.
+func (c *Client) WithoutRedirects() *Client {
+	c = c.orDefault()
+	nc := *c
+	nc.clients = nil
+	for _, hc := range c.clients {
+		hc := *hc
+		hc.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+		nc.clients = append(nc.clients, &hc)
+	}
+	return &nc
+}
+
+// A client for urls from clients of the reflector, which refuses addresses
+// that aren't on the internet. It shares the proxies and the breaker.
+func (c *Client) PublicOnly() *Client {
+	c = c.orDefault()
+	nc := *c
+	nc.clients = nil
+	nc.publicOnly = true
+	for _, hc := range c.clients {
+		hc := *hc
+		if hc.Transport == nil {
+			// Check the address actually dialed.
+			t := http.DefaultTransport.(*http.Transport).Clone()
+			t.DialContext = (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+				Control:   dialPublicOnly,
+			}).DialContext
+			hc.Transport = t
+		}
+		checkRedirect := hc.CheckRedirect
+		hc.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if err := checkPublicHost(req.Context(), req.URL.Hostname()); err != nil {
+				return err
+			}
+			if checkRedirect != nil {
+				return checkRedirect(req, via)
+			} else if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		}
+		nc.clients = append(nc.clients, &hc)
+	}
+	return &nc
+}
+
+// The proxy for a request made by something else, such as youtube-dl.
+func (c *Client) Proxy() string {
+	c = c.orDefault()
+	if len(c.cfg.Proxies) == 0 {
+		return ""
+	}
+	return c.cfg.Proxies[c.turn()]
+}
+
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	c = c.orDefault()
+	host := req.URL.Hostname()
+	if err := c.breaker.allow(host, time.Now()); err != nil {
+		return nil, err
+	}
+	if c.publicOnly {
+		if err := checkPublicHost(req.Context(), host); err != nil {
+			return nil, err
+		}
+	}
+	retryable := req.Method == http.MethodGet || req.Method == http.MethodHead
+	wait := c.cfg.RetryWait
+	for attempt := 0; ; attempt++ {
+		resp, err := c.try(req, host)
+		if err == nil && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			c.breaker.success(host)
+			return resp, nil
+		}
+		// The caller giving up says nothing about the host.
+		if req.Context().Err() != nil {
+			return resp, err
+		}
+		if !retryable || attempt >= c.cfg.Retries {
+			c.breaker.failure(host, time.Now())
+			return resp, err
+		}
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			if ra := retryAfter(resp); ra > wait && ra <= maxRetryAfter {
+				wait = ra
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		logger.Printf("retrying %s in %s: %s", req.URL, wait, reason)
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		wait *= 2
+	}
+}
+
+func (c *Client) try(req *http.Request, host string) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	r := req.WithContext(ctx)
+	r.Header = req.Header.Clone()
+	if c.cfg.UserAgent != "" && r.Header.Get("User-Agent") == "" {
+		r.Header.Set("User-Agent", c.cfg.UserAgent)
+	}
+	if jar := cookies.FromContext(req.Context()); jar != nil {
+		for _, ck := range jar.For(req.URL) {
+			r.AddCookie(ck)
+		}
+	}
+	var timer *time.Timer
+	if t := c.timeout(host); t > 0 {
+		timer = time.AfterFunc(t, cancel)
+	}
+	resp, err := c.clients[c.turn()].Do(r)
+	if timer != nil {
+		timer.Stop()
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+func (c *Client) turn() int {
+	return int((atomic.AddUint32(c.next, 1) - 1) % uint32(len(c.clients)))
+}
+
+func (c *Client) timeout(host string) time.Duration {
+	for h, t := range c.cfg.HostTimeouts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return t
+		}
+	}
+	return c.cfg.Timeout
+}
+
+func (c *Client) orDefault() *Client {
+	if c == nil {
+		return defaultClient
+	}
+	return c
+}
+
+// Seconds or an HTTP date, zero if there is none.
+func retryAfter(resp *http.Response) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// Releases the request's context once the body is done with.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// Stops requests to hosts that keep failing.
+type breaker struct {
+	mu       sync.Mutex
+	max      int           // Failures in a row that open the circuit, 0 for never
+	cooldown time.Duration // How long it stays open
+	hosts    map[string]*hostState
+}
+
+type hostState struct {
+	failures  int
+	openUntil time.Time
+}
+
+func newBreaker(max int, cooldown time.Duration) *breaker {
+	return &breaker{max: max, cooldown: cooldown, hosts: map[string]*hostState{}}
+}
+
+// After the cooldown the next failure opens the circuit again.
+func (b *breaker) allow(host string, now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if st, ok := b.hosts[host]; ok && now.Before(st.openUntil) {
+		return fmt.Errorf("%w for %s until %s", ErrCircuitOpen, host, st.openUntil.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (b *breaker) success(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.hosts, host)
+}
+
+func (b *breaker) failure(host string, now time.Time) {
+	if b.max <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st, ok := b.hosts[host]
+	if !ok {
+		st = &hostState{}
+		b.hosts[host] = st
+	}
+	st.failures++
+	if st.failures >= b.max {
+		st.openUntil = now.Add(b.cooldown)
+		logger.Printf("%s failed %d times in a row, stopping requests until %s", host, st.failures, st.openUntil.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,41 @@
+package upstream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBreakerIgnoresCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	c, err := New(Config{Retries: 3, RetryWait: time.Hour, BreakerFailures: 1, BreakerCooldown: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cancelled while waiting to retry.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if _, err := c.Do(req.WithContext(ctx)); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := c.breaker.allow("127.0.0.1", time.Now()); err != nil {
+		t.Fatalf("cancelled request opened the circuit: %s", err)
+	}
+
+	c.cfg.Retries = 0
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err := c.breaker.allow("127.0.0.1", time.Now()); err == nil {
+		t.Error("failed request left the circuit closed")
+	}
+}
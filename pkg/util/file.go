@@ -11,6 +11,10 @@ import (
 const (
 	DefaultFilePerm os.FileMode = 0644
 	DefaultDirPerm  os.FileMode = 0755
+
+	// For secrets only the owner may read.
+	PrivateFilePerm os.FileMode = 0600
+	PrivateDirPerm  os.FileMode = 0700
 )
 
 func FileExists(path string) (bool, error) {